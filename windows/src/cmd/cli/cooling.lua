@@ -1,4 +1,8 @@
-local tag = 'idle'
+local util = require 'util'
+local stand = require 'stand'
+
+stand.setTag('idle')
+
 local lastTele = {}
 
 local function onConnect()
@@ -7,25 +11,10 @@ local function onConnect()
    tare()
 end
 
-local function printTelemetry(t)
-   local str = string.format(
-      'Скорость:%d:Момент:%.02f:Тяга:%d:Об/мин:%d:Ток:%.02f:Напряжение:%.02f:КПД:%.02f',
-      t.Throttle, 
-      (t.Load2 + t.Load3) / 2, 
-      t.Load1, 
-      t.MotorRPM, 
-      t.MotorI, 
-      t.MotorU, 
-      t.MotorP
-   )
-   print(str)
-end
-
 local function onTelemetry(t)
-   t.Tag = tag
    lastTele = t
    if t.Throttle % 100 == 0 then
-      printTelemetry(t)
+      util.printSummary(t)
    end
 end
 
@@ -40,7 +29,7 @@ local function coolingMode()
    local delay = 100 -- Задержка между шагами разгона
    local targetThrottle = 1200 -- Целевое значение газа
 
-   tag = 'cooling'
+   stand.setTag('cooling')
 
    -- Включаем вентиляторы на 100%
    chiller(1, 100)
@@ -72,4 +61,8 @@ return {
    OnConnect    = onConnect,
    OnTelemetry  = onTelemetry,
    OnDisconnect = onDisconnect,
+   Params       = {
+      { name = 'sampleRate', type = 'int', default = 10, min = 1, max = 1000000,
+        description = 'период телеметрии, мс' },
+   },
 }
\ No newline at end of file
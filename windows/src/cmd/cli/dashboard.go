@@ -0,0 +1,392 @@
+package main
+
+import (
+	"io"
+	"os"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+	"strings"
+	"strconv"
+
+	"golang.org/x/term"
+
+	"dronmotors/dmetrics/internal/device"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+type gauge struct {
+	label, key string
+	history []float64
+}
+
+var dashboardGauges = []struct{ label, key string }{
+	{ "rpm", "motorRPM" },
+	{ "current", "motorI" },
+	{ "power", "motorP" },
+	{ "thrust", "load1" },
+	{ "temp1", "temp1" },
+	{ "temp2", "temp2" },
+}
+
+const (
+	dashboardHistory = 60 // sparkline points, one per redraw
+	dashboardLogLines = 100
+)
+
+// dashboard is the full-screen view of a run: sparklines of the main
+// readings, tag, link health, limit warnings and the script log. It is a
+// bus subscriber and the console of the run logger.
+type dashboard struct {
+	sync.Mutex
+
+	out io.Writer
+	title string
+	limits map[string]float64 // key -> max
+	stop func(device.Device) // emergency stop
+	abort func()
+	warn func(string)
+
+	dev device.Device
+	gauges []*gauge
+	values map[string]string // latest record
+	over map[string]bool // limits exceeded now
+	records int // since the last redraw
+	rate float64
+	last time.Time
+
+	log []string
+	partial string
+
+	state *term.State
+	done chan struct{}
+	closed bool
+}
+
+// parseLimits parses key=max pairs
+func parseLimits(args []string) (map[string]float64, error) {
+	res := map[string]float64{}
+	for _, arg := range args {
+		key, value, ok := strings.Cut(arg, "=")
+		if !ok {
+			return nil, errorf("limit %q: expected key=max", arg)
+		}
+
+		v, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return nil, errorf("limit %q: %v", arg, err)
+		}
+
+		res[key] = v
+	}
+	return res, nil
+}
+
+func newDashboard(title string, limits map[string]float64) *dashboard {
+	d := &dashboard{
+		out: os.Stdout,
+		title: title,
+		limits: limits,
+		values: map[string]string{},
+		over: map[string]bool{},
+		done: make(chan struct{}),
+	}
+
+	for _, g := range dashboardGauges {
+		d.gauges = append(d.gauges, &gauge{ label: g.label, key: g.key })
+	}
+
+	return d
+}
+
+// Open switches the terminal to the dashboard, starts redrawing and reading
+// hotkeys
+func (d *dashboard) Open(interval time.Duration) error {
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		return errorf("dashboard needs a terminal")
+	}
+
+	state, err := term.MakeRaw(fd)
+	if err != nil {
+		return err
+	}
+
+	d.state = state
+	io.WriteString(d.out, "\x1b[?1049h\x1b[?25l") // alternate screen, hide cursor
+
+	go d.keys()
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-d.done:
+				return
+			case <-ticker.C:
+				d.redraw(interval)
+			}
+		}
+	}()
+
+	return nil
+}
+
+// Close restores the terminal, the log goes to the console again
+func (d *dashboard) Close() {
+	d.Lock()
+	defer d.Unlock()
+
+	if d.closed {
+		return
+	}
+
+	d.closed = true
+	close(d.done)
+
+	if d.state != nil {
+		io.WriteString(d.out, "\x1b[?25h\x1b[?1049l")
+		term.Restore(int(os.Stdin.Fd()), d.state)
+	}
+}
+
+// keys handles hotkeys until Close. A pending read of stdin can not be
+// interrupted, what it returns after Close is ignored.
+func (d *dashboard) keys() {
+	buf := make([]byte, 16)
+	for {
+		n, err := os.Stdin.Read(buf)
+		if err != nil {
+			return
+		}
+
+		select {
+		case <-d.done:
+			return
+		default:
+		}
+
+		for _, c := range buf[:n] {
+			switch c {
+			case ' ', 's':
+				d.Lock()
+				dev := d.dev
+				d.Unlock()
+
+				if dev != nil {
+					d.stop(dev)
+				}
+			case 'q', 3: // ^C, no signal in raw mode
+				d.abort()
+			}
+		}
+	}
+}
+
+// Write takes log lines
+func (d *dashboard) Write(p []byte) (int, error) {
+	d.Lock()
+	defer d.Unlock()
+
+	if d.closed {
+		return d.out.Write(p)
+	}
+
+	lines := strings.Split(d.partial + string(p), "\n")
+	d.partial = lines[len(lines) - 1]
+
+	d.log = append(d.log, lines[:len(lines) - 1]...)
+	if n := len(d.log); n > dashboardLogLines {
+		d.log = d.log[n - dashboardLogLines:]
+	}
+
+	return len(p), nil
+}
+
+func (d *dashboard) OnConnect(dev device.Device) {
+	d.Lock()
+	d.dev = dev
+	d.Unlock()
+}
+
+func (d *dashboard) OnTelemetry(dev device.Device, t device.Telemetry) {
+	for _, w := range d.observe(t) {
+		d.warn(w) // the log comes back to Write, not under the lock
+	}
+}
+
+// observe keeps the latest record, returns the limits it exceeds first
+func (d *dashboard) observe(t device.Telemetry) []string {
+	d.Lock()
+	defer d.Unlock()
+
+	keys, values := t.AsKeys(), t.AsValues()
+	for i, k := range keys {
+		if i < len(values) {
+			d.values[k] = values[i]
+		}
+	}
+
+	d.records++
+	d.last = time.Now()
+
+	var warnings []string
+	for _, key := range d.limitKeys() {
+		max := d.limits[key]
+		v, err := strconv.ParseFloat(d.values[key], 64)
+		if over := err == nil && v > max; over && !d.over[key] {
+			warnings = append(warnings, fmt.Sprintf("%s %s above limit %g", key, d.values[key], max))
+			d.over[key] = true
+		} else if !over {
+			d.over[key] = false
+		}
+	}
+
+	return warnings
+}
+
+func (d *dashboard) OnDisconnect(dev device.Device) {
+	d.Lock()
+	d.dev = nil
+	d.Unlock()
+}
+
+func (d *dashboard) limitKeys() []string {
+	var keys []string
+	for k := range d.limits {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+var sparks = []rune("▁▂▃▄▅▆▇█")
+
+func sparkline(h []float64) string {
+	if len(h) == 0 {
+		return ""
+	}
+
+	lo, hi := h[0], h[0]
+	for _, v := range h {
+		if v < lo {
+			lo = v
+		}
+		if v > hi {
+			hi = v
+		}
+	}
+
+	var b strings.Builder
+	for _, v := range h {
+		i := 0
+		if hi > lo {
+			i = int((v - lo) / (hi - lo) * float64(len(sparks) - 1) + 0.5)
+		}
+		b.WriteRune(sparks[i])
+	}
+	return b.String()
+}
+
+func (d *dashboard) redraw(interval time.Duration) {
+	d.Lock()
+	defer d.Unlock()
+
+	if d.closed {
+		return
+	}
+
+	width, height, err := term.GetSize(int(os.Stdout.Fd()))
+	if err != nil || height == 0 {
+		width, height = 80, 24
+	}
+
+	d.rate = float64(d.records) / interval.Seconds()
+	d.records = 0
+
+	var lines []string
+	add := func(t string, args ...interface{}) {
+		lines = append(lines, fmt.Sprintf(t, args...))
+	}
+
+	link := "waiting for device"
+	if d.dev != nil {
+		age := "-"
+		if !d.last.IsZero() {
+			age = time.Since(d.last).Round(time.Millisecond).String()
+		}
+		link = fmt.Sprintf("%s %.0f rec/s, last %s ago", d.dev.Id(), d.rate, age)
+		if res, err := d.dev.Control("status"); err == nil {
+			if m, ok := res.(map[string]interface{}); ok {
+				link += fmt.Sprintf(", frames %v, errors %v", m["frames"], m["errors"])
+			}
+		}
+	}
+
+	add("\x1b[1m%s\x1b[0m  tag: %s", d.title, d.values["tag"])
+	add("link: %s", link)
+	add("")
+
+	for _, g := range d.gauges {
+		value := d.values[g.key]
+		if v, err := strconv.ParseFloat(value, 64); err == nil {
+			g.history = append(g.history, v)
+			if n := len(g.history); n > dashboardHistory {
+				g.history = g.history[n - dashboardHistory:]
+			}
+		}
+
+		limit := ""
+		if max, ok := d.limits[g.key]; ok {
+			limit = fmt.Sprintf("  max %g", max)
+		}
+
+		n, h := dashboardHistory, g.history
+		if width - 40 < n && width > 40 {
+			n = width - 40
+		}
+		if len(h) > n {
+			h = h[len(h) - n:]
+		}
+
+		line := fmt.Sprintf("%-8s %10s  %-*s%s", g.label, value, n, sparkline(h), limit)
+		if d.over[g.key] {
+			line = "\x1b[31m" + line + "\x1b[0m"
+		}
+		lines = append(lines, line)
+	}
+
+	add("")
+	for _, key := range d.limitKeys() {
+		if d.over[key] {
+			add("\x1b[31mWARN %s %s above limit %g\x1b[0m", key, d.values[key], d.limits[key])
+		}
+	}
+
+	add("\x1b[7m [space] emergency stop  [q] abort \x1b[0m")
+
+	// the rest of the screen is the log tail
+	if n := height - len(lines) - 1; n > 0 {
+		tail := d.log
+		if len(tail) > n {
+			tail = tail[len(tail) - n:]
+		}
+		for _, line := range tail {
+			if r := []rune(line); width > 0 && len(r) > width {
+				line = string(r[:width])
+			}
+			lines = append(lines, line)
+		}
+	}
+
+	var b strings.Builder
+	b.WriteString("\x1b[H")
+	for _, line := range lines {
+		b.WriteString(line + "\x1b[K\r\n")
+	}
+	b.WriteString("\x1b[J")
+	io.WriteString(d.out, b.String())
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"time"
+	"strings"
+	"testing"
+)
+
+type record struct {
+	keys, values []string
+	ts time.Time
+}
+
+func (r record) Id() string { return "test" }
+func (r record) AsKeys() []string { return r.keys }
+func (r record) AsValues() []string { return r.values }
+func (r record) TimeStamp() time.Time { return r.ts }
+func (r record) String() string { return strings.Join(r.values, ",") }
+
+func TestParseLimits(t *testing.T) {
+	tests := []struct {
+		args []string
+		want map[string]float64
+		err bool
+	}{
+		{ nil, map[string]float64{}, false },
+		{ []string{ "temp1=80", "motorI=40.5" }, map[string]float64{ "temp1": 80, "motorI": 40.5 }, false },
+		{ []string{ "temp1" }, nil, true },
+		{ []string{ "temp1=hot" }, nil, true },
+	}
+
+	for _, tt := range tests {
+		got, err := parseLimits(tt.args)
+		if tt.err {
+			if err == nil {
+				t.Errorf("%q: no error", tt.args)
+			}
+			continue
+		} else if err != nil {
+			t.Errorf("%q: %v", tt.args, err)
+			continue
+		}
+
+		if len(got) != len(tt.want) {
+			t.Errorf("%q: got %v, want %v", tt.args, got, tt.want)
+		}
+		for k, v := range tt.want {
+			if got[k] != v {
+				t.Errorf("%q: got %v, want %v", tt.args, got, tt.want)
+			}
+		}
+	}
+}
+
+func TestSparkline(t *testing.T) {
+	tests := []struct {
+		h []float64
+		want string
+	}{
+		{ nil, "" },
+		{ []float64{ 5, 5, 5 }, "▁▁▁" },
+		{ []float64{ 0, 7, 3.5 }, "▁█▅" },
+		{ []float64{ 10, 0 }, "█▁" },
+	}
+
+	for _, tt := range tests {
+		if got := sparkline(tt.h); got != tt.want {
+			t.Errorf("%v: got %q, want %q", tt.h, got, tt.want)
+		}
+	}
+}
+
+func TestDashboardLimits(t *testing.T) {
+	d := newDashboard("test", map[string]float64{ "temp1": 80, "motorI": 40 })
+
+	// a warning when a limit is exceeded, not again while it stays so
+	tests := []struct {
+		temp1, motorI string
+		want []string
+	}{
+		{ "70", "10", nil },
+		{ "81", "10", []string{ "temp1 81 above limit 80" } },
+		{ "82", "41", []string{ "motorI 41 above limit 40" } },
+		{ "79", "41", nil },
+		{ "85", "x", []string{ "temp1 85 above limit 80" } },
+	}
+
+	for i, tt := range tests {
+		got := d.observe(record{ keys: []string{ "temp1", "motorI" }, values: []string{ tt.temp1, tt.motorI } })
+		if strings.Join(got, "; ") != strings.Join(tt.want, "; ") {
+			t.Errorf("%d: got %q, want %q", i, got, tt.want)
+		}
+	}
+}
+
+func TestDashboardLog(t *testing.T) {
+	d := newDashboard("test", nil)
+
+	d.Write([]byte("one\ntw"))
+	d.Write([]byte("o\n"))
+	if strings.Join(d.log, ",") != "one,two" || len(d.partial) > 0 {
+		t.Errorf("log %q, partial %q", d.log, d.partial)
+	}
+
+	for i := 0; i < dashboardLogLines; i++ {
+		d.Write([]byte("line\n"))
+	}
+	if len(d.log) != dashboardLogLines || d.log[0] != "line" {
+		t.Errorf("%d lines kept, first %q", len(d.log), d.log[0])
+	}
+
+	// after Close the log goes to the console
+	var b strings.Builder
+	d.out = &b
+	d.Close()
+	d.Write([]byte("bye\n"))
+	if b.String() != "bye\n" {
+		t.Errorf("console got %q", b.String())
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"os"
+	"io"
+
+	"github.com/urfave/cli/v2"
+
+	"dronmotors/dmetrics/internal/analysis"
+)
+
+func readRun(filename string) ([]analysis.Record, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	defer f.Close()
+
+	return analysis.ReadCSV(f)
+}
+
+func analysisOptions(cli *cli.Context) analysis.Options {
+	opts := analysis.DefaultOptions()
+	opts.Window = cli.Int("window")
+	opts.Tolerance = cli.Float64("tolerance")
+	return opts
+}
+
+func (app *App) doAnalyzeCmd(cli *cli.Context) error {
+	filename := "telemetry.csv"
+	if cli.Args().Present() {
+		filename = cli.Args().First()
+	}
+
+	records, err := readRun(filename)
+	if err != nil {
+		return err
+	}
+
+	points := analysis.Table(records, analysisOptions(cli))
+
+	var out io.Writer = os.Stdout
+	if name := cli.String("output"); len(name) > 0 {
+		f, err := os.Create(name)
+		if err != nil {
+			return err
+		}
+
+		defer f.Close()
+		out = f
+	}
+
+	switch cli.String("format") {
+	case "csv":
+		return analysis.WriteCSV(out, points)
+	case "json":
+		return analysis.WriteJSON(out, points)
+	default:
+		return errorf("format %q is not supported", cli.String("format"))
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"os"
+	"strings"
+	"encoding/json"
+
+	"github.com/urfave/cli/v2"
+
+	"dronmotors/dmetrics/internal/analysis"
+)
+
+// loadPoints reads operating points either from a saved point table or
+// datasheet (json) or from raw run telemetry (csv).
+func loadPoints(filename string, opts analysis.Options) ([]analysis.OperatingPoint, error) {
+	if strings.HasSuffix(strings.ToLower(filename), ".json") {
+		f, err := os.Open(filename)
+		if err != nil {
+			return nil, err
+		}
+
+		defer f.Close()
+
+		return analysis.ReadJSON(f)
+	}
+
+	records, err := readRun(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	return analysis.Table(records, opts), nil
+}
+
+func (app *App) doCompareCmd(cli *cli.Context) error {
+	if cli.NArg() != 2 {
+		return errorf("compare: expected <run> <baseline>")
+	}
+
+	tols := analysis.DefaultTolerances()
+	if v := cli.StringSlice("tol"); len(v) > 0 {
+		tols = nil
+		for _, s := range v {
+			if tol, err := analysis.ParseTolerance(s); err != nil {
+				return err
+			} else {
+				tols = append(tols, tol)
+			}
+		}
+	}
+
+	run, err := loadPoints(cli.Args().Get(0), analysisOptions(cli))
+	if err != nil {
+		return err
+	}
+
+	baseline, err := loadPoints(cli.Args().Get(1), analysisOptions(cli))
+	if err != nil {
+		return err
+	}
+
+	report, err := analysis.Compare(run, baseline, cli.String("align"), tols)
+	if err != nil {
+		return err
+	}
+
+	if err := report.WriteText(os.Stdout, cli.Bool("failed")); err != nil {
+		return err
+	}
+
+	if name := cli.String("report"); len(name) > 0 {
+		if err := writeFile(name, func(f *os.File) error {
+			enc := json.NewEncoder(f)
+			enc.SetIndent("", "  ")
+			return enc.Encode(report)
+		}); err != nil {
+			return err
+		}
+	}
+
+	if !report.OK {
+		return exitf(exitTestFailed, "compare: out of tolerance")
+	}
+
+	return nil
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/urfave/cli/v2"
+
+	"dronmotors/dmetrics/internal/analysis"
+)
+
+func writeFile(filename string, write func(f *os.File) error) error {
+	f, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+
+	if err := write(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func (app *App) doDatasheetCmd(cli *cli.Context) error {
+	filename := "telemetry.csv"
+	if cli.Args().Present() {
+		filename = cli.Args().First()
+	}
+
+	records, err := readRun(filename)
+	if err != nil {
+		return err
+	}
+
+	points := analysis.Table(records, analysisOptions(cli))
+	if len(points) == 0 {
+		return errorf("%s: no steady operating points found", filename)
+	}
+
+	sheet := analysis.Datasheet{
+		Motor: cli.String("motor"),
+		Source: filename,
+		Points: points,
+		Curves: analysis.Curves(points, cli.Int("degree"), cli.Float64("level")),
+	}
+
+	dir := cli.String("output")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	if err := writeFile(filepath.Join(dir, "datasheet.json"), func(f *os.File) error {
+		return sheet.WriteJSON(f)
+	}); err != nil {
+		return err
+	}
+
+	if err := writeFile(filepath.Join(dir, "points.csv"), func(f *os.File) error {
+		return analysis.WriteCSV(f, points)
+	}); err != nil {
+		return err
+	}
+
+	for _, c := range sheet.Curves {
+		chart := c.Chart()
+		if len(sheet.Motor) > 0 {
+			chart.Title = sheet.Motor + ": " + chart.Title
+		}
+
+		if err := writeFile(filepath.Join(dir, c.Name + ".svg"), func(f *os.File) error {
+			return chart.WriteSVG(f)
+		}); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"os"
+	"strconv"
+	"strings"
+	"path/filepath"
+
+	"github.com/urfave/cli/v2"
+
+	"dronmotors/dmetrics/internal/plot"
+	"dronmotors/dmetrics/internal/session"
+	"dronmotors/dmetrics/internal/analysis"
+)
+
+// eventMarkers turns events into vertical lines on the ts axis, seconds
+// eventMarkers places events by their device ts, an event without one (it
+// came before the first telemetry record) has no place on the time axis
+func eventMarkers(events []session.Event) []plot.Marker {
+	var res []plot.Marker
+	for _, e := range events {
+		if ts, err := strconv.ParseFloat(e.Ts, 64); err == nil {
+			res = append(res, plot.Marker{ X: ts / 1000, Label: e.Name })
+		}
+	}
+	return res
+}
+
+func (app *App) doPlotCmd(cli *cli.Context) error {
+	filename := "telemetry.csv"
+	if cli.Args().Present() {
+		filename = cli.Args().First()
+	}
+
+	records, err := readRun(filename)
+	if err != nil {
+		return err
+	}
+
+	chart := plot.Chart{
+		Title: filepath.Base(filename),
+		XLabel: "time, s",
+		YLabel: strings.Join(cli.StringSlice("field"), ", "),
+		Width: 1000,
+	}
+
+	for _, name := range cli.StringSlice("field") {
+		value, ok := analysis.RecordFields[name]
+		if !ok {
+			return errorf("plot: no such field %q", name)
+		}
+
+		s := plot.Series{ Name: name, Style: plot.StyleLine }
+		for _, r := range records {
+			s.X = append(s.X, r.Ts / 1000)
+			s.Y = append(s.Y, value(r))
+		}
+
+		chart.Series = append(chart.Series, s)
+	}
+
+	eventsFile := cli.String("events")
+	if !cli.IsSet("events") {
+		eventsFile = filepath.Join(filepath.Dir(filename), "events.csv")
+		if _, err := os.Stat(eventsFile); err != nil {
+			eventsFile = "" // no events recorded
+		}
+	}
+
+	if len(eventsFile) > 0 {
+		events, err := session.ReadEvents(eventsFile)
+		if err != nil {
+			return err
+		}
+		chart.Markers = eventMarkers(events)
+	}
+
+	return writeFile(cli.String("output"), func(f *os.File) error {
+		return chart.WriteSVG(f)
+	})
+}
@@ -0,0 +1,21 @@
+package main
+
+import (
+	"testing"
+
+	"dronmotors/dmetrics/internal/session"
+)
+
+func TestEventMarkers(t *testing.T) {
+	events := []session.Event{
+		{ Name: "before telemetry" },
+		{ Ts: "1500", Name: "prop changed" },
+		{ Ts: "x", Name: "broken" },
+		{ Ts: "0", Name: "start" },
+	}
+
+	got := eventMarkers(events)
+	if len(got) != 2 || got[0].X != 1.5 || got[0].Label != "prop changed" || got[1].X != 0 || got[1].Label != "start" {
+		t.Errorf("got %+v", got)
+	}
+}
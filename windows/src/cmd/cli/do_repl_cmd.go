@@ -3,19 +3,66 @@ package main
 import (
 	"os"
 	"fmt"
+	"time"
 	"context"
 	"strings"
+	"strconv"
+	"encoding/json"
 
 	"github.com/urfave/cli/v2"
 
 	"dronmotors/dmetrics/internal/device"
 	"dronmotors/dmetrics/internal/device/dmsx"
+	"dronmotors/dmetrics/internal/sink"
+	"dronmotors/dmetrics/internal/session"
 
 	dms "dronmotors/dmetrics/internal/script"
 
 	"github.com/chzyer/readline"
 )
 
+// formatReply pretty-prints structured control replies
+func formatReply(v interface{}) string {
+	switch v.(type) {
+	case nil:
+		return "ok"
+	case string:
+		return v.(string)
+	}
+
+	if data, err := json.MarshalIndent(v, "", "  "); err != nil {
+		return fmt.Sprint(v)
+	} else {
+		return string(data)
+	}
+}
+
+// parseMark parses "name key=value ...", numeric values become numbers
+func parseMark(args []string) (dms.Mark, error) {
+	if len(args) == 0 {
+		return dms.Mark{}, errorf("usage: /mark name [key=value ...]")
+	}
+
+	m := dms.Mark{
+		Time: time.Now(),
+		Name: args[0],
+		Data: map[string]interface{}{},
+	}
+
+	for _, arg := range args[1:] {
+		k, v, ok := strings.Cut(arg, "=")
+		if !ok {
+			return dms.Mark{}, errorf("mark: %q is not key=value", arg)
+		} else if n, err := strconv.ParseFloat(v, 64); err == nil {
+			m.Data[k] = n
+		} else {
+			m.Data[k] = v
+		}
+	}
+
+	return m, nil
+}
+
 func (app *App) doReplCmd(cli *cli.Context) error {
 	ctx, cancel := context.WithCancelCause(cli.Context)
 	defer cancel(nil)
@@ -39,13 +86,40 @@ func (app *App) doReplCmd(cli *cli.Context) error {
 
 	defer telefile.Close()
 
-	callbacks := &device.CallbacksWrapper{
+	// telemetry.bin is a text dump, the csv is what plot renders with the
+	// events
+	csvfile, err := sink.NewCSV(cli.String("csv"))
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		if err := csvfile.Close(); err != nil {
+			fmt.Println(err)
+		}
+	}()
+
+	events := &session.Events{}
+	defer func() {
+		if list := events.List(); len(list) > 0 {
+			if err := session.WriteEvents(cli.String("events"), list); err != nil {
+				fmt.Println(err)
+			}
+		}
+	}()
+
+	bus := device.NewBus()
+	defer bus.Close()
+
+	bus.Subscribe(&device.CallbacksWrapper{
 		Connect: func(dev device.Device) {
 			methods := []readline.PrefixCompleterInterface{}
 			for _, method := range dev.Methods() {
 				methods = append(methods, readline.PcItem(method))
 			}
 
+			methods = append(methods, readline.PcItem("/mark"))
+			methods = append(methods, readline.PcItem("/help"))
 			methods = append(methods, readline.PcItem("/quit"))
 			completer := readline.NewPrefixCompleter(
 				methods...
@@ -66,6 +140,18 @@ func (app *App) doReplCmd(cli *cli.Context) error {
 						break
 					} else if line == "/quit" || line == "/exit" {
 						break
+					} else if line == "/help" {
+						for _, sig := range dev.Signatures() {
+							fmt.Printf("%-40s %s\n", sig, sig.Help)
+						}
+						fmt.Printf("%-40s %s\n", "/mark name [key=value ...]", "insert an event marker")
+					} else if args := strings.Fields(line); len(args) >= 1 && args[0] == "/mark" {
+						if m, err := parseMark(args[1:]); err != nil {
+							fmt.Println(err)
+						} else {
+							events.Mark(m)
+							fmt.Println("ok")
+						}
 					} else if args := strings.Fields(line); len(args) >= 1 {
 						cmd_args := []dms.Value{}
 						for _, v := range args[1:] {
@@ -75,22 +161,37 @@ func (app *App) doReplCmd(cli *cli.Context) error {
 						if res, err := dev.Control(args[0], cmd_args...); err != nil {
 							fmt.Println(err)
 						} else {
-							fmt.Println(res)
+							fmt.Println(formatReply(res))
 						}
 					}
 				}
 			})
 		},
-		Telemetry: func(dev device.Device, t device.Telemetry) {
-			fmt.Fprintf(telefile, "%s\n", t)
-		},
 		Disconnect: func(dev device.Device) {
 			stdin.Close()
-			cancel(errorf("disconnected"))
+			cancel(errDisconnected)
 		},
+	}, device.SubscribeOptions{})
+
+	bus.Subscribe(&device.CallbacksWrapper{
+		Telemetry: func(dev device.Device, t device.Telemetry) {
+			events.Observe(t)
+			fmt.Fprintf(telefile, "%s\n", t)
+		},
+	}, device.SubscribeOptions{})
+
+	bus.Subscribe(sink.Callbacks(csvfile, func(err error) {
+		fmt.Println(err)
+	}), device.SubscribeOptions{})
+
+	queue, err := app.newQueue(cli, bus)
+	if err != nil {
+		return err
 	}
 
-	dev := dmsx.NewDevice(cli.String("port"), callbacks)
+	defer queue.Close()
+
+	dev := dmsx.NewDevice(cli.String("port"), queue)
 	if err := dev.StartUp(ctx); err != nil {
 		return err
 	} else {
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"os"
+	"fmt"
+	"context"
+
+	"crypto/tls"
+	"crypto/x509"
+
+	"github.com/urfave/cli/v2"
+
+	"dronmotors/dmetrics/internal/bridge"
+	"dronmotors/dmetrics/internal/device"
+	"dronmotors/dmetrics/internal/device/dmsx"
+	"dronmotors/dmetrics/internal/server"
+	"dronmotors/dmetrics/internal/metrics"
+	"dronmotors/dmetrics/internal/session"
+	"dronmotors/dmetrics/pkg/mqtt"
+
+	dms "dronmotors/dmetrics/internal/script"
+)
+
+func (app *App) doServeCmd(cli *cli.Context) error {
+	ctx, cancel := context.WithCancelCause(cli.Context)
+	defer cancel(nil)
+
+	bus := device.NewBus()
+	defer bus.Close()
+
+	bus.Subscribe(&device.CallbacksWrapper{
+		Connect: func(dev device.Device) {
+			dev.Control("sample", dms.NewValue(cli.Int("rate")))
+		},
+		Disconnect: func(dev device.Device) {
+			cancel(errDisconnected)
+		},
+	}, device.SubscribeOptions{})
+
+	queue, err := app.newQueue(cli, bus)
+	if err != nil {
+		return err
+	} else {
+		defer queue.Close()
+	}
+
+	// the bridge is there before any run starts
+	var br *bridge.Bridge
+
+	dev := dmsx.NewDevice(cli.String("port"), queue)
+	srv := server.New(ctx, dev, bus, os.Stdout, server.Options{
+		Scripts: cli.String("scripts"),
+		Runs: cli.String("runs"),
+		Lib: cli.StringSlice("lib"),
+		Influx: cli.String("influx"),
+		InfluxToken: cli.String("influx-token"),
+		Hosts: cli.StringSlice("allow-host"),
+		OnEvent: func(e session.Event) {
+			if br != nil {
+				br.Event(e)
+			}
+		},
+	})
+
+	srv.Metrics().Register(metrics.QueueCollector("reader", queue))
+
+	// the broker settings are checked before the device is started
+	broker := cli.String("mqtt")
+	if cli.Bool("mqtt-control") && !cli.Bool("mqtt-insecure-control") && !mqtt.Secure(broker) {
+		return exitf(exitError, "--mqtt-control needs a tls:// broker, or --mqtt-insecure-control to accept commands over a plain connection")
+	}
+
+	var mqttTLS *tls.Config
+	if ca := cli.String("mqtt-ca"); len(ca) > 0 {
+		if !mqtt.Secure(broker) {
+			return exitf(exitError, "--mqtt-ca needs a tls:// broker")
+		} else if mqttTLS, err = loadCA(ca); err != nil {
+			return err
+		}
+	}
+
+	if err := dev.StartUp(ctx); err != nil {
+		return err
+	} else {
+		defer dev.TearDown()
+	}
+
+	if len(broker) > 0 {
+		opts := bridge.Options{
+			Broker: broker,
+			Prefix: cli.String("mqtt-prefix"),
+			Id: cli.String("mqtt-id"),
+			Username: cli.String("mqtt-user"),
+			Password: cli.String("mqtt-password"),
+			TLS: mqttTLS,
+			Logger: srv.Logger(),
+		}
+		if cli.Bool("mqtt-control") {
+			opts.Control = srv.Control
+			opts.InsecureControl = cli.Bool("mqtt-insecure-control")
+		}
+
+		if br, err = bridge.New(ctx, dev, opts); err != nil {
+			return err
+		} else {
+			defer br.Close()
+		}
+
+		bus.Subscribe(br, device.SubscribeOptions{ Rate: cli.Float64("mqtt-rate") })
+		scheme := "mqtt"
+		if mqtt.Secure(broker) {
+			scheme = "mqtts"
+		}
+		fmt.Printf("%s: publishing to %s://%s/%s\n", dev.Id(), scheme, mqtt.Address(broker), br.Topic())
+	}
+
+	// a running script is stopped while the device is still there
+	defer srv.Close()
+
+	fmt.Printf("%s: serving on http://%s\n", dev.Id(), cli.String("listen"))
+	if err := srv.ListenAndServe(cli.String("listen")); err != nil {
+		return err
+	}
+
+	return context.Cause(ctx)
+}
+
+// loadCA trusts the pem certificates of a file instead of the system roots,
+// e.g. of a lab broker
+func loadCA(filename string) (*tls.Config, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, errorf("%s: no pem certificates", filename)
+	}
+	return &tls.Config{ RootCAs: pool }, nil
+}
@@ -1,40 +1,124 @@
 package main
 
 import (
+	"os"
 	"fmt"
+	"time"
 	"context"
 
 	"github.com/urfave/cli/v2"
 
+	"dronmotors/dmetrics/internal/sink"
 	"dronmotors/dmetrics/internal/device"
 	"dronmotors/dmetrics/internal/device/dmsx"
 
 	dms "dronmotors/dmetrics/internal/script"
 )
 
-func (app *App) doTeleCmd(cli *cli.Context) error {
+func (app *App) doTeleCmd(cli *cli.Context) (res error) {
 	ctx, cancel := context.WithCancelCause(cli.Context)
 	defer cancel(nil)
 
-	callbacks := &device.CallbacksWrapper{
+	bus := device.NewBus()
+	defer bus.Close()
+
+	bus.Subscribe(&device.CallbacksWrapper{
 		Connect: func(dev device.Device) {
 			dev.Control("sample", dms.NewValue(cli.Int("rate")))
 			app.Go(func() {
-				select {
-				case <-ctx.Done():
-					break
-				}
+				<-ctx.Done()
 			})
 		},
-		Telemetry: func(dev device.Device, t device.Telemetry) {
-			fmt.Println(t)
-		},
 		Disconnect: func(dev device.Device) {
-			cancel(errorf("disconnected"))
+			cancel(errDisconnected)
 		},
+	}, device.SubscribeOptions{})
+
+	type output struct {
+		name string
+		w sink.Sink
+	}
+
+	var outputs []output
+	if name := cli.String("output"); len(name) > 0 {
+		w, err := sink.NewCSV(name)
+		if err != nil {
+			return err
+		}
+		outputs = append(outputs, output{ name, w })
+	}
+
+	if target := cli.String("influx"); len(target) > 0 {
+		w, err := sink.NewInflux(target, sink.InfluxOptions{ Token: cli.String("influx-token") })
+		if err != nil {
+			return err
+		}
+		outputs = append(outputs, output{ target, w })
+	}
+
+	for _, o := range outputs {
+		// full rate, own queue: writes must not hold up the display. The
+		// queue blocks rather than loses records, the reader queue absorbs
+		// a slow sink.
+		q := device.NewQueue(sink.Callbacks(o.w, func(err error) {
+			cancel(err)
+		}), device.QueueOptions{ Size: device.DefaultQueueOptions().Size, Policy: device.PolicyBlock })
+		unsubscribe := bus.Subscribe(q, device.SubscribeOptions{})
+
+		// the queue is drained before the sink is closed. A failed flush
+		// loses records, it fails the command even if stopped by the user.
+		o := o
+		defer func() {
+			unsubscribe()
+			q.Close()
+			if err := o.w.Close(); err != nil {
+				if res == nil || res == context.Canceled {
+					res = exitf(exitError, "%s: %v", o.name, err)
+				} else {
+					fmt.Printf("%s: %v\n", o.name, err)
+				}
+			}
+
+			fmt.Printf("%s: %s\n", o.name, q.Stats())
+		}()
 	}
 
-	dev := dmsx.NewDevice(cli.String("port"), callbacks)
+	view := &teleView{
+		out: os.Stdout,
+		columns: cli.StringSlice("columns"),
+		stats: cli.Bool("stats"),
+		live: cli.Bool("live"),
+	}
+
+	if rate := cli.Float64("display-rate"); rate > 0 {
+		view.interval = time.Duration(float64(time.Second) / rate)
+	}
+
+	// statistics need every record, plain values only the displayed ones
+	var viewOpts device.SubscribeOptions
+	if !view.stats && !view.live {
+		viewOpts.Rate = cli.Float64("display-rate")
+	}
+
+	bus.Subscribe(&device.CallbacksWrapper{
+		Telemetry: func(dev device.Device, t device.Telemetry) {
+			if err := view.Add(t); err != nil {
+				cancel(err)
+			}
+		},
+	}, viewOpts)
+
+	queue, err := app.newQueue(cli, bus)
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		queue.Close()
+		fmt.Printf("telemetry: %s\n", queue.Stats())
+	}()
+
+	dev := dmsx.NewDevice(cli.String("port"), queue)
 	if err := dev.StartUp(ctx); err != nil {
 		return err
 	} else {
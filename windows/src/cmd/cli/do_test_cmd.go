@@ -1,21 +1,30 @@
 package main
 
 import (
+	"io"
 	"os"
+	"fmt"
+	"time"
+	"errors"
 	"context"
+	"net"
+	"net/http"
+	"sync/atomic"
+	"path/filepath"
+	"text/tabwriter"
 
 	"github.com/urfave/cli/v2"
 
 	"dronmotors/dmetrics/internal/device"
 	"dronmotors/dmetrics/internal/device/dmsx"
+	"dronmotors/dmetrics/internal/metrics"
+	"dronmotors/dmetrics/internal/session"
 
+	dms "dronmotors/dmetrics/internal/script"
 	"dronmotors/dmetrics/internal/script/lua"
 )
 
 func (app *App) doTestCmd(cli *cli.Context) error {
-	ctx, cancel := context.WithCancelCause(cli.Context)
-	defer cancel(nil)
-
 	filename := "default.lua"
 	if cli.Args().Present() {
 		filename = cli.Args().First()
@@ -26,17 +35,375 @@ func (app *App) doTestCmd(cli *cli.Context) error {
 		return err
 	}
 
-	ls, err := lua.NewScript(string(filedata), app.argsMap(cli))
+	params := app.argsMap(cli)
+
+	var clock dms.Clock
+	var vclock *dms.VirtualClock
+	if cli.Bool("dry-run") || cli.Bool("sim") || cli.IsSet("replay") {
+		vclock = dms.NewVirtualClock(time.Now())
+		clock = vclock
+	}
+
+	var dash *dashboard
+	console := io.Writer(os.Stdout)
+	if cli.Bool("tui") {
+		limits, err := parseLimits(cli.StringSlice("limit"))
+		if err != nil {
+			return err
+		}
+
+		dash = newDashboard(filepath.Base(filename), limits)
+		console = dash
+	}
+
+	logger := session.NewLogger(console)
+	if clock != nil {
+		logger.Clock = clock
+	}
+
+	events := &session.Events{}
+
+	ls, err := lua.NewScript(string(filedata), lua.Options{
+		Name: filepath.Base(filename),
+		Params: params,
+		Path: append([]string{ filepath.Dir(filename) }, cli.StringSlice("lib")...),
+		Clock: clock,
+		Logger: logger,
+		Marker: events,
+	})
 	if err != nil {
 		return err
 	} else {
 		defer ls.Release()
 	}
 
-	callbacks := &device.CallbacksWrapper{
+	if cli.Bool("help-script") {
+		return printParams(filename, ls.Params())
+	} else if err := dms.ValidateParams(ls.Params(), params); err != nil {
+		return err
+	} else if cli.Bool("dry-run") {
+		return dryRun(cli, ls, clock)
+	}
+
+	sess, err := session.New(cli.String("session"))
+	if err != nil {
+		return err
+	}
+
+	sess.Script = filename
+
+	if err := logger.Open(sess.Path("session.log")); err != nil {
+		return err
+	} else {
+		defer logger.Close()
+	}
+
+	// virtual devices deliver telemetry synchronously to stay deterministic,
+	// a real one goes through the dispatch queue
+	var queue *device.Queue
+	var exporter *metrics.Exporter
+
+	newDevice := func(callbacks device.Callbacks) (device.Device, error) {
+		switch {
+		case cli.Bool("sim"):
+			return dmsx.NewSimulator(vclock, callbacks), nil
+		case cli.IsSet("replay"):
+			return dmsx.NewReplay(cli.String("replay"), vclock, callbacks)
+		default:
+			if queue, err = app.newQueue(cli, callbacks); err != nil {
+				return nil, err
+			} else if exporter != nil {
+				exporter.Register(metrics.QueueCollector("reader", queue))
+			}
+			return dmsx.NewDevice(cli.String("port"), queue), nil
+		}
+	}
+
+	if vclock != nil {
+		// the session and its checks are timed in virtual time, so are the
+		// test case durations of the junit report
+		start := vclock.Now()
+		sess.Clock, sess.Started = vclock, start
+		defer func() {
+			logger.Printf(dms.LogInfo, "virtual time: %s", vclock.Now().Sub(start))
+		}()
+	}
+
+	bus := device.NewBus()
+	defer bus.Close()
+
+	// the run record comes first, so that the lines the other subscribers
+	// log are stamped with the latest record (the script stamps its own with
+	// the record it is handling); it also takes the device errors
+	bus.Subscribe(&deviceLog{
+		CallbacksWrapper: device.CallbacksWrapper{
+			Telemetry: func(dev device.Device, t device.Telemetry) {
+				logger.Observe(t)
+				events.Observe(t)
+				app.telemetry = append(app.telemetry, t)
+			},
+		},
+		logger: logger,
+	}, device.SubscribeOptions{ Front: true })
+
+	var running atomic.Bool
+	running.Store(true)
+
+	if addr := cli.String("metrics"); len(addr) > 0 {
+		ln, err := net.Listen("tcp", addr)
+		if err != nil {
+			return err
+		}
+
+		exporter = metrics.New()
+		exporter.Register(func(w *metrics.Writer) {
+			w.Gauge("run_running", "1 while a script is running", metrics.Bool(running.Load()))
+			w.Gauge("run_info", "the current run", 1, "id", filepath.Base(sess.Dir), "script", filepath.Base(filename))
+			w.Counter("script_dropped_total", "telemetry records the running script did not keep up with", float64(ls.Dropped()))
+		})
+		bus.Subscribe(exporter, device.SubscribeOptions{})
+
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", exporter)
+
+		srv := &http.Server{ Handler: mux, ReadHeaderTimeout: 10 * time.Second }
+		go srv.Serve(ln)
+		defer srv.Close()
+
+		logger.Printf(dms.LogInfo, "metrics: http://%s/metrics", ln.Addr())
+	}
+
+	if dash != nil {
+		ctx, abort := context.WithCancel(cli.Context)
+		defer abort()
+		cli.Context = ctx
+
+		dash.abort = abort
+		dash.warn = func(msg string) {
+			logger.Printf(dms.LogWarn, "%s", msg)
+		}
+		// cut the throttle at once, then stop the script, then cut it again
+		// when the script is over in case it managed to send another command
+		var stopped atomic.Bool
+		dash.stop = func(dev device.Device) {
+			logger.Printf(dms.LogWarn, "emergency stop")
+			cutThrottle(dev, logger)
+			events.Mark(dms.Mark{ Name: "emergency-stop" })
+			stopped.Store(true)
+			abort()
+		}
+
+		open := newDevice
+		newDevice = func(callbacks device.Callbacks) (device.Device, error) {
+			dev, err := open(callbacks)
+			if err != nil {
+				return nil, err
+			}
+			return &stopGuard{ Device: dev, stopped: &stopped, logger: logger }, nil
+		}
+
+		bus.Subscribe(dash, device.SubscribeOptions{})
+		if err := dash.Open(200 * time.Millisecond); err != nil {
+			return err
+		}
+		defer dash.Close()
+	}
+
+	err = app.runTest(cli, ls, sess, bus, newDevice)
+	running.Store(false)
+	if dash != nil {
+		dash.Close()
+	}
+
+	if queue != nil {
+		queue.Close()
+		stats := queue.Stats()
+		sess.Telemetry = &stats
+	}
+
+	sess.ScriptDropped = ls.Dropped()
+	if sess.Telemetry != nil {
+		logger.Printf(dms.LogInfo, "telemetry: %s", sess.Telemetry)
+	}
+
+	if sess.ScriptDropped > 0 {
+		logger.Printf(dms.LogWarn, "telemetry: script dropped %d", sess.ScriptDropped)
+	}
+	if cli.Context.Err() != nil {
+		err = errAborted
+	} else if err == context.Canceled {
+		err = nil // test is over
+	}
+
+	for k, v := range params {
+		sess.Params[k] = v
+	}
+
+	for k, v := range ls.UsedParams() {
+		sess.Params[k] = v
+	}
+
+	outcome, code := testOutcome(err, ls.Result().Verdict())
+	sess.Finish(ls.Result(), outcome, err)
+	sess.Events = events.List()
+
+	if err := sess.SaveTelemetry(app.telemetry); err != nil {
+		logger.Printf(dms.LogError, "%v", err)
+	}
+
+	if target := cli.String("influx"); len(target) > 0 {
+		if err := sess.SaveInflux(target, cli.String("influx-token"), app.telemetry); err != nil {
+			logger.Printf(dms.LogError, "%v", err)
+		}
+	}
+
+	if err := sess.SaveEvents(); err != nil {
+		logger.Printf(dms.LogError, "%v", err)
+	}
+
+	if err := sess.Save(); err != nil {
+		logger.Printf(dms.LogError, "%v", err)
+	}
+
+	if name := cli.String("json"); len(name) > 0 {
+		if err := sess.WriteJSON(name); err != nil {
+			logger.Printf(dms.LogError, "%v", err)
+		}
+	}
+
+	if name := cli.String("junit"); len(name) > 0 {
+		if err := sess.WriteJUnit(name); err != nil {
+			logger.Printf(dms.LogError, "%v", err)
+		}
+	}
+
+	for _, c := range ls.Result().Failed() {
+		logger.Printf(dms.LogWarn, "%s: %s %s", c.Status, c.Name, c.Message)
+	}
+
+	if code != 0 {
+		if outcome == session.OutcomeError && err != nil {
+			logger.Printf(dms.LogError, "test error: %v", err)
+		} else {
+			logger.Printf(dms.LogError, "test %s", outcome)
+		}
+		return exitCode(code)
+	}
+
+	logger.Printf(dms.LogInfo, "test %s", outcome)
+	return nil
+}
+
+func printParams(filename string, params []dms.Param) error {
+	if len(params) == 0 {
+		fmt.Printf("%s: no parameters declared\n", filename)
+		return nil
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(tw, "name\ttype\tdefault\trange\tdescription\n")
+	for _, p := range params {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", p.Name, p.Type, p.Default, p.Range(), p.Description)
+	}
+
+	return tw.Flush()
+}
+
+// dryRun executes the script against a recorder which validates device
+// commands instead of sending them, sleeps take no real time.
+func dryRun(cli *cli.Context, ls dms.Script, clock dms.Clock) error {
+	rec := dms.NewRecorder(clock, dmsx.NewDevice("", nil).Signatures())
+
+	if res, err := ls.Bind(rec); err != nil {
+		return err
+	} else {
+		defer res.Release()
+	}
+
+	err := ls.Execute(cli.Context, "OnConnect")
+	if err == nil {
+		err = ls.Execute(cli.Context, "Test")
+		if err := ls.Execute(cli.Context, "OnDisconnect"); err != nil {
+			fmt.Println(err)
+		}
+	}
+
+	fmt.Println("timeline:")
+	if err := rec.WriteTimeline(os.Stdout); err != nil {
+		return err
+	}
+
+	fmt.Printf("duration: %s\n", clock.Now().Sub(rec.Start))
+
+	if err != nil && err != dms.ErrStopped {
+		return exitf(exitError, "dry run error: %v", err)
+	}
+
+	for _, c := range rec.Calls {
+		if c.Err != nil {
+			return exitf(exitError, "dry run: invalid device command %s", c)
+		}
+	}
+
+	return nil
+}
+
+func testOutcome(err error, verdict string) (string, int) {
+	switch {
+	case errors.Is(err, errAborted):
+		return session.OutcomeAborted, exitAborted
+	case errors.Is(err, errDisconnected):
+		return session.OutcomeDisconnected, exitDisconnected
+	case err != nil, verdict == dms.VerdictError:
+		return session.OutcomeError, exitError
+	case verdict == dms.VerdictFail:
+		return session.OutcomeFail, exitTestFailed
+	default:
+		return session.OutcomePass, 0
+	}
+}
+
+func cutThrottle(dev device.Device, logger *session.Logger) {
+	if _, err := dev.Control("throttle", dms.NewValue(1000)); err != nil {
+		logger.Printf(dms.LogError, "emergency stop: %v", err)
+	}
+}
+
+// stopGuard cuts the throttle once more before the device goes down after an
+// emergency stop
+// deviceLog writes the errors the device reader skips over (broken frames,
+// failed callbacks) to the session log
+type deviceLog struct {
+	device.CallbacksWrapper
+	logger *session.Logger
+}
+
+func (l *deviceLog) OnError(dev device.Device, err error) {
+	l.logger.Printf(dms.LogWarn, "device %s: %v", dev.Id(), err)
+}
+
+type stopGuard struct {
+	device.Device
+	stopped *atomic.Bool
+	logger *session.Logger
+}
+
+func (g *stopGuard) TearDown() error {
+	if g.stopped.Load() {
+		cutThrottle(g.Device, g.logger)
+	}
+	return g.Device.TearDown()
+}
+
+func (app *App) runTest(cli *cli.Context, ls dms.Script, sess *session.Session, bus *device.Bus, newDevice func(device.Callbacks) (device.Device, error)) error {
+	ctx, cancel := context.WithCancelCause(cli.Context)
+	defer cancel(nil)
+
+	unsubscribe := bus.Subscribe(&device.CallbacksWrapper{
 		Connect: func(dev device.Device) {
+			sess.Device = dev.Id()
 			if err := ls.Execute(context.Background(), "OnConnect"); err != nil {
-				panic(err)
+				cancel(err) // no Test, the run ends as an error
 			} else {
 				app.Go(func() {
 					defer ls.Execute(context.Background(), "OnDisconnect")
@@ -45,18 +412,21 @@ func (app *App) doTestCmd(cli *cli.Context) error {
 			}
 		},
 		Telemetry: func(dev device.Device, t device.Telemetry) {
-			if err := ls.Execute(ctx, "OnTelemetry", t); err != nil {
+			if err := ls.Feed(ctx, t); err != nil {
 				cancel(err)
-			} else {
-				app.telemetry = append(app.telemetry, t)
 			}
 		},
 		Disconnect: func(dev device.Device) {
-			cancel(errorf("disconnected"))
+			cancel(errDisconnected)
 		},
-	}
+	}, device.SubscribeOptions{ Front: true }) // tags records for the others
 
-	dev := dmsx.NewDevice(cli.String("port"), callbacks)
+	defer unsubscribe()
+
+	dev, err := newDevice(bus)
+	if err != nil {
+		return err
+	}
 
 	if res, err := ls.Bind(dev); err != nil {
 		return err
@@ -64,7 +434,9 @@ func (app *App) doTestCmd(cli *cli.Context) error {
 		defer res.Release()
 	}
 
-	if err := dev.StartUp(ctx); err != nil {
+	// the device outlives an aborted script: OnDisconnect and the emergency
+	// stop can still command it, it goes down when the script is over
+	if err := dev.StartUp(context.Background()); err != nil {
 		return err
 	} else {
 		defer dev.TearDown()
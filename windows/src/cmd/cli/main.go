@@ -12,8 +12,7 @@ import (
 	"github.com/sourcegraph/conc"
 
 	"dronmotors/dmetrics/internal/device"
-
-	"encoding/csv"
+	"dronmotors/dmetrics/internal/analysis"
 )
 
 ////////////////////////////////////////////////////////////////////////////////
@@ -22,6 +21,33 @@ func errorf(t string, args ...interface{}) error {
 	return fmt.Errorf(t, args...)
 }
 
+var (
+	errAborted = errorf("aborted")
+	errDisconnected = errorf("disconnected")
+)
+
+const (
+	exitTestFailed		= 1
+	exitError		= 2
+	exitDisconnected	= 3
+	exitAborted		= 4
+)
+
+func exitf(code int, t string, args ...interface{}) error {
+	return cli.Exit(fmt.Sprintf(t, args...), code)
+}
+
+// exitCode ends the program without a message, the reason is already reported
+type exitCode int
+
+func (c exitCode) Error() string {
+	return fmt.Sprintf("exit code %d", int(c))
+}
+
+func (c exitCode) ExitCode() int {
+	return int(c)
+}
+
 ////////////////////////////////////////////////////////////////////////////////
 
 type App struct {
@@ -34,10 +60,10 @@ func (app *App) argsMap(cli *cli.Context) map[string]string {
 	m := map[string]string{}
 
 	for _, arg := range cli.StringSlice("args") {
-		v := strings.Split(arg, "=")
+		v := strings.SplitN(arg, "=", 2)
 		if len(v) == 1 {
 			m[ v[0] ] = ""
-		} else if len(v) == 2 {
+		} else {
 			m[ v[0] ] = v[1]
 		}
 	}
@@ -45,27 +71,34 @@ func (app *App) argsMap(cli *cli.Context) map[string]string {
 	return m
 }
 
-func (app *App) SaveTelemetry(filename string) error {
-	if len(app.telemetry) == 0 {
-		return nil
+func queueFlags() []cli.Flag {
+	def := device.DefaultQueueOptions()
+	return []cli.Flag{
+		&cli.IntFlag{
+			Name: "queue",
+			Usage: "telemetry dispatch queue size",
+			Value: def.Size,
+		},
+		&cli.StringFlag{
+			Name: "overflow",
+			Usage: "queue overflow policy: block, drop-oldest, decimate",
+			Value: def.Policy,
+		},
 	}
+}
 
-	if f, err := os.Create(filename); err != nil {
-		return err
-	} else {
-		defer f.Close()
-
-                writer := csv.NewWriter(f)
-                defer writer.Flush()
-		
-		var data [][]string
-		data = append(data, append([]string{ "idx" }, app.telemetry[0].AsKeys()...))
-		for i, t := range app.telemetry {
-			data = append(data, append([]string{ fmt.Sprintf("%d", i) }, t.AsValues()... ))
-		}
+// newQueue puts a dispatch queue between the device reader and callbacks
+func (app *App) newQueue(cli *cli.Context, callbacks device.Callbacks) (*device.Queue, error) {
+	opts := device.QueueOptions{
+		Size: cli.Int("queue"),
+		Policy: cli.String("overflow"),
+	}
 
-		return writer.WriteAll(data)
+	if err := opts.Validate(); err != nil {
+		return nil, err
 	}
+
+	return device.NewQueue(callbacks, opts), nil
 }
 
 func NewApp() *App {
@@ -75,7 +108,7 @@ func NewApp() *App {
 		Commands: []*cli.Command{
 			{
 				Name:  "test",
-				Flags: []cli.Flag{
+				Flags: append([]cli.Flag{
 					&cli.StringFlag{
 						Name: "port",
 						Usage: "port to use",
@@ -85,14 +118,68 @@ func NewApp() *App {
 						Name: "args",
 						Usage: "args to pass to the script",
 					},
-				},
+					&cli.BoolFlag{
+						Name: "help-script",
+						Usage: "list script parameters and exit",
+					},
+					&cli.BoolFlag{
+						Name: "dry-run",
+						Usage: "run the script against a recording fake device in virtual time",
+					},
+					&cli.BoolFlag{
+						Name: "sim",
+						Usage: "run the script against the stand simulator in virtual time",
+					},
+					&cli.StringFlag{
+						Name: "replay",
+						Usage: "run the script against captured telemetry (csv) in virtual time",
+					},
+					&cli.StringSliceFlag{
+						Name: "lib",
+						Usage: "additional lua module directory",
+					},
+					&cli.StringFlag{
+						Name: "session",
+						Usage: "session directory to store run results",
+						Value: ".",
+					},
+					&cli.StringFlag{
+						Name: "junit",
+						Usage: "write junit xml result to file",
+					},
+					&cli.StringFlag{
+						Name: "json",
+						Usage: "write json result to file",
+					},
+					&cli.BoolFlag{
+						Name: "tui",
+						Usage: "show a live dashboard: space - emergency stop, q - abort",
+					},
+					&cli.StringSliceFlag{
+						Name: "limit",
+						Usage: "warn when telemetry exceeds the limit on the dashboard: key=max",
+					},
+					&cli.StringFlag{
+						Name: "metrics",
+						Usage: "serve prometheus metrics on address while the test runs, e.g. :9100",
+					},
+					&cli.StringFlag{
+						Name: "influx",
+						Usage: "write telemetry as influxdb line protocol to a file or a write url",
+					},
+					&cli.StringFlag{
+						Name: "influx-token",
+						Usage: "influxdb api token",
+						EnvVars: []string{ "DM_INFLUX_TOKEN" },
+					},
+				}, queueFlags()...),
 				Action: func(cli *cli.Context) error {
 					return app.doTestCmd(cli)
 				},
 			},
 			{
 				Name:  "tele",
-				Flags: []cli.Flag{
+				Flags: append([]cli.Flag{
 					&cli.IntFlag{
 						Name: "rate",
 						Usage: "sample rate, ms",
@@ -103,14 +190,44 @@ func NewApp() *App {
 						Usage: "port to use",
 						Value: "/dev/tty.usbmodem101",
 					},
-				},
+					&cli.Float64Flag{
+						Name: "display-rate",
+						Usage: "display updates per second, 0 - every record",
+						Value: 5,
+					},
+					&cli.StringSliceFlag{
+						Name: "columns",
+						Usage: "columns to display (default: all)",
+					},
+					&cli.BoolFlag{
+						Name: "stats",
+						Usage: "show min/mean/max of each display interval",
+					},
+					&cli.BoolFlag{
+						Name: "live",
+						Usage: "redraw a fixed-position table instead of scrolling",
+					},
+					&cli.StringFlag{
+						Name: "output",
+						Usage: "record full-rate telemetry to csv file",
+					},
+					&cli.StringFlag{
+						Name: "influx",
+						Usage: "stream full-rate telemetry as influxdb line protocol to a file or a write url",
+					},
+					&cli.StringFlag{
+						Name: "influx-token",
+						Usage: "influxdb api token",
+						EnvVars: []string{ "DM_INFLUX_TOKEN" },
+					},
+				}, queueFlags()...),
 				Action: func(cli *cli.Context) error {
 					return app.doTeleCmd(cli)
 				},
 			},
 			{
 				Name:  "repl",
-				Flags: []cli.Flag{
+				Flags: append([]cli.Flag{
 					&cli.StringFlag{
 						Name: "port",
 						Usage: "port to use",
@@ -121,11 +238,244 @@ func NewApp() *App {
 						Usage: "tele file to use",
 						Value: "telemetry.bin",
 					},
-				},
+					&cli.StringFlag{
+						Name: "csv",
+						Usage: "file to save telemetry as csv to, for plot and analyze",
+						Value: "telemetry.csv",
+					},
+					&cli.StringFlag{
+						Name: "events",
+						Usage: "file to save /mark events to",
+						Value: "events.csv",
+					},
+				}, queueFlags()...),
 				Action: func(cli *cli.Context) error {
 					return app.doReplCmd(cli)
 				},
 			},
+			{
+				Name:  "serve",
+				Usage: "serve the device over http: rest api and websocket telemetry",
+				Flags: append([]cli.Flag{
+					&cli.StringFlag{
+						Name: "port",
+						Usage: "port to use",
+						Value: "/dev/tty.usbmodem101",
+					},
+					&cli.IntFlag{
+						Name: "rate",
+						Usage: "sample rate, ms",
+						Value: 10,
+					},
+					&cli.StringFlag{
+						Name: "listen",
+						Usage: "address to listen on",
+						Value: "127.0.0.1:8080",
+					},
+					&cli.StringSliceFlag{
+						Name: "allow-host",
+						Usage: "host name the api is requested at besides an ip address, localhost and the --listen name",
+					},
+					&cli.StringFlag{
+						Name: "scripts",
+						Usage: "directory of the scripts that can be run",
+						Value: ".",
+					},
+					&cli.StringFlag{
+						Name: "runs",
+						Usage: "directory to store run sessions in",
+						Value: "runs",
+					},
+					&cli.StringSliceFlag{
+						Name: "lib",
+						Usage: "additional lua module directory",
+					},
+					&cli.StringFlag{
+						Name: "mqtt",
+						Usage: "publish telemetry and events to the mqtt broker host[:port], tls://host[:port] over tls",
+					},
+					&cli.StringFlag{
+						Name: "mqtt-ca",
+						Usage: "pem certificates to trust for a tls:// broker instead of the system roots",
+					},
+					&cli.StringFlag{
+						Name: "mqtt-prefix",
+						Usage: "mqtt topic prefix",
+						Value: "stand",
+					},
+					&cli.StringFlag{
+						Name: "mqtt-id",
+						Usage: "device id in the mqtt topics, the device identification by default",
+					},
+					&cli.StringFlag{
+						Name: "mqtt-user",
+						Usage: "mqtt user name",
+						EnvVars: []string{ "DM_MQTT_USER" },
+					},
+					&cli.StringFlag{
+						Name: "mqtt-password",
+						Usage: "mqtt password",
+						EnvVars: []string{ "DM_MQTT_PASSWORD" },
+					},
+					&cli.Float64Flag{
+						Name: "mqtt-rate",
+						Usage: "telemetry publish rate, Hz, 0 - every record",
+						Value: 10,
+					},
+					&cli.BoolFlag{
+						Name: "mqtt-control",
+						Usage: "accept device commands on the <prefix>/<device id>/command topic, a tls:// broker only",
+					},
+					&cli.BoolFlag{
+						Name: "mqtt-insecure-control",
+						Usage: "allow --mqtt-control over a plain connection",
+					},
+					&cli.StringFlag{
+						Name: "influx",
+						Usage: "write telemetry of the runs as influxdb line protocol to a file or a write url",
+					},
+					&cli.StringFlag{
+						Name: "influx-token",
+						Usage: "influxdb api token",
+						EnvVars: []string{ "DM_INFLUX_TOKEN" },
+					},
+				}, queueFlags()...),
+				Action: func(cli *cli.Context) error {
+					return app.doServeCmd(cli)
+				},
+			},
+			{
+				Name:  "analyze",
+				Usage: "build steady-state operating point table of a run",
+				ArgsUsage: "[telemetry.csv]",
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name: "format",
+						Usage: "output format: csv or json",
+						Value: "csv",
+					},
+					&cli.StringFlag{
+						Name: "output",
+						Usage: "output file, stdout if empty",
+					},
+					&cli.IntFlag{
+						Name: "window",
+						Usage: "steady-state detection window, samples",
+						Value: analysis.DefaultOptions().Window,
+					},
+					&cli.Float64Flag{
+						Name: "tolerance",
+						Usage: "allowed relative spread within the window",
+						Value: analysis.DefaultOptions().Tolerance,
+					},
+				},
+				Action: func(cli *cli.Context) error {
+					return app.doAnalyzeCmd(cli)
+				},
+			},
+			{
+				Name:  "datasheet",
+				Usage: "fit motor performance curves and export a datasheet",
+				ArgsUsage: "[telemetry.csv]",
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name: "output",
+						Usage: "output directory",
+						Value: "datasheet",
+					},
+					&cli.StringFlag{
+						Name: "motor",
+						Usage: "motor type name",
+					},
+					&cli.IntFlag{
+						Name: "degree",
+						Usage: "polynomial degree",
+						Value: 2,
+					},
+					&cli.Float64Flag{
+						Name: "level",
+						Usage: "confidence level",
+						Value: 0.95,
+					},
+					&cli.IntFlag{
+						Name: "window",
+						Usage: "steady-state detection window, samples",
+						Value: analysis.DefaultOptions().Window,
+					},
+					&cli.Float64Flag{
+						Name: "tolerance",
+						Usage: "allowed relative spread within the window",
+						Value: analysis.DefaultOptions().Tolerance,
+					},
+				},
+				Action: func(cli *cli.Context) error {
+					return app.doDatasheetCmd(cli)
+				},
+			},
+			{
+				Name:  "compare",
+				Usage: "compare a run against a golden baseline",
+				ArgsUsage: "<run> <baseline>",
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name: "align",
+						Usage: "align steps by: throttle or tag",
+						Value: analysis.AlignThrottle,
+					},
+					&cli.StringSliceFlag{
+						Name: "tol",
+						Usage: "tolerance per metric: rpm=5% (relative) or temp1=10 (absolute)",
+					},
+					&cli.StringFlag{
+						Name: "report",
+						Usage: "write json diff report to file",
+					},
+					&cli.BoolFlag{
+						Name: "failed",
+						Usage: "show failed checks only",
+					},
+					&cli.IntFlag{
+						Name: "window",
+						Usage: "steady-state detection window, samples",
+						Value: analysis.DefaultOptions().Window,
+					},
+					&cli.Float64Flag{
+						Name: "tolerance",
+						Usage: "allowed relative spread within the window",
+						Value: analysis.DefaultOptions().Tolerance,
+					},
+				},
+				Action: func(cli *cli.Context) error {
+					return app.doCompareCmd(cli)
+				},
+			},
+			{
+				Name:  "plot",
+				Usage: "plot telemetry over time with event markers",
+				ArgsUsage: "[telemetry.csv]",
+				Flags: []cli.Flag{
+					&cli.StringSliceFlag{
+						Name: "field",
+						Usage: "field to plot: throttle, rpm, current, voltage, power, thrust, torque, temp1, temp2",
+						Value: cli.NewStringSlice("rpm"),
+					},
+					&cli.StringFlag{
+						Name: "events",
+						Usage: "events file (default: events.csv next to telemetry)",
+					},
+					&cli.StringFlag{
+						Name: "output",
+						Usage: "output svg file",
+						Value: "plot.svg",
+					},
+				},
+				Action: func(cli *cli.Context) error {
+					return app.doPlotCmd(cli)
+				},
+			},
+		},
+		ExitErrHandler: func(*cli.Context, error) {
+			// exit codes are handled in main
 		},
 	}
 	return app
@@ -145,12 +495,14 @@ func main() {
         defer cancel()
 
         if err := app.RunContext(ctx, os.Args); err != nil {
-                if err != context.Canceled {
+                if _, silent := err.(exitCode); !silent && err != context.Canceled {
 			fmt.Println(err)
-		} else {
-			if err := app.SaveTelemetry("telemetry.csv"); err != nil {
-				fmt.Println(err)
-			}
+		}
+
+		if v, ok := err.(cli.ExitCoder); ok {
+			os.Exit(v.ExitCode())
+		} else if err != context.Canceled {
+			os.Exit(exitError)
 		}
 	}
 }
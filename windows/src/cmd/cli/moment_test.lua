@@ -1,4 +1,8 @@
-local tag = 'idle'
+local util = require 'util'
+local stand = require 'stand'
+
+stand.setTag('idle')
+
 local lastTele = {}
 
 local function onConnect()
@@ -8,38 +12,10 @@ local function onConnect()
    tare()
 end
 
--- Проверка, находится ли значение в таблице
-local function isInTable(value, tbl)
-   if type(tbl) ~= "table" then
-      error("Second argument must be a table")
-   end
-   for _, v in ipairs(tbl) do
-      if v == value then
-         return true
-      end
-   end
-   return false
-end
-
-local function printTelemetry(t)
-   local str = string.format(
-      'Скорость:%d:Момент:%.02f:Тяга:%d:Об/мин:%d:Ток:%.02f:Напряжение:%.02f:КПД:%.02f',
-      t.Throttle, 
-      (t.Load2 + t.Load3) / 2, 
-      t.Load1, 
-      t.MotorRPM, 
-      t.MotorI, 
-      t.MotorU, 
-      t.MotorP
-   )
-   print(str)
-end
-
 local function onTelemetry(t)
-   t.Tag = tag
    lastTele = t
    if t.Throttle % 100 == 0 then
-      printTelemetry(t)
+      util.printSummary(t)
    end
 end
 
@@ -66,11 +42,11 @@ local function test(t)
    -- фаза тестирования и снятия телеметрии с каждой 100
    --
 
-   tag = 'acceleration'
+   stand.setTag('acceleration')
 
    for i = pulseMin, pulseMax, pulseInc do
       throttle(i)
-      if isInTable(i, throttleValues) then
+      if util.isInTable(i, throttleValues) then
          sleep(bigDelay) -- Увеличенная задержка для выбранных значений
       else
          sleep(delay)    -- Обычная задержка
@@ -88,4 +64,16 @@ return {
    OnConnect	= onConnect,
    OnTelemetry	= onTelemetry,
    OnDisconnect	= onDisconnect,
+   Params	= {
+      { name = 'sampleRate', type = 'int', default = 10, min = 1, max = 1000000,
+        description = 'период телеметрии, мс' },
+      { name = 'delay', type = 'int', default = 250, min = 10, max = 5000,
+        description = 'задержка на каждом шаге газа, мс' },
+      { name = 'pulseMin', type = 'int', default = 1000, min = 1000, max = 2000,
+        description = 'начальный газ, мкс' },
+      { name = 'pulseMax', type = 'int', default = 2000, min = 1000, max = 2000,
+        description = 'конечный газ, мкс' },
+      { name = 'pulseInc', type = 'int', default = 25, min = 1, max = 1000,
+        description = 'шаг газа, мкс' },
+   },
 }
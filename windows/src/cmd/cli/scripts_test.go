@@ -0,0 +1,49 @@
+package main
+
+import (
+	"os"
+	"regexp"
+	"testing"
+	"path/filepath"
+
+	"dronmotors/dmetrics/internal/script/lua"
+	dms "dronmotors/dmetrics/internal/script"
+)
+
+// the shipped scripts declare every param they read, so --args is checked
+func TestScriptParams(t *testing.T) {
+	getter := regexp.MustCompile(`(int|float|bool|str)Param\(\s*'([^']+)'`)
+
+	for _, name := range []string{ "test12000.lua", "moment_test.lua", "cooling.lua", "test_conn.lua" } {
+		text, err := os.ReadFile(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		ls, err := lua.NewScript(string(text), lua.Options{ Name: name, Path: []string{ filepath.Dir(name) } })
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		defer ls.Release()
+
+		declared := map[string]bool{}
+		for _, p := range ls.Params() {
+			if len(p.Description) == 0 || len(p.Default) == 0 {
+				t.Errorf("%s: param '%s' without description or default", name, p.Name)
+			}
+			declared[p.Name] = true
+		}
+
+		for _, m := range getter.FindAllStringSubmatch(string(text), -1) {
+			if !declared[m[2]] {
+				t.Errorf("%s: param '%s' is not declared", name, m[2])
+			}
+		}
+
+		if err := dms.ValidateParams(ls.Params(), map[string]string{ "sampelRate": "10" }); err == nil {
+			t.Errorf("%s: a mistyped param is accepted", name)
+		} else if err := dms.ValidateParams(ls.Params(), map[string]string{ "sampleRate": "0" }); err == nil {
+			t.Errorf("%s: sampleRate out of range is accepted", name)
+		}
+	}
+}
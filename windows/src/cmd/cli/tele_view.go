@@ -0,0 +1,162 @@
+package main
+
+import (
+	"io"
+	"fmt"
+	"math"
+	"time"
+	"strings"
+	"strconv"
+
+	"dronmotors/dmetrics/internal/device"
+)
+
+type colStats struct {
+	n int
+	min, max, sum float64
+}
+
+func (c *colStats) add(v float64) {
+	if c.n == 0 {
+		c.min, c.max = v, v
+	} else {
+		c.min, c.max = math.Min(c.min, v), math.Max(c.max, v)
+	}
+	c.n++
+	c.sum += v
+}
+
+// teleView renders telemetry once per display interval: a line with the last
+// value (or min/mean/max) of each column, or a table redrawn in place.
+type teleView struct {
+	out io.Writer
+	columns []string // nil - all
+	stats bool
+	live bool
+	interval time.Duration
+
+	index []int // of the columns in the record keys
+	agg []colStats
+	last []string
+	records int
+	start time.Time
+	lines int
+}
+
+const teleHeaderEvery = 20
+
+func (v *teleView) resolve(t device.Telemetry) error {
+	keys := t.AsKeys()
+	if len(v.columns) == 0 {
+		v.columns = keys
+	}
+
+	for _, c := range v.columns {
+		i := -1
+		for j, k := range keys {
+			if strings.EqualFold(k, c) {
+				i = j
+			}
+		}
+
+		if i < 0 {
+			return errorf("tele: no such column %q, available: %s", c, strings.Join(keys, ", "))
+		}
+
+		v.index = append(v.index, i)
+	}
+
+	v.agg = make([]colStats, len(v.columns))
+	v.last = make([]string, len(v.columns))
+	return nil
+}
+
+func (v *teleView) Add(t device.Telemetry) error {
+	if v.index == nil {
+		if err := v.resolve(t); err != nil {
+			return err
+		}
+		v.start = t.TimeStamp()
+	}
+
+	values := t.AsValues()
+	for i, idx := range v.index {
+		if idx < len(values) {
+			v.last[i] = values[idx]
+			if f, err := strconv.ParseFloat(values[idx], 64); err == nil {
+				v.agg[i].add(f)
+			}
+		}
+	}
+
+	v.records++
+
+	if ts := t.TimeStamp(); ts.Sub(v.start) >= v.interval {
+		v.render()
+		v.start = ts
+		v.records = 0
+		for i := range v.agg {
+			v.agg[i] = colStats{}
+		}
+	}
+
+	return nil
+}
+
+func (v *teleView) width(i int) int {
+	w := 9
+	if v.stats {
+		w = 23
+	}
+	if n := len(v.columns[i]); n > w {
+		w = n
+	}
+	return w
+}
+
+func (v *teleView) cell(i int) string {
+	if a := v.agg[i]; v.stats && a.n > 0 {
+		return fmt.Sprintf("%.4g/%.4g/%.4g", a.min, a.sum / float64(a.n), a.max)
+	}
+	return v.last[i]
+}
+
+func (v *teleView) render() {
+	if v.live {
+		v.renderTable()
+		return
+	}
+
+	var b strings.Builder
+	if v.lines % teleHeaderEvery == 0 {
+		for i, c := range v.columns {
+			fmt.Fprintf(&b, "%*s ", v.width(i), c)
+		}
+		b.WriteString("\n")
+	}
+
+	for i := range v.columns {
+		fmt.Fprintf(&b, "%*s ", v.width(i), v.cell(i))
+	}
+	b.WriteString("\n")
+
+	v.lines++
+	io.WriteString(v.out, b.String())
+}
+
+func (v *teleView) renderTable() {
+	var b strings.Builder
+	b.WriteString("\x1b[H\x1b[2J") // home, clear screen
+	fmt.Fprintf(&b, "%-12s %12s %12s %12s %12s\n", "column", "last", "min", "mean", "max")
+
+	for i, c := range v.columns {
+		if a := v.agg[i]; a.n > 0 {
+			fmt.Fprintf(&b, "%-12s %12s %12.4g %12.4g %12.4g\n", c, v.last[i], a.min, a.sum / float64(a.n), a.max)
+		} else {
+			fmt.Fprintf(&b, "%-12s %12s\n", c, v.last[i])
+		}
+	}
+
+	fmt.Fprintf(&b, "\n%d records in %s\n", v.records, v.interval)
+	io.WriteString(v.out, b.String())
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"fmt"
+	"time"
+	"strings"
+	"testing"
+)
+
+// feedView adds a ts,rpm,mode record every 50ms, rpm going up by 100
+func feedView(t *testing.T, v *teleView, n int) {
+	t.Helper()
+	epoch := time.Unix(1760000000, 0)
+	for i := 0; i < n; i++ {
+		r := record{
+			keys: []string{ "ts", "motorRPM", "mode" },
+			values: []string{ fmt.Sprint(i * 50), fmt.Sprint(1000 + i * 100), "hover" },
+			ts: epoch.Add(time.Duration(i * 50) * time.Millisecond),
+		}
+		if err := v.Add(r); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestTeleView(t *testing.T) {
+	tests := []struct {
+		name string
+		view teleView
+		n int
+		want string
+	}{
+		{
+			"every record",
+			teleView{ columns: []string{ "motorrpm" } },
+			3,
+			"" +
+				" motorrpm \n" +
+				"     1000 \n" +
+				"     1100 \n" +
+				"     1200 \n",
+		},
+		{
+			"last value per interval",
+			teleView{ interval: 100 * time.Millisecond },
+			5,
+			"" +
+				"       ts  motorRPM      mode \n" +
+				"      100      1200     hover \n" +
+				"      200      1400     hover \n",
+		},
+		{
+			"stats",
+			teleView{ columns: []string{ "motorRPM", "mode" }, stats: true, interval: 100 * time.Millisecond },
+			5,
+			"" +
+				"               motorRPM                    mode \n" +
+				"         1000/1100/1200                   hover \n" +
+				"         1300/1350/1400                   hover \n",
+		},
+		{
+			"live",
+			teleView{ columns: []string{ "motorRPM" }, live: true, interval: 100 * time.Millisecond },
+			3,
+			"\x1b[H\x1b[2J" +
+				"column               last          min         mean          max\n" +
+				"motorRPM             1200         1000         1100         1200\n" +
+				"\n3 records in 100ms\n",
+		},
+	}
+
+	for _, tt := range tests {
+		var b strings.Builder
+		v := tt.view
+		v.out = &b
+		feedView(t, &v, tt.n)
+
+		if got := b.String(); got != tt.want {
+			t.Errorf("%s: got\n%s\nwant\n%s", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestTeleViewHeader(t *testing.T) {
+	var b strings.Builder
+	feedView(t, &teleView{ out: &b, columns: []string{ "mode" } }, teleHeaderEvery + 1)
+
+	// repeated every teleHeaderEvery lines
+	if n := strings.Count(b.String(), "mode"); n != 2 {
+		t.Errorf("%d headers in\n%s", n, b.String())
+	}
+}
+
+func TestTeleViewColumns(t *testing.T) {
+	v := &teleView{ out: &strings.Builder{}, columns: []string{ "motorRPM", "temp9" } }
+	err := v.Add(record{ keys: []string{ "ts", "motorRPM" }, values: []string{ "0", "1000" } })
+	if err == nil || !strings.Contains(err.Error(), `no such column "temp9", available: ts, motorRPM`) {
+		t.Errorf("got %v", err)
+	}
+}
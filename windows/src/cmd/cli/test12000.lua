@@ -1,4 +1,8 @@
-local tag = 'idle'
+local util = require 'util'
+local stand = require 'stand'
+
+stand.setTag('idle')
+
 local lastTele = {}
 
 local function onConnect()
@@ -8,19 +12,10 @@ local function onConnect()
    tare()
 end
 
-local function printTelemetry(t)
-   local str = string.format(
-      '%dµs r/min %d b/pos %d | %.02fA x %.02fV = %.02fW | %.02f°C %.02f°C | %d %d %d | %d %d %d',
-      t.Throttle, t.MotorRPM, t.Brake, t.MotorI, t.MotorU, t.MotorP, t.Temp1, t.Temp2, t.Load1, t.Load2, t.Load3, t.GyroX, t.GyroY, t.GyroZ
-   )
-   print(str)
-end
-
 local function onTelemetry(t)
-   t.Tag = tag
    lastTele = t
    if t.Throttle % 100 == 0 then
-      printTelemetry(t)
+      util.printTelemetry(t)
    end
 end
 
@@ -42,7 +37,7 @@ local function test(t)
    -- фаза разгона двигателя
    --
 
-   tag = 'acceleration'
+   stand.setTag('acceleration')
 
    for i = pulseMin, pulseMax, pulseInc do
       throttle(i)
@@ -53,20 +48,17 @@ local function test(t)
    -- фаза торможения двигателя диском
    --
 
-   tag = 'slowdown'
+   stand.setTag('slowdown')
 
    brake(1, 6000)
 
-   while lastTele.Brake < 6000 do
-      if lastTele.MotorRPM < 6000 then
-          print('MotorRPM < 6000 limit!')
-          brake(1, 0)
-          break
-      end
-      sleep(10)
+   local t = waitUntil(function(t) return t.Brake >= 6000 or t.MotorRPM < 6000 end)
+   if t and t.MotorRPM < 6000 then
+      log.warn('MotorRPM below limit', { rpm = t.MotorRPM, limit = 6000 })
+      brake(1, 0)
    end
 
-   tag = 'maxpower'
+   stand.setTag('maxpower')
 
    sleep(1000)
 
@@ -79,4 +71,16 @@ return {
    OnConnect	= onConnect,
    OnTelemetry	= onTelemetry,
    OnDisconnect	= onDisconnect,
+   Params	= {
+      { name = 'sampleRate', type = 'int', default = 10, min = 1, max = 1000000,
+        description = 'период телеметрии, мс' },
+      { name = 'delay', type = 'int', default = 250, min = 10, max = 5000,
+        description = 'задержка на каждом шаге газа, мс' },
+      { name = 'pulseMin', type = 'int', default = 1000, min = 1000, max = 2000,
+        description = 'начальный газ, мкс' },
+      { name = 'pulseMax', type = 'int', default = 2000, min = 1000, max = 2000,
+        description = 'конечный газ, мкс' },
+      { name = 'pulseInc', type = 'int', default = 50, min = 1, max = 1000,
+        description = 'шаг газа, мкс' },
+   },
 }
@@ -1,4 +1,7 @@
-local tag = 'idle'
+local stand = require 'stand'
+
+stand.setTag('idle')
+
 local lastTele = {}
 
 -- Вызывается при подключении
@@ -11,7 +14,6 @@ end
 
 -- Обработка телеметрии
 local function onTelemetry(t)
-   t.Tag = tag
    lastTele = t
 
    -- Отображение успешного подключения через телеметрию
@@ -37,4 +39,8 @@ return {
    OnConnect    = onConnect,
    OnTelemetry  = onTelemetry,
    OnDisconnect = onDisconnect,
+   Params       = {
+      { name = 'sampleRate', type = 'int', default = 100000, min = 1, max = 1000000,
+        description = 'период телеметрии, мс' },
+   },
 }
\ No newline at end of file
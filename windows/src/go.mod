@@ -9,6 +9,7 @@ require (
 	github.com/urfave/cli/v2 v2.27.4
 	github.com/yuin/gluamapper v0.0.0-20150323120927-d836955830e7
 	github.com/yuin/gopher-lua v1.1.1
+	golang.org/x/term v0.27.0
 	layeh.com/gopher-luar v1.0.11
 )
 
@@ -21,5 +22,4 @@ require (
 	go.uber.org/atomic v1.10.0 // indirect
 	go.uber.org/multierr v1.9.0 // indirect
 	golang.org/x/sys v0.28.0 // indirect
-	golang.org/x/term v0.27.0 // indirect
 )
@@ -0,0 +1,295 @@
+package analysis
+
+import (
+	"io"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+var pointMetrics = map[string]func(OperatingPoint) float64{
+	"rpm":        func(op OperatingPoint) float64 { return op.RPM },
+	"current":    func(op OperatingPoint) float64 { return op.I },
+	"voltage":    func(op OperatingPoint) float64 { return op.U },
+	"power":      func(op OperatingPoint) float64 { return op.P },
+	"thrust":     func(op OperatingPoint) float64 { return op.Thrust },
+	"torque":     func(op OperatingPoint) float64 { return op.Torque },
+	"temp1":      func(op OperatingPoint) float64 { return op.Temp1 },
+	"temp2":      func(op OperatingPoint) float64 { return op.Temp2 },
+	"efficiency": func(op OperatingPoint) float64 { return op.Efficiency },
+}
+
+// Tolerance is an allowed deviation of a metric from the baseline, either
+// absolute or relative to the baseline value.
+type Tolerance struct {
+	Metric   string
+	Value    float64
+	Relative bool
+}
+
+func DefaultTolerances() []Tolerance {
+	return []Tolerance{
+		{ Metric: "rpm", Value: 0.05, Relative: true },
+		{ Metric: "current", Value: 0.10, Relative: true },
+		{ Metric: "thrust", Value: 0.05, Relative: true },
+		{ Metric: "temp1", Value: 10 },
+		{ Metric: "temp2", Value: 10 },
+	}
+}
+
+// ParseTolerance parses "metric=N" (absolute) and "metric=N%" (relative).
+func ParseTolerance(s string) (Tolerance, error) {
+	v := strings.SplitN(s, "=", 2)
+	if len(v) != 2 {
+		return Tolerance{}, errorf("tolerance %q: expected metric=value", s)
+	}
+
+	tol := Tolerance{ Metric: strings.ToLower(strings.TrimSpace(v[0])) }
+	if _, ok := pointMetrics[tol.Metric]; !ok {
+		return tol, errorf("tolerance %q: unknown metric %q", s, tol.Metric)
+	}
+
+	num := strings.TrimSpace(v[1])
+	if strings.HasSuffix(num, "%") {
+		tol.Relative = true
+		num = strings.TrimSuffix(num, "%")
+	}
+
+	n, err := strconv.ParseFloat(num, 64)
+	if err != nil || n < 0 {
+		return tol, errorf("tolerance %q: bad value", s)
+	}
+
+	if tol.Relative {
+		n /= 100
+	}
+
+	tol.Value = n
+	return tol, nil
+}
+
+func (t Tolerance) limit(base float64) float64 {
+	if t.Relative {
+		return t.Value * math.Abs(base)
+	}
+	return t.Value
+}
+
+func (t Tolerance) String() string {
+	if t.Relative {
+		return fmt.Sprintf("±%g%%", t.Value * 100)
+	}
+	return fmt.Sprintf("±%g", t.Value)
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+const (
+	AlignThrottle = "throttle"
+	AlignTag      = "tag"
+)
+
+type Diff struct {
+	Step      string  `json:"step"`
+	Metric    string  `json:"metric"`
+	Run       float64 `json:"run"`
+	Baseline  float64 `json:"baseline"`
+	Delta     float64 `json:"delta"`
+	Tolerance string  `json:"tolerance"`
+	Missing   bool    `json:"missing,omitempty"`
+	OK        bool    `json:"ok"`
+}
+
+type Report struct {
+	Align string   `json:"align"`
+	Diffs []Diff   `json:"diffs"`
+	Extra []string `json:"extra,omitempty"` // run steps the baseline does not have
+	OK    bool     `json:"ok"`
+}
+
+type step struct {
+	key   string
+	order float64
+	op    OperatingPoint
+}
+
+// steps merges operating points sharing the same key, weighting them by the
+// number of steady samples.
+func steps(points []OperatingPoint, align string) ([]step, error) {
+	var keys []string
+	groups := map[string][]OperatingPoint{}
+
+	for _, op := range points {
+		var key string
+		switch align {
+		case AlignThrottle:
+			key = fmt.Sprintf("%.0f", op.Throttle)
+		case AlignTag:
+			key = op.Tag
+		default:
+			return nil, errorf("align %q is not supported", align)
+		}
+
+		if _, ok := groups[key]; !ok {
+			keys = append(keys, key)
+		}
+		groups[key] = append(groups[key], op)
+	}
+
+	var res []step
+	for i, key := range keys {
+		s := step{ key: key, order: float64(i) }
+
+		// weighted by the steady samples, equally if there are none (e.g. a
+		// hand-written baseline without them)
+		total, equal := 0.0, true
+		for _, op := range groups[key] {
+			equal = equal && op.Steady <= 0
+		}
+
+		for _, op := range groups[key] {
+			w := float64(op.Steady)
+			if equal {
+				w = 1
+			}
+			total += w
+			s.op.Throttle += w * op.Throttle
+			s.op.RPM += w * op.RPM
+			s.op.I += w * op.I
+			s.op.U += w * op.U
+			s.op.P += w * op.P
+			s.op.Thrust += w * op.Thrust
+			s.op.Torque += w * op.Torque
+			s.op.Temp1 += w * op.Temp1
+			s.op.Temp2 += w * op.Temp2
+			s.op.Efficiency += w * op.Efficiency
+		}
+
+		if total > 0 {
+			s.op.Throttle /= total
+			s.op.RPM /= total
+			s.op.I /= total
+			s.op.U /= total
+			s.op.P /= total
+			s.op.Thrust /= total
+			s.op.Torque /= total
+			s.op.Temp1 /= total
+			s.op.Temp2 /= total
+			s.op.Efficiency /= total
+		}
+
+		if align == AlignThrottle {
+			s.order = s.op.Throttle
+		}
+
+		res = append(res, s)
+	}
+
+	sort.SliceStable(res, func(i, j int) bool {
+		return res[i].order < res[j].order
+	})
+
+	return res, nil
+}
+
+// Compare aligns a run with the baseline and checks every baseline step
+// against the tolerances. A step missing in the run is a failure, run steps
+// missing in the baseline are reported as extra. A baseline without steps
+// is an error: nothing would be compared.
+func Compare(run, baseline []OperatingPoint, align string, tols []Tolerance) (*Report, error) {
+	rs, err := steps(run, align)
+	if err != nil {
+		return nil, err
+	}
+
+	bs, err := steps(baseline, align)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(bs) == 0 {
+		return nil, errorf("baseline has no steady-state steps")
+	} else if len(tols) == 0 {
+		return nil, errorf("no tolerances to check")
+	}
+
+	byKey := map[string]OperatingPoint{}
+	for _, s := range rs {
+		byKey[s.key] = s.op
+	}
+
+	inBaseline := map[string]bool{}
+	for _, s := range bs {
+		inBaseline[s.key] = true
+	}
+
+	report := &Report{ Align: align, OK: true }
+
+	for _, s := range rs {
+		if !inBaseline[s.key] {
+			report.Extra = append(report.Extra, s.key)
+		}
+	}
+
+	for _, b := range bs {
+		r, found := byKey[b.key]
+
+		for _, tol := range tols {
+			metric, ok := pointMetrics[tol.Metric]
+			if !ok {
+				return nil, errorf("unknown metric %q", tol.Metric)
+			}
+
+			d := Diff{
+				Step: b.key,
+				Metric: tol.Metric,
+				Baseline: metric(b.op),
+				Tolerance: tol.String(),
+				Missing: !found,
+			}
+
+			if found {
+				d.Run = metric(r)
+				d.Delta = d.Run - d.Baseline
+				d.OK = math.Abs(d.Delta) <= tol.limit(d.Baseline)
+			}
+
+			report.OK = report.OK && d.OK
+			report.Diffs = append(report.Diffs, d)
+		}
+	}
+
+	return report, nil
+}
+
+func (r Report) WriteText(w io.Writer, failedOnly bool) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+
+	fmt.Fprintf(tw, "%s\tmetric\trun\tbaseline\tdelta\ttolerance\tresult\n", r.Align)
+	for _, d := range r.Diffs {
+		if failedOnly && d.OK {
+			continue
+		}
+
+		result := "ok"
+		if d.Missing {
+			result = "MISSING"
+		} else if !d.OK {
+			result = "FAIL"
+		}
+
+		fmt.Fprintf(tw, "%s\t%s\t%.02f\t%.02f\t%+.02f\t%s\t%s\n",
+			d.Step, d.Metric, d.Run, d.Baseline, d.Delta, d.Tolerance, result)
+	}
+
+	for _, step := range r.Extra {
+		fmt.Fprintf(tw, "%s\t\t\t\t\t\tnot in baseline\n", step)
+	}
+
+	return tw.Flush()
+}
@@ -0,0 +1,143 @@
+package analysis
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestParseTolerance(t *testing.T) {
+	tests := []struct {
+		in string
+		want Tolerance
+		err bool
+	}{
+		{ "rpm=5%", Tolerance{ Metric: "rpm", Value: 0.05, Relative: true }, false },
+		{ " Thrust = 20 ", Tolerance{ Metric: "thrust", Value: 20 }, false },
+		{ "temp1=0", Tolerance{ Metric: "temp1" }, false },
+		{ "rpm", Tolerance{}, true },
+		{ "speed=5", Tolerance{}, true },
+		{ "rpm=x%", Tolerance{}, true },
+		{ "rpm=-1", Tolerance{}, true },
+	}
+
+	for _, tt := range tests {
+		got, err := ParseTolerance(tt.in)
+		if tt.err {
+			if err == nil {
+				t.Errorf("%q: no error", tt.in)
+			}
+			continue
+		} else if err != nil {
+			t.Errorf("%q: %v", tt.in, err)
+		} else if got != tt.want {
+			t.Errorf("%q: got %+v, want %+v", tt.in, got, tt.want)
+		}
+	}
+}
+
+func point(throttle, rpm float64, tag string) OperatingPoint {
+	return OperatingPoint{ Throttle: throttle, RPM: rpm, Tag: tag, Steady: 10 }
+}
+
+func TestCompare(t *testing.T) {
+	baseline := []OperatingPoint{ point(1200, 4000, "a"), point(1400, 6000, "b"), point(1600, 8000, "c") }
+	tols := []Tolerance{ { Metric: "rpm", Value: 0.05, Relative: true } }
+
+	tests := []struct {
+		name string
+		run []OperatingPoint
+		align string
+		ok bool
+		missing []string
+		extra []string
+	}{
+		{ "same", baseline, AlignThrottle, true, nil, nil },
+		{ "within tolerance", []OperatingPoint{ point(1200, 4100, ""), point(1400, 5800, ""), point(1600, 8300, "") }, AlignThrottle, true, nil, nil },
+		{ "out of tolerance", []OperatingPoint{ point(1200, 4000, ""), point(1400, 5000, ""), point(1600, 8000, "") }, AlignThrottle, false, nil, nil },
+		{ "missing step", baseline[:2], AlignThrottle, false, []string{ "1600" }, nil },
+		{ "extra step", append(append([]OperatingPoint{}, baseline...), point(1800, 9000, "d")), AlignThrottle, true, nil, []string{ "1800" } },
+		{ "by tag", []OperatingPoint{ point(1250, 4000, "a"), point(1450, 6000, "b"), point(1650, 8000, "c") }, AlignTag, true, nil, nil },
+		{ "empty run", nil, AlignThrottle, false, []string{ "1200", "1400", "1600" }, nil },
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			report, err := Compare(tt.run, baseline, tt.align, tols)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if report.OK != tt.ok {
+				t.Errorf("ok %v, want %v", report.OK, tt.ok)
+			}
+
+			var missing []string
+			for _, d := range report.Diffs {
+				if d.Missing {
+					missing = append(missing, d.Step)
+				}
+			}
+
+			if strings.Join(missing, ",") != strings.Join(tt.missing, ",") {
+				t.Errorf("missing %v, want %v", missing, tt.missing)
+			}
+
+			if strings.Join(report.Extra, ",") != strings.Join(tt.extra, ",") {
+				t.Errorf("extra %v, want %v", report.Extra, tt.extra)
+			}
+
+			var b bytes.Buffer
+			if err := report.WriteText(&b, false); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestCompareNoSteady(t *testing.T) {
+	// a hand-written baseline without the steady sample counts
+	baseline := []OperatingPoint{ { Throttle: 1200, RPM: 4000 }, { Throttle: 1400, RPM: 6000 }, { Throttle: 1400, RPM: 6200 } }
+	run := []OperatingPoint{ point(1200, 4000, ""), point(1400, 6100, "") }
+
+	report, err := Compare(run, baseline, AlignThrottle, []Tolerance{ { Metric: "rpm", Value: 0.01, Relative: true } })
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !report.OK {
+		t.Errorf("not ok: %+v", report.Diffs)
+	}
+
+	for _, d := range report.Diffs {
+		if want := map[string]float64{ "1200": 4000, "1400": 6100 }[d.Step]; d.Baseline != want {
+			t.Errorf("step %s: baseline %g, want %g", d.Step, d.Baseline, want)
+		}
+	}
+}
+
+func TestCompareErrors(t *testing.T) {
+	run := []OperatingPoint{ point(1200, 4000, "") }
+	tols := DefaultTolerances()
+
+	tests := []struct {
+		name string
+		baseline []OperatingPoint
+		align string
+		tols []Tolerance
+		err string
+	}{
+		{ "empty baseline", nil, AlignThrottle, tols, "no steady-state steps" },
+		{ "no tolerances", run, AlignThrottle, nil, "no tolerances" },
+		{ "bad align", run, "time", tols, "not supported" },
+		{ "bad metric", run, AlignThrottle, []Tolerance{ { Metric: "speed" } }, "unknown metric" },
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := Compare(run, tt.baseline, tt.align, tt.tols); err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("got error %v, want %q", err, tt.err)
+			}
+		})
+	}
+}
@@ -0,0 +1,174 @@
+package analysis
+
+import (
+	"io"
+	"fmt"
+	"sort"
+
+	"encoding/json"
+
+	"dronmotors/dmetrics/internal/plot"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+// Curve is a fitted characteristic of a motor built from operating points.
+type Curve struct {
+	Name   string    `json:"name"`
+	XLabel string    `json:"xLabel"`
+	YLabel string    `json:"yLabel"`
+	X      []float64 `json:"x"`
+	Y      []float64 `json:"y"`
+	Fit    *Fit      `json:"fit"`
+	Error  string    `json:"error,omitempty"` // why there is no fit
+	Level  float64   `json:"level"`
+	Band   []Band    `json:"band,omitempty"`
+}
+
+// Band is the fitted value at x with the confidence interval of the curve
+// level, the interval is nil if it is undefined (no residual degrees of
+// freedom)
+type Band struct {
+	X  float64  `json:"x"`
+	Y  float64  `json:"y"`
+	Lo *float64 `json:"lo,omitempty"`
+	Hi *float64 `json:"hi,omitempty"`
+}
+
+const bandSteps = 100
+
+type curveDef struct {
+	name, xlabel, ylabel string
+	x, y func(OperatingPoint) float64
+}
+
+var curveDefs = []curveDef{
+	{
+		"thrust-throttle", "throttle (µs)", "thrust",
+		func(op OperatingPoint) float64 { return op.Throttle },
+		func(op OperatingPoint) float64 { return op.Thrust },
+	},
+	{
+		"thrust-power", "power (W)", "thrust",
+		func(op OperatingPoint) float64 { return op.P },
+		func(op OperatingPoint) float64 { return op.Thrust },
+	},
+	{
+		"efficiency-thrust", "thrust", "efficiency (thrust/W)",
+		func(op OperatingPoint) float64 { return op.Thrust },
+		func(op OperatingPoint) float64 { return op.Efficiency },
+	},
+	{
+		"rpm-voltage", "voltage (V)", "r/min",
+		func(op OperatingPoint) float64 { return op.U },
+		func(op OperatingPoint) float64 { return op.RPM },
+	},
+}
+
+// Curves fits every datasheet characteristic with a polynomial of the given
+// degree. Characteristics with too few or degenerate points are returned
+// without a fit, the error says why.
+func Curves(points []OperatingPoint, degree int, level float64) []Curve {
+	var res []Curve
+
+	for _, def := range curveDefs {
+		c := Curve{
+			Name: def.name,
+			XLabel: def.xlabel,
+			YLabel: def.ylabel,
+			Level: level,
+		}
+
+		sorted := append([]OperatingPoint{}, points...)
+		sort.SliceStable(sorted, func(i, j int) bool {
+			return def.x(sorted[i]) < def.x(sorted[j])
+		})
+
+		for _, op := range sorted {
+			c.X = append(c.X, def.x(op))
+			c.Y = append(c.Y, def.y(op))
+		}
+
+		if fit, err := PolyFit(c.X, c.Y, degree); err != nil {
+			c.Error = err.Error()
+		} else {
+			c.Fit = fit
+			c.Band = fit.band(c.X[0], c.X[len(c.X) - 1], level)
+		}
+
+		res = append(res, c)
+	}
+
+	return res
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+type Datasheet struct {
+	Motor  string           `json:"motor"`
+	Source string           `json:"source"`
+	Points []OperatingPoint `json:"points"`
+	Curves []Curve          `json:"curves"`
+}
+
+func (d Datasheet) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(d)
+}
+
+// band samples the fit over [x0, x1], without residual degrees of freedom
+// the interval is unknown and left out
+func (f Fit) band(x0, x1, level float64) []Band {
+	if x0 == x1 {
+		return nil
+	}
+
+	var res []Band
+	for i := 0; i <= bandSteps; i++ {
+		x := x0 + (x1 - x0) * float64(i) / bandSteps
+		b := Band{ X: x, Y: f.Eval(x) }
+		if d := f.Interval(x, level); finite(d) {
+			lo, hi := b.Y - d, b.Y + d
+			b.Lo, b.Hi = &lo, &hi
+		}
+		res = append(res, b)
+	}
+	return res
+}
+
+// Chart renders measured points, the fitted curve and its confidence band
+// where it is defined.
+func (c Curve) Chart() plot.Chart {
+	chart := plot.Chart{
+		Title: c.Name,
+		XLabel: c.XLabel,
+		YLabel: c.YLabel,
+	}
+
+	if len(c.Band) > 0 {
+		fit := plot.Series{ Name: "fit", Style: plot.StyleLine }
+		band := plot.Series{ Name: fmt.Sprintf("%g%% CI", c.Level * 100), Style: plot.StyleBand }
+
+		for _, b := range c.Band {
+			fit.X, fit.Y = append(fit.X, b.X), append(fit.Y, b.Y)
+			if b.Lo != nil {
+				band.X, band.Y, band.Y2 = append(band.X, b.X), append(band.Y, *b.Lo), append(band.Y2, *b.Hi)
+			}
+		}
+
+		if len(band.X) > 0 {
+			chart.Series = append(chart.Series, band)
+		}
+		chart.Series = append(chart.Series, fit)
+	}
+
+	chart.Series = append(chart.Series, plot.Series{
+		Name: "measured",
+		Style: plot.StylePoints,
+		X: c.X,
+		Y: c.Y,
+	})
+
+	return chart
+}
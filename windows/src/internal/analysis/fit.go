@@ -0,0 +1,295 @@
+package analysis
+
+import (
+	"math"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+// Fit is a least squares polynomial y = c0 + c1*u + ... + cn*u^n of the
+// normalized u = (x - Center) / Scale, which keeps the normal equations well
+// conditioned for x like throttle in µs.
+type Fit struct {
+	Coeffs []float64   `json:"coeffs"`
+	Center float64     `json:"center"`
+	Scale  float64     `json:"scale"`
+	Sigma  float64     `json:"sigma"` // residual standard error
+	R2     float64     `json:"r2"`
+	DOF    int         `json:"dof"`
+	Cov    [][]float64 `json:"cov"`   // (UᵀU)⁻¹, times Sigma² gives coefficient covariance
+}
+
+func PolyFit(x, y []float64, degree int) (*Fit, error) {
+	n, m := len(x), degree + 1
+
+	if len(y) != n {
+		return nil, errorf("fit: x/y length mismatch")
+	} else if degree < 0 {
+		return nil, errorf("fit: negative degree")
+	} else if n < m {
+		return nil, errorf("fit: %d points are not enough for degree %d", n, degree)
+	}
+
+	for k := 0; k < n; k++ {
+		if !finite(x[k]) || !finite(y[k]) {
+			return nil, errorf("fit: point %d is not finite", k)
+		}
+	}
+
+	// map x to [-1, 1]
+	lo, hi := x[0], x[0]
+	for _, v := range x {
+		lo, hi = math.Min(lo, v), math.Max(hi, v)
+	}
+
+	f := &Fit{
+		Coeffs: make([]float64, m),
+		Center: (lo + hi) / 2,
+		Scale: (hi - lo) / 2,
+		DOF: n - m,
+	}
+
+	if f.Scale == 0 {
+		if degree > 0 {
+			return nil, errorf("fit: all points have x = %g", lo)
+		}
+		f.Scale = 1
+	}
+
+	// normal equations: (UᵀU) c = Uᵀy
+	a := make([][]float64, m)
+	b := make([]float64, m)
+	for i := range a {
+		a[i] = make([]float64, m)
+	}
+
+	for k := 0; k < n; k++ {
+		row := powers(f.normalize(x[k]), m)
+		for i := 0; i < m; i++ {
+			b[i] += row[i] * y[k]
+			for j := 0; j < m; j++ {
+				a[i][j] += row[i] * row[j]
+			}
+		}
+	}
+
+	inv, err := invert(a)
+	if err != nil {
+		return nil, err
+	}
+
+	f.Cov = inv
+	for i := 0; i < m; i++ {
+		for j := 0; j < m; j++ {
+			f.Coeffs[i] += inv[i][j] * b[j]
+		}
+		if !finite(f.Coeffs[i]) {
+			return nil, errorf("fit: degenerate points")
+		}
+	}
+
+	mean := 0.0
+	for _, v := range y {
+		mean += v / float64(n)
+	}
+
+	sse, sst := 0.0, 0.0
+	for k := 0; k < n; k++ {
+		d := y[k] - f.Eval(x[k])
+		sse += d * d
+		sst += (y[k] - mean) * (y[k] - mean)
+	}
+
+	if f.DOF > 0 {
+		f.Sigma = math.Sqrt(sse / float64(f.DOF))
+	}
+
+	if sst > 0 {
+		f.R2 = 1 - sse / sst
+	}
+
+	return f, nil
+}
+
+func finite(v float64) bool {
+	return !math.IsNaN(v) && !math.IsInf(v, 0)
+}
+
+func (f Fit) normalize(x float64) float64 {
+	return (x - f.Center) / f.Scale
+}
+
+func (f Fit) Eval(x float64) float64 {
+	x = f.normalize(x)
+	y := 0.0
+	for i := len(f.Coeffs) - 1; i >= 0; i-- {
+		y = y * x + f.Coeffs[i]
+	}
+	return y
+}
+
+// Interval returns the half-width of the confidence interval of the fitted
+// mean at x for the given confidence level (e.g. 0.95).
+func (f Fit) Interval(x, level float64) float64 {
+	if f.DOF <= 0 {
+		return math.Inf(1)
+	}
+
+	row := powers(f.normalize(x), len(f.Coeffs))
+	q := 0.0
+	for i := range row {
+		for j := range row {
+			q += row[i] * f.Cov[i][j] * row[j]
+		}
+	}
+
+	return studentQuantile(1 - (1 - level) / 2, f.DOF) * f.Sigma * math.Sqrt(math.Max(q, 0))
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+func powers(x float64, m int) []float64 {
+	row := make([]float64, m)
+	p := 1.0
+	for i := range row {
+		row[i] = p
+		p *= x
+	}
+	return row
+}
+
+// gauss-jordan with partial pivoting, a pivot negligible against the
+// largest diagonal element means the matrix is singular
+func invert(a [][]float64) ([][]float64, error) {
+	n := len(a)
+
+	norm := 0.0
+	for i := range a {
+		norm = math.Max(norm, math.Abs(a[i][i]))
+	}
+	m := make([][]float64, n)
+	for i := range m {
+		m[i] = make([]float64, 2 * n)
+		copy(m[i], a[i])
+		m[i][n + i] = 1
+	}
+
+	for c := 0; c < n; c++ {
+		p := c
+		for r := c + 1; r < n; r++ {
+			if math.Abs(m[r][c]) > math.Abs(m[p][c]) {
+				p = r
+			}
+		}
+
+		if math.Abs(m[p][c]) <= 1e-12 * norm {
+			return nil, errorf("fit: singular matrix")
+		}
+
+		m[c], m[p] = m[p], m[c]
+
+		d := m[c][c]
+		for j := range m[c] {
+			m[c][j] /= d
+		}
+
+		for r := 0; r < n; r++ {
+			if r != c && m[r][c] != 0 {
+				k := m[r][c]
+				for j := range m[r] {
+					m[r][j] -= k * m[c][j]
+				}
+			}
+		}
+	}
+
+	inv := make([][]float64, n)
+	for i := range inv {
+		inv[i] = m[i][n:]
+	}
+
+	return inv, nil
+}
+
+////////////////////////////////////////////////////////////////////////////////
+// student's t distribution
+////////////////////////////////////////////////////////////////////////////////
+
+func studentCDF(t float64, dof int) float64 {
+	v := float64(dof)
+	p := 0.5 * betaInc(v / 2, 0.5, v / (v + t * t))
+	if t > 0 {
+		return 1 - p
+	}
+	return p
+}
+
+func studentQuantile(p float64, dof int) float64 {
+	lo, hi := -1e3, 1e3
+	for i := 0; i < 200; i++ {
+		mid := (lo + hi) / 2
+		if studentCDF(mid, dof) < p {
+			lo = mid
+		} else {
+			hi = mid
+		}
+	}
+	return (lo + hi) / 2
+}
+
+// regularized incomplete beta function I_x(a, b)
+func betaInc(a, b, x float64) float64 {
+	if x <= 0 {
+		return 0
+	} else if x >= 1 {
+		return 1
+	}
+
+	la, _ := math.Lgamma(a)
+	lb, _ := math.Lgamma(b)
+	lab, _ := math.Lgamma(a + b)
+	front := math.Exp(lab - la - lb + a * math.Log(x) + b * math.Log(1 - x))
+
+	if x < (a + 1) / (a + b + 2) {
+		return front * betaCF(a, b, x) / a
+	}
+	return 1 - front * betaCF(b, a, 1 - x) / b
+}
+
+// continued fraction for betaInc (modified Lentz)
+func betaCF(a, b, x float64) float64 {
+	const eps, tiny = 1e-14, 1e-300
+
+	c, d := 1.0, 1 - (a + b) * x / (a + 1)
+	if math.Abs(d) < tiny {
+		d = tiny
+	}
+	d = 1 / d
+	h := d
+
+	for m := 1; m <= 300; m++ {
+		fm := float64(m)
+
+		for _, num := range []float64{
+			fm * (b - fm) * x / ((a + 2 * fm - 1) * (a + 2 * fm)),
+			-(a + fm) * (a + b + fm) * x / ((a + 2 * fm) * (a + 2 * fm + 1)),
+		} {
+			d = 1 + num * d
+			if math.Abs(d) < tiny {
+				d = tiny
+			}
+			c = 1 + num / c
+			if math.Abs(c) < tiny {
+				c = tiny
+			}
+			d = 1 / d
+			h *= d * c
+		}
+
+		if math.Abs(d * c - 1) < eps {
+			break
+		}
+	}
+
+	return h
+}
@@ -0,0 +1,184 @@
+package analysis
+
+import (
+	"math"
+	"bytes"
+	"strings"
+	"testing"
+
+	"dronmotors/dmetrics/internal/plot"
+)
+
+func TestPolyFit(t *testing.T) {
+	tests := []struct {
+		name string
+		degree int
+		f func(x float64) float64
+	}{
+		{ "constant", 0, func(x float64) float64 { return 3 } },
+		{ "line", 1, func(x float64) float64 { return 2 * x - 1000 } },
+		{ "quadratic", 2, func(x float64) float64 { return 1e-3 * x * x - 1.5 * x + 600 } },
+		{ "cubic on throttle", 3, func(x float64) float64 { return 1e-6 * x * x * x - 3e-3 * x * x + 2 * x } },
+		{ "quartic on throttle", 4, func(x float64) float64 { u := (x - 1500) / 500; return u * u * u * u - u + 7 } },
+	}
+
+	// throttle steps in µs, where the raw normal equations break down
+	var x []float64
+	for v := 1000.0; v <= 2000; v += 50 {
+		x = append(x, v)
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			y := make([]float64, len(x))
+			for i, v := range x {
+				y[i] = tt.f(v)
+			}
+
+			fit, err := PolyFit(x, y, tt.degree)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for _, v := range []float64{ 1000, 1234, 1500, 1999 } {
+				if got, want := fit.Eval(v), tt.f(v); math.Abs(got - want) > 1e-6 * (1 + math.Abs(want)) {
+					t.Errorf("f(%g) = %g, want %g", v, got, want)
+				}
+			}
+
+			if fit.Sigma > 1e-6 {
+				t.Errorf("sigma %g of an exact fit", fit.Sigma)
+			}
+
+			if fit.DOF != len(x) - tt.degree - 1 {
+				t.Errorf("dof %d", fit.DOF)
+			}
+		})
+	}
+}
+
+func TestPolyFitErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		x, y []float64
+		degree int
+		err string
+	}{
+		{ "length mismatch", []float64{ 1, 2 }, []float64{ 1 }, 1, "mismatch" },
+		{ "negative degree", []float64{ 1 }, []float64{ 1 }, -1, "negative" },
+		{ "too few points", []float64{ 1, 2 }, []float64{ 1, 2 }, 2, "not enough" },
+		{ "same x", []float64{ 1500, 1500, 1500 }, []float64{ 1, 2, 3 }, 1, "all points" },
+		{ "repeated x", []float64{ 1000, 1000, 2000, 2000 }, []float64{ 1, 2, 3, 4 }, 2, "singular" },
+		{ "nan", []float64{ 1, 2, 3 }, []float64{ 1, math.NaN(), 3 }, 1, "not finite" },
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := PolyFit(tt.x, tt.y, tt.degree); err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("got error %v, want %q", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestStudentQuantile(t *testing.T) {
+	// two-sided 95% critical values
+	tests := []struct {
+		dof int
+		want float64
+	}{
+		{ 1, 12.706 },
+		{ 2, 4.303 },
+		{ 5, 2.571 },
+		{ 10, 2.228 },
+		{ 30, 2.042 },
+		{ 1000, 1.962 },
+	}
+
+	for _, tt := range tests {
+		if got := studentQuantile(0.975, tt.dof); math.Abs(got - tt.want) > 1e-3 {
+			t.Errorf("dof %d: got %.4f, want %.3f", tt.dof, got, tt.want)
+		}
+	}
+
+	if got := studentQuantile(0.5, 7); math.Abs(got) > 1e-6 {
+		t.Errorf("median %g", got)
+	}
+}
+
+func TestInterval(t *testing.T) {
+	x := []float64{ 1000, 1100, 1200, 1300, 1400, 1500 }
+	y := []float64{ 10, 21, 29, 41, 50, 61 }
+
+	fit, err := PolyFit(x, y, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the band is narrowest in the middle of the data
+	mid, edge := fit.Interval(1250, 0.95), fit.Interval(1000, 0.95)
+	if !(mid > 0 && edge > mid) || !finite(edge) {
+		t.Errorf("interval mid %g, edge %g", mid, edge)
+	}
+
+	exact, _ := PolyFit(x[:2], y[:2], 1)
+	if !math.IsInf(exact.Interval(1000, 0.95), 1) {
+		t.Errorf("interval without degrees of freedom must be unknown")
+	}
+}
+
+func TestCurvesJSON(t *testing.T) {
+	points := []OperatingPoint{
+		{ Throttle: 1200, Thrust: 100, P: 20, U: 16, RPM: 4000, Efficiency: 5 },
+		{ Throttle: 1400, Thrust: 300, P: 55, U: 16, RPM: 6000, Efficiency: 5.4 },
+		{ Throttle: 1600, Thrust: 550, P: 110, U: 15.9, RPM: 8000, Efficiency: 5 },
+		{ Throttle: 1800, Thrust: 800, P: 190, U: 15.8, RPM: 9500, Efficiency: 4.2 },
+	}
+
+	var b bytes.Buffer
+	d := Datasheet{ Points: points, Curves: Curves(points, 2, 0.95) }
+	if err := d.WriteJSON(&b); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, c := range d.Curves {
+		if (c.Fit == nil) == (len(c.Error) == 0) {
+			t.Errorf("%s: fit %v, error %q", c.Name, c.Fit, c.Error)
+		}
+
+		if c.Name == "thrust-throttle" {
+			if c.Fit == nil || len(c.Band) != bandSteps + 1 {
+				t.Errorf("%s: fit %v, band %d", c.Name, c.Fit, len(c.Band))
+			} else if b := c.Band[bandSteps / 2]; b.Lo == nil || !(*b.Lo < b.Y && b.Y < *b.Hi) {
+				t.Errorf("%s: band %+v", c.Name, b)
+			}
+		}
+	}
+
+	few := Curves(points[:2], 2, 0.95)
+	if few[0].Fit != nil || !strings.Contains(few[0].Error, "not enough") {
+		t.Errorf("fit of two points: %+v", few[0])
+	}
+
+	if !strings.Contains(b.String(), `"band"`) {
+		t.Errorf("no band in json")
+	}
+
+	// an exact fit has no interval, not a zero-width one
+	exact := Curves(points[:3], 2, 0.95)[0]
+	if exact.Fit == nil || exact.Fit.DOF != 0 || len(exact.Band) == 0 {
+		t.Fatalf("fit of three points: %+v", exact)
+	}
+
+	for _, b := range exact.Band {
+		if b.Lo != nil || b.Hi != nil {
+			t.Fatalf("band %+v of an exact fit", b)
+		}
+	}
+
+	for _, s := range exact.Chart().Series {
+		if s.Style == plot.StyleBand {
+			t.Errorf("band drawn for an exact fit")
+		}
+	}
+}
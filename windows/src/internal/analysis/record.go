@@ -0,0 +1,110 @@
+package analysis
+
+import (
+	"io"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"encoding/csv"
+)
+
+func errorf(t string, args ...interface{}) error {
+	return fmt.Errorf("analysis: " + t, args...)
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// Record is a single telemetry sample of a saved run. Thrust and torque are
+// taken from the load cells: thrust is load1, torque is the mean of load2 and
+// load3.
+type Record struct {
+	Ts       float64
+	Throttle float64
+	RPM      float64
+	I        float64
+	U        float64
+	P        float64
+	Thrust   float64
+	Torque   float64
+	Temp1    float64
+	Temp2    float64
+	Tag      string
+}
+
+// RecordFields maps field names to record values, e.g. for plotting
+var RecordFields = map[string]func(Record) float64{
+	"throttle": func(r Record) float64 { return r.Throttle },
+	"rpm":      func(r Record) float64 { return r.RPM },
+	"current":  func(r Record) float64 { return r.I },
+	"voltage":  func(r Record) float64 { return r.U },
+	"power":    func(r Record) float64 { return r.P },
+	"thrust":   func(r Record) float64 { return r.Thrust },
+	"torque":   func(r Record) float64 { return r.Torque },
+	"temp1":    func(r Record) float64 { return r.Temp1 },
+	"temp2":    func(r Record) float64 { return r.Temp2 },
+}
+
+func ReadCSV(r io.Reader) ([]Record, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+
+	rows, err := reader.ReadAll()
+	if err != nil {
+		return nil, err
+	} else if len(rows) == 0 {
+		return nil, errorf("no header found")
+	}
+
+	cols := map[string]int{}
+	for i, key := range rows[0] {
+		cols[strings.ToLower(strings.TrimSpace(key))] = i
+	}
+
+	for _, key := range []string{ "ts", "throttle", "motorrpm", "motori", "motoru", "motorp" } {
+		if _, ok := cols[key]; !ok {
+			return nil, errorf("column %q not found", key)
+		}
+	}
+
+	records := make([]Record, 0, len(rows) - 1)
+	for n, row := range rows[1:] {
+		num := func(key string) float64 {
+			if i, ok := cols[key]; !ok || i >= len(row) {
+				return 0
+			} else if v, e := strconv.ParseFloat(strings.TrimSpace(row[i]), 64); e != nil {
+				if err == nil {
+					err = errorf("line %d: %s: %v", n + 2, key, e)
+				}
+				return 0
+			} else {
+				return v
+			}
+		}
+
+		rec := Record{
+			Ts: num("ts"),
+			Throttle: num("throttle"),
+			RPM: num("motorrpm"),
+			I: num("motori"),
+			U: num("motoru"),
+			P: num("motorp"),
+			Thrust: num("load1"),
+			Torque: (num("load2") + num("load3")) / 2,
+			Temp1: num("temp1"),
+			Temp2: num("temp2"),
+		}
+
+		if i, ok := cols["tag"]; ok && i < len(row) {
+			rec.Tag = row[i]
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		records = append(records, rec)
+	}
+
+	return records, nil
+}
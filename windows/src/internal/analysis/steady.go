@@ -0,0 +1,114 @@
+package analysis
+
+import (
+	"math"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+// Metric is a signal checked for settling. A segment is considered steady once
+// the spread (max - min) of every metric within the window drops below
+// Tolerance * |mean| + Floor.
+type Metric struct {
+	Name  string
+	Value func(Record) float64
+	Floor float64
+}
+
+var DefaultMetrics = []Metric{
+	{ Name: "rpm", Value: func(r Record) float64 { return r.RPM }, Floor: 50 },
+	{ Name: "current", Value: func(r Record) float64 { return r.I }, Floor: 0.1 },
+	{ Name: "thrust", Value: func(r Record) float64 { return r.Thrust }, Floor: 5 },
+}
+
+type Options struct {
+	Window    int     // samples
+	Tolerance float64 // relative
+	Metrics   []Metric
+}
+
+func DefaultOptions() Options {
+	return Options{
+		Window: 20,
+		Tolerance: 0.02,
+		Metrics: DefaultMetrics,
+	}
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// Segment is a contiguous part of a run with the same throttle setpoint and tag.
+type Segment struct {
+	Throttle float64
+	Tag      string
+	Records  []Record
+}
+
+func Segments(records []Record) []Segment {
+	var res []Segment
+
+	for i := 0; i < len(records); {
+		j := i + 1
+		for j < len(records) && records[j].Throttle == records[i].Throttle && records[j].Tag == records[i].Tag {
+			j++
+		}
+
+		res = append(res, Segment{
+			Throttle: records[i].Throttle,
+			Tag: records[i].Tag,
+			Records: records[i:j],
+		})
+
+		i = j
+	}
+
+	return res
+}
+
+func (o Options) settled(records []Record) bool {
+	for _, m := range o.Metrics {
+		lo, hi, sum := math.Inf(1), math.Inf(-1), 0.0
+		for _, r := range records {
+			v := m.Value(r)
+			lo, hi, sum = math.Min(lo, v), math.Max(hi, v), sum + v
+		}
+
+		mean := sum / float64(len(records))
+		if hi - lo > o.Tolerance * math.Abs(mean) + m.Floor {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Steady returns the index of the first record from which the segment stays
+// settled, or -1 if the segment never settles.
+func (o Options) Steady(seg Segment) int {
+	if o.Window <= 0 || len(seg.Records) < o.Window {
+		return -1
+	}
+
+	for i := 0; i + o.Window <= len(seg.Records); i++ {
+		if !o.settled(seg.Records[i:i + o.Window]) {
+			continue
+		}
+
+		// the rest of the segment must not drift away either, a partial
+		// window at the end is checked as the last whole one
+		ok := true
+		for j := i + o.Window; j < len(seg.Records) && ok; j += o.Window {
+			end := j + o.Window
+			if end > len(seg.Records) {
+				j, end = len(seg.Records) - o.Window, len(seg.Records)
+			}
+			ok = o.settled(seg.Records[j:end])
+		}
+
+		if ok {
+			return i
+		}
+	}
+
+	return -1
+}
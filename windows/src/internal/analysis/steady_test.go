@@ -0,0 +1,147 @@
+package analysis
+
+import (
+	"strings"
+	"testing"
+)
+
+func records(throttle float64, tag string, rpm ...float64) []Record {
+	var res []Record
+	for _, v := range rpm {
+		res = append(res, Record{ Throttle: throttle, Tag: tag, RPM: v, I: 1, Thrust: 100 })
+	}
+	return res
+}
+
+func repeat(v float64, n int) []float64 {
+	res := make([]float64, n)
+	for i := range res {
+		res[i] = v
+	}
+	return res
+}
+
+func TestSegments(t *testing.T) {
+	var recs []Record
+	recs = append(recs, records(1000, "idle", 0, 0)...)
+	recs = append(recs, records(1200, "idle", 1, 2, 3)...)
+	recs = append(recs, records(1200, "step", 4)...)
+	recs = append(recs, records(1000, "idle", 5)...)
+
+	segs := Segments(recs)
+
+	want := []struct {
+		throttle float64
+		tag string
+		n int
+	}{
+		{ 1000, "idle", 2 },
+		{ 1200, "idle", 3 },
+		{ 1200, "step", 1 },
+		{ 1000, "idle", 1 },
+	}
+
+	if len(segs) != len(want) {
+		t.Fatalf("got %d segments, want %d", len(segs), len(want))
+	}
+
+	for i, w := range want {
+		if s := segs[i]; s.Throttle != w.throttle || s.Tag != w.tag || len(s.Records) != w.n {
+			t.Errorf("segment %d: got %v/%s/%d, want %v/%s/%d", i, s.Throttle, s.Tag, len(s.Records), w.throttle, w.tag, w.n)
+		}
+	}
+
+	if len(Segments(nil)) != 0 {
+		t.Errorf("segments of no records")
+	}
+}
+
+func TestSteady(t *testing.T) {
+	ramp := []float64{ 0, 1000, 2000, 3000, 4000 }
+
+	tests := []struct {
+		name string
+		rpm []float64
+		want int
+	}{
+		{ "flat", repeat(5000, 40), 0 },
+		{ "ramp then flat", append(append([]float64{}, ramp...), repeat(5000, 40)...), len(ramp) },
+		{ "too short", repeat(5000, 10), -1 },
+		{ "never settles", func() []float64 {
+			var v []float64
+			for i := 0; i < 60; i++ {
+				v = append(v, float64(i * 200))
+			}
+			return v
+		}(), -1 },
+		{ "drifts away", func() []float64 {
+			v := repeat(5000, 20)
+			for i := 0; i < 40; i++ {
+				v = append(v, 5000 + float64(i * 100))
+			}
+			return v
+		}(), -1 },
+		{ "drifts in the tail", func() []float64 {
+			v := repeat(5000, 45)
+			for i := 1; i <= 10; i++ {
+				v = append(v, 5000 + float64(i * 200))
+			}
+			return v
+		}(), -1 },
+		{ "noise within tolerance", func() []float64 {
+			var v []float64
+			for i := 0; i < 40; i++ {
+				v = append(v, 5000 + float64(i % 3) * 30)
+			}
+			return v
+		}(), 0 },
+	}
+
+	opts := DefaultOptions()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			seg := Segments(records(1500, "", tt.rpm...))[0]
+			if got := opts.Steady(seg); got != tt.want {
+				t.Errorf("got %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReadCSV(t *testing.T) {
+	tests := []struct {
+		name string
+		csv string
+		n int
+		err string
+	}{
+		{ "ok", "ts,throttle,motorRPM,motorI,motorU,motorP,load1,load2,load3,tag\n10,1200,5000,1.5,16,24,300,10,20,hover\n", 1, "" },
+		{ "with brake", "ts,load1,load2,load3,temp1,temp2,temp3,brake,motorI,motorU,motorP,motorRPM,throttle,gyroX,gyroY,gyroZ,tag\n10,300,10,20,40.00,25.00,0.00,150,1.50,16.00,24.00,5000,1200,0,0,0,hover\n", 1, "" },
+		{ "missing column", "ts,throttle\n10,1200\n", 0, "column \"motorrpm\" not found" },
+		{ "bad number", "ts,throttle,motorRPM,motorI,motorU,motorP\n10,x,1,1,1,1\n", 0, "line 2: throttle" },
+		{ "empty", "", 0, "no header" },
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			recs, err := ReadCSV(strings.NewReader(tt.csv))
+			if len(tt.err) > 0 {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("got error %v, want %q", err, tt.err)
+				}
+				return
+			} else if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(recs) != tt.n {
+				t.Fatalf("got %d records, want %d", len(recs), tt.n)
+			}
+
+			r := recs[0]
+			if r.Throttle != 1200 || r.RPM != 5000 || r.Thrust != 300 || r.Torque != 15 || r.Tag != "hover" {
+				t.Errorf("got %+v", r)
+			}
+		})
+	}
+}
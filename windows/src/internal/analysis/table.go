@@ -0,0 +1,136 @@
+package analysis
+
+import (
+	"io"
+	"fmt"
+
+	"encoding/csv"
+	"encoding/json"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+// OperatingPoint is the steady-state average of a single throttle step.
+// Efficiency is thrust per watt of electrical power.
+type OperatingPoint struct {
+	Throttle   float64 `json:"throttle"`
+	Tag        string  `json:"tag"`
+	Samples    int     `json:"samples"`
+	Steady     int     `json:"steady"`
+	RPM        float64 `json:"rpm"`
+	I          float64 `json:"current"`
+	U          float64 `json:"voltage"`
+	P          float64 `json:"power"`
+	Thrust     float64 `json:"thrust"`
+	Torque     float64 `json:"torque"`
+	Temp1      float64 `json:"temp1"`
+	Temp2      float64 `json:"temp2"`
+	Efficiency float64 `json:"efficiency"`
+}
+
+func average(seg Segment, from int) OperatingPoint {
+	op := OperatingPoint{
+		Throttle: seg.Throttle,
+		Tag: seg.Tag,
+		Samples: len(seg.Records),
+		Steady: len(seg.Records) - from,
+	}
+
+	for _, r := range seg.Records[from:] {
+		op.RPM += r.RPM
+		op.I += r.I
+		op.U += r.U
+		op.P += r.P
+		op.Thrust += r.Thrust
+		op.Torque += r.Torque
+		op.Temp1 += r.Temp1
+		op.Temp2 += r.Temp2
+	}
+
+	n := float64(op.Steady)
+	op.RPM /= n
+	op.I /= n
+	op.U /= n
+	op.P /= n
+	op.Thrust /= n
+	op.Torque /= n
+	op.Temp1 /= n
+	op.Temp2 /= n
+
+	if op.P > 0 {
+		op.Efficiency = op.Thrust / op.P
+	}
+
+	return op
+}
+
+// Table segments a run by throttle setpoint and tag and averages the steady
+// part of every segment. Segments which never settle are skipped.
+func Table(records []Record, opts Options) []OperatingPoint {
+	res := []OperatingPoint{}
+
+	for _, seg := range Segments(records) {
+		if from := opts.Steady(seg); from >= 0 {
+			res = append(res, average(seg, from))
+		}
+	}
+
+	return res
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+func WriteCSV(w io.Writer, points []OperatingPoint) error {
+	writer := csv.NewWriter(w)
+	defer writer.Flush()
+
+	data := [][]string{
+		{ "throttle", "tag", "samples", "steady", "rpm", "current", "voltage", "power", "thrust", "torque", "temp1", "temp2", "efficiency" },
+	}
+
+	for _, op := range points {
+		data = append(data, []string{
+			fmt.Sprintf("%.0f", op.Throttle),
+			op.Tag,
+			fmt.Sprintf("%d", op.Samples),
+			fmt.Sprintf("%d", op.Steady),
+			fmt.Sprintf("%.0f", op.RPM),
+			fmt.Sprintf("%.02f", op.I),
+			fmt.Sprintf("%.02f", op.U),
+			fmt.Sprintf("%.02f", op.P),
+			fmt.Sprintf("%.02f", op.Thrust),
+			fmt.Sprintf("%.02f", op.Torque),
+			fmt.Sprintf("%.02f", op.Temp1),
+			fmt.Sprintf("%.02f", op.Temp2),
+			fmt.Sprintf("%.03f", op.Efficiency),
+		})
+	}
+
+	return writer.WriteAll(data)
+}
+
+func WriteJSON(w io.Writer, points []OperatingPoint) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(points)
+}
+
+// ReadJSON accepts both a bare point table and a datasheet.
+func ReadJSON(r io.Reader) ([]OperatingPoint, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var points []OperatingPoint
+	if err := json.Unmarshal(data, &points); err == nil {
+		return points, nil
+	}
+
+	var sheet Datasheet
+	if err := json.Unmarshal(data, &sheet); err != nil {
+		return nil, err
+	}
+
+	return sheet.Points, nil
+}
@@ -0,0 +1,284 @@
+// Package bridge publishes the stand to an MQTT broker for SCADA systems:
+//
+//	<prefix>/<device id>/status          online, offline (retained)
+//	<prefix>/<device id>/telemetry       telemetry records, json
+//	<prefix>/<device id>/events          markers of the runs, json
+//	<prefix>/<device id>/command         {"cmd": "throttle", "args": [1200]}
+//	<prefix>/<device id>/command/result  {"cmd": ..., "result": ...} or {"cmd": ..., "error": ...}
+//
+// Commands are accepted only when Options.Control is set, and only over a
+// tls:// broker connection unless Options.InsecureControl: anyone on the way
+// to a plain broker could drive the stand. Control validates them and
+// refuses what is unsafe at the moment: Server.Control refuses actuator
+// commands other than a stop while a run is in progress.
+package bridge
+
+import (
+	"fmt"
+	"sync"
+	"time"
+	"bytes"
+	"context"
+	"strings"
+
+	"crypto/tls"
+	"encoding/json"
+
+	"dronmotors/dmetrics/internal/device"
+	"dronmotors/dmetrics/internal/session"
+	"dronmotors/dmetrics/pkg/mqtt"
+
+	dms "dronmotors/dmetrics/internal/script"
+)
+
+const DefaultPrefix = "stand"
+
+const retryInterval = 5 * time.Second
+
+func errorf(t string, args ...interface{}) error {
+	return fmt.Errorf("mqtt: " + t, args...)
+}
+
+type Options struct {
+	Broker   string // host[:port], tls://host[:port] over tls
+	Prefix   string // topic prefix, DefaultPrefix if empty
+	Id       string // device id in the topics, the device identification if empty
+	ClientId string // dm-cli-<device id> if empty
+	Username string
+	Password string
+	TLS      *tls.Config // of a tls:// broker, nil - the system roots
+
+	// Control executes commands of the command topic, nil to ignore them
+	Control func(cmd string, args ...dms.Value) (interface{}, error)
+	InsecureControl bool // accept commands over a plain connection
+	Logger dms.Logger
+}
+
+// Bridge is a bus subscriber, it keeps the broker connection until Close
+type Bridge struct {
+	sync.Mutex
+
+	opts Options
+	topic string // <prefix>/<device id>
+	client *mqtt.Client
+
+	cancel context.CancelFunc
+	wg sync.WaitGroup
+}
+
+// TopicId makes a device id usable as a single topic level
+func TopicId(id string) string {
+	id = strings.Map(func(r rune) rune {
+		switch r {
+		case '/', '+', '#', ' ':
+			return '_'
+		default:
+			return r
+		}
+	}, id)
+
+	if len(id) == 0 {
+		return "unknown"
+	}
+	return id
+}
+
+// New connects to the broker on behalf of a connected device, later losses
+// of the broker are retried in the background
+func New(ctx context.Context, dev device.Device, opts Options) (*Bridge, error) {
+	if opts.Control != nil && !opts.InsecureControl && !mqtt.Secure(opts.Broker) {
+		return nil, errorf("commands are accepted over tls only, use a tls:// broker")
+	}
+
+	if len(opts.Prefix) == 0 {
+		opts.Prefix = DefaultPrefix
+	}
+
+	id := opts.Id
+	if len(id) == 0 {
+		id = dev.Id()
+	}
+
+	id = TopicId(id)
+	if len(opts.ClientId) == 0 {
+		opts.ClientId = "dm-cli-" + id
+	}
+
+	b := &Bridge{
+		opts: opts,
+		topic: opts.Prefix + "/" + id,
+	}
+
+	client, err := b.dial()
+	if err != nil {
+		return nil, err
+	}
+
+	b.client = client
+
+	ctx, b.cancel = context.WithCancel(ctx)
+	b.wg.Add(1)
+	go b.keep(ctx, client)
+
+	return b, nil
+}
+
+func (b *Bridge) Topic() string {
+	return b.topic
+}
+
+func (b *Bridge) logf(level string, t string, args ...interface{}) {
+	if b.opts.Logger != nil {
+		b.opts.Logger.Log(dms.LogEntry{ Time: time.Now(), Level: level, Message: fmt.Sprintf(t, args...) })
+	}
+}
+
+func (b *Bridge) dial() (*mqtt.Client, error) {
+	client, err := mqtt.Dial(b.opts.Broker, mqtt.Options{
+		ClientId: b.opts.ClientId,
+		Username: b.opts.Username,
+		Password: b.opts.Password,
+		TLS: b.opts.TLS,
+		Will: &mqtt.Message{ Topic: b.topic + "/status", Payload: []byte("offline"), Retain: true },
+	}, b.receive)
+	if err != nil {
+		return nil, err
+	}
+
+	if b.opts.Control != nil {
+		if err := client.Subscribe(b.topic + "/command"); err != nil {
+			client.Close()
+			return nil, err
+		}
+	}
+
+	client.Publish(mqtt.Message{ Topic: b.topic + "/status", Payload: []byte("online"), Retain: true })
+	return client, nil
+}
+
+// keep reconnects when the broker goes away
+func (b *Bridge) keep(ctx context.Context, client *mqtt.Client) {
+	defer b.wg.Done()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-client.Done():
+		}
+
+		b.logf(dms.LogWarn, "mqtt: %v, reconnecting", client.Err())
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(retryInterval):
+			}
+
+			var err error
+			if client, err = b.dial(); err == nil {
+				break
+			}
+		}
+
+		b.Lock()
+		b.client = client
+		b.Unlock()
+
+		b.logf(dms.LogInfo, "mqtt: reconnected to %s", b.opts.Broker)
+	}
+}
+
+func (b *Bridge) publish(topic string, payload []byte, retain bool) {
+	b.Lock()
+	client := b.client
+	b.Unlock()
+
+	if client == nil {
+		return // still connecting
+	}
+
+	// nothing is queued while the broker is away
+	client.Publish(mqtt.Message{ Topic: b.topic + topic, Payload: payload, Retain: retain })
+}
+
+func (b *Bridge) publishJSON(topic string, v interface{}) {
+	if data, err := json.Marshal(v); err == nil {
+		b.publish(topic, data, false)
+	}
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+func (b *Bridge) OnConnect(dev device.Device) {
+	b.publish("/status", []byte("online"), true)
+}
+
+func (b *Bridge) OnTelemetry(dev device.Device, t device.Telemetry) {
+	fields := device.Fields(t)
+	fields["time"] = t.TimeStamp()
+	b.publishJSON("/telemetry", fields)
+}
+
+func (b *Bridge) OnDisconnect(dev device.Device) {
+	b.publish("/status", []byte("offline"), true)
+}
+
+// Event publishes a marker of a run
+func (b *Bridge) Event(e session.Event) {
+	b.publishJSON("/events", e)
+}
+
+type command struct {
+	Id   interface{}   `json:"id,omitempty"` // echoed to correlate the result
+	Cmd  string        `json:"cmd"`
+	Args []interface{} `json:"args"`
+}
+
+type commandResult struct {
+	Id     interface{} `json:"id,omitempty"`
+	Cmd    string      `json:"cmd"`
+	Result interface{} `json:"result,omitempty"`
+	Error  string      `json:"error,omitempty"`
+}
+
+func (b *Bridge) receive(m mqtt.Message) {
+	// a retained command would run again on every reconnect
+	if m.Topic != b.topic + "/command" || m.Retain || b.opts.Control == nil {
+		return
+	}
+
+	var cmd command
+	res := commandResult{}
+
+	dec := json.NewDecoder(bytes.NewReader(m.Payload))
+	dec.UseNumber()
+
+	if err := dec.Decode(&cmd); err != nil {
+		res.Error = errorf("bad command: %v", err).Error()
+	} else if args, err := dms.JSONValues(cmd.Args); err != nil {
+		res.Id, res.Cmd, res.Error = cmd.Id, cmd.Cmd, err.Error()
+	} else if v, err := b.opts.Control(cmd.Cmd, args...); err != nil {
+		res.Id, res.Cmd, res.Error = cmd.Id, cmd.Cmd, err.Error()
+	} else {
+		res.Id, res.Cmd, res.Result = cmd.Id, cmd.Cmd, v
+	}
+
+	if len(res.Error) > 0 {
+		b.logf(dms.LogWarn, "%s: %s", m.Topic, res.Error)
+	}
+
+	b.publishJSON("/command/result", res)
+}
+
+// Close marks the device offline and disconnects
+func (b *Bridge) Close() error {
+	b.cancel()
+	b.wg.Wait()
+
+	b.publish("/status", []byte("offline"), true)
+
+	b.Lock()
+	defer b.Unlock()
+	return b.client.Close()
+}
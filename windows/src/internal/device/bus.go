@@ -0,0 +1,140 @@
+package device
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+type SubscribeOptions struct {
+	Rate  float64       // Hz, 0 - every record
+	Queue *QueueOptions // own dispatch queue, nil - called by the publisher
+	Front bool          // called before the others (in the order they subscribed), e.g. to tag records
+}
+
+type subscriber struct {
+	callbacks Callbacks
+	errors ErrorCallbacks // nil if it does not take errors
+	queue *Queue
+	front bool
+	interval time.Duration
+	last time.Time // time stamp of the last delivered record
+}
+
+// Bus fans device events out to independent subscribers: the script, sinks,
+// dashboards, exporters. It is the device callbacks itself, subscribers are
+// called in the order they subscribed.
+type Bus struct {
+	sync.Mutex
+	subs []*subscriber
+	front int // number of front subscribers
+}
+
+func NewBus() *Bus {
+	return &Bus{}
+}
+
+// Subscribe adds a subscriber, the returned function removes it
+func (b *Bus) Subscribe(callbacks Callbacks, opts SubscribeOptions) func() {
+	sub := &subscriber{
+		callbacks: callbacks,
+		front: opts.Front,
+	}
+
+	// errors skip the subscriber queue
+	sub.errors, _ = callbacks.(ErrorCallbacks)
+
+	if opts.Rate > 0 {
+		sub.interval = time.Duration(float64(time.Second) / opts.Rate)
+	}
+
+	if opts.Queue != nil {
+		sub.queue = NewQueue(callbacks, *opts.Queue)
+		sub.callbacks = sub.queue
+	}
+
+	b.Lock()
+	if opts.Front {
+		b.subs = append(b.subs[:b.front:b.front], append([]*subscriber{ sub }, b.subs[b.front:]...)...)
+		b.front++
+	} else {
+		b.subs = append(b.subs, sub)
+	}
+	b.Unlock()
+
+	return func() {
+		b.Lock()
+		for i, s := range b.subs {
+			if s == sub {
+				b.subs = append(b.subs[:i:i], b.subs[i+1:]...)
+				if sub.front {
+					b.front--
+				}
+				break
+			}
+		}
+		b.Unlock()
+
+		if sub.queue != nil {
+			sub.queue.Close()
+		}
+	}
+}
+
+func (b *Bus) subscribers() []*subscriber {
+	b.Lock()
+	defer b.Unlock()
+	return append([]*subscriber{}, b.subs...)
+}
+
+func (b *Bus) OnConnect(dev Device) {
+	for _, s := range b.subscribers() {
+		s.callbacks.OnConnect(dev)
+	}
+}
+
+func (b *Bus) OnTelemetry(dev Device, t Telemetry) {
+	for _, s := range b.subscribers() {
+		if s.interval > 0 {
+			if ts := t.TimeStamp(); ts.Sub(s.last) < s.interval {
+				continue
+			} else {
+				s.last = ts
+			}
+		}
+
+		s.callbacks.OnTelemetry(dev, t)
+	}
+}
+
+func (b *Bus) OnDisconnect(dev Device) {
+	for _, s := range b.subscribers() {
+		s.callbacks.OnDisconnect(dev)
+	}
+}
+
+// OnError goes to the subscribers taking errors, printed if there are none
+func (b *Bus) OnError(dev Device, err error) {
+	taken := false
+	for _, s := range b.subscribers() {
+		if s.errors != nil {
+			s.errors.OnError(dev, err)
+			taken = true
+		}
+	}
+
+	if !taken {
+		fmt.Println(err)
+	}
+}
+
+// Close stops the subscriber queues, delivering what is queued
+func (b *Bus) Close() {
+	for _, s := range b.subscribers() {
+		if s.queue != nil {
+			s.queue.Close()
+		}
+	}
+}
@@ -0,0 +1,139 @@
+package device
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+	"testing"
+)
+
+func TestBusOrder(t *testing.T) {
+	var mu sync.Mutex
+	var got []string
+
+	b := NewBus()
+	sub := func(name string, front bool) func() {
+		return b.Subscribe(CallbacksWrapper{
+			Telemetry: func(Device, Telemetry) {
+				mu.Lock()
+				got = append(got, name)
+				mu.Unlock()
+			},
+		}, SubscribeOptions{ Front: front })
+	}
+
+	check := func(want string) {
+		t.Helper()
+		got = nil
+		b.OnTelemetry(nil, record{})
+		if s := strings.Join(got, " "); s != want {
+			t.Errorf("order %q, want %q", s, want)
+		}
+	}
+
+	sub("a", false)
+	unsubB := sub("b", true)
+	unsubC := sub("c", false)
+	sub("d", true)
+	check("b d a c")
+
+	unsubB()
+	sub("e", true)
+	check("d e a c")
+
+	unsubC()
+	sub("f", false)
+	check("d e a f")
+}
+
+func TestBusRate(t *testing.T) {
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		rate float64
+		want int
+	}{
+		{ 0, 50 },
+		{ 10, 10 },
+		{ 1, 1 },
+		{ 100, 50 },
+	}
+
+	for _, tt := range tests {
+		b := NewBus()
+		n := 0
+		b.Subscribe(CallbacksWrapper{
+			Telemetry: func(Device, Telemetry) { n++ },
+		}, SubscribeOptions{ Rate: tt.rate })
+
+		// 50 Hz for a second
+		for i := 0; i < 50; i++ {
+			b.OnTelemetry(nil, record{ n: i, ts: start.Add(time.Duration(i) * 20 * time.Millisecond) })
+		}
+
+		if n != tt.want {
+			t.Errorf("rate %g: %d records, want %d", tt.rate, n, tt.want)
+		}
+	}
+}
+
+func TestBusQueue(t *testing.T) {
+	b := NewBus()
+
+	c := newConsumer()
+	b.Subscribe(c.callbacks(), SubscribeOptions{ Queue: &QueueOptions{ Size: 16, Policy: PolicyBlock } })
+
+	direct := 0
+	b.Subscribe(CallbacksWrapper{
+		Telemetry: func(Device, Telemetry) { direct++ },
+	}, SubscribeOptions{})
+
+	// a stuck queued subscriber does not hold the others up
+	b.OnTelemetry(nil, record{ n: 0 })
+	<-c.started
+	for i := 1; i < 10; i++ {
+		b.OnTelemetry(nil, record{ n: i })
+	}
+
+	if direct != 10 {
+		t.Errorf("direct subscriber got %d records", direct)
+	}
+
+	close(c.gate)
+	b.Close()
+
+	if n := len(c.records()); n != 10 {
+		t.Errorf("queued subscriber got %d records after close", n)
+	}
+}
+
+type errorLog struct {
+	CallbacksWrapper
+	errors []string
+}
+
+func (l *errorLog) OnError(dev Device, err error) {
+	l.errors = append(l.errors, err.Error())
+}
+
+func TestBusErrors(t *testing.T) {
+	b := NewBus()
+
+	direct, queued := &errorLog{}, &errorLog{}
+	b.Subscribe(direct, SubscribeOptions{})
+	unsubscribe := b.Subscribe(queued, SubscribeOptions{ Queue: &QueueOptions{ Size: 4, Policy: PolicyBlock } })
+	defer unsubscribe()
+	b.Subscribe(CallbacksWrapper{}, SubscribeOptions{})
+
+	// the device reports through its dispatch queue, errors skip the queues
+	q := NewQueue(b, QueueOptions{ Size: 4, Policy: PolicyBlock })
+	defer q.Close()
+	q.OnError(nil, fmt.Errorf("broken frame"))
+
+	for _, l := range []*errorLog{ direct, queued } {
+		if strings.Join(l.errors, ",") != "broken frame" {
+			t.Errorf("errors %v", l.errors)
+		}
+	}
+}
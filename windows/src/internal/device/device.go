@@ -1,6 +1,7 @@
 package device
 
 import (
+	"fmt"
 	"context"
 
 	dms "dronmotors/dmetrics/internal/script"
@@ -14,6 +15,7 @@ type Device interface {
 	// scriptable
 	Control(cmd string, args ...dms.Value) (interface{}, error)
 	Methods() []string
+	Signatures() []dms.Signature
 }
 
 const (
@@ -27,6 +29,21 @@ type Callbacks interface {
 	OnDisconnect(Device)
 }
 
+// ErrorCallbacks are callbacks which also take the errors a device skips
+// over: broken frames and telemetry, failed callbacks
+type ErrorCallbacks interface {
+	OnError(Device, error)
+}
+
+// ReportError passes err to the callbacks taking errors, prints it otherwise
+func ReportError(callbacks Callbacks, dev Device, err error) {
+	if c, ok := callbacks.(ErrorCallbacks); ok {
+		c.OnError(dev, err)
+	} else {
+		fmt.Println(err)
+	}
+}
+
 type CallbacksWrapper struct {
 	Callbacks
 
@@ -36,13 +53,19 @@ type CallbacksWrapper struct {
 }
 
 func (p CallbacksWrapper) OnConnect(dev Device) {
-	p.Connect(dev)
+	if p.Connect != nil {
+		p.Connect(dev)
+	}
 }
 
 func (p CallbacksWrapper) OnTelemetry(dev Device, t Telemetry) {
-	p.Telemetry(dev, t)
+	if p.Telemetry != nil {
+		p.Telemetry(dev, t)
+	}
 }
 
 func (p CallbacksWrapper) OnDisconnect(dev Device) {
-	p.Disconnect(dev)
+	if p.Disconnect != nil {
+		p.Disconnect(dev)
+	}
 }
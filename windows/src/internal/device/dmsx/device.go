@@ -6,6 +6,8 @@ import (
 	"time"
 	"bufio"
 	"bytes"
+	"strings"
+	"sync/atomic"
 
 	"context"
 
@@ -28,29 +30,35 @@ type device struct {
 
 	id string
 	dsn string
-	status int
-	lastText string
+	status atomic.Int32
+	lastText atomic.Value // string, reply to the last command
 
 	file portType
 	cancel context.CancelCauseFunc
 	controlMtx sync.Mutex
+
+	frames atomic.Uint64 // decoded frames
+	frameErrors atomic.Uint64 // broken frames and telemetry
+	present atomic.Uint32 // channels of the last telemetry frame
 }
 
 func NewDevice(dsn string, callbacks Callbacks) Device {
-	return &device{
+	dev := &device{
 		dsn: dsn,
-		lastText: "?",
 		callbacks: callbacks,
-		status: StatusDisconnected,
 	}
+
+	dev.lastText.Store("?")
+	dev.status.Store(StatusDisconnected)
+	return dev
 }
 
-func (dev device) Id() string {
+func (dev *device) Id() string {
 	return dev.id
 }
 
-func (dev device) Status() string {
-	switch dev.status {
+func (dev *device) Status() string {
+	switch dev.status.Load() {
 	case StatusConnected:
 		return "connected"
 	case StatusDisconnected:
@@ -60,15 +68,75 @@ func (dev device) Status() string {
 	}
 }
 
-func (dev device) Methods() []string {
-	return []string{
-		"id",
-		"tare",
-		"brake",
-		"sample",
-		"chiller",
-		"throttle",
+var signatures = []dms.Signature{
+	{
+		Name: "id",
+		Help: "device identification: firmware, serial, sensors",
+	},
+	{
+		Name: "status",
+		Help: "link status dump",
+	},
+	{
+		Name: "tare",
+		Help: "reset brake position and load cells",
+	},
+	{
+		Name: "brake",
+		Help: "brake(0, 0) - return to zero, brake(1, ±N) - move N steps, brake(1, 0) - stop",
+		Args: []dms.Arg{
+			{ Name: "mode", Type: dms.ArgInt, Min: 0, Max: 1 },
+			{ Name: "steps", Type: dms.ArgInt },
+		},
+	},
+	{
+		Name: "sample",
+		Help: "telemetry period, ms",
+		Args: []dms.Arg{
+			{ Name: "period", Type: dms.ArgInt, Min: 1, Max: 1000000 },
+		},
+	},
+	{
+		Name: "chiller",
+		Help: "chiller(0, N) - off after N ms, chiller(1, N) - on at N %",
+		Args: []dms.Arg{
+			{ Name: "mode", Type: dms.ArgInt, Min: 0, Max: 1 },
+			{ Name: "value", Type: dms.ArgInt },
+		},
+		Check: func(args []dms.Value) error {
+			if mode, n := args[0].Int(), args[1].Int(); mode == 1 && (n < 1 || n > 100) {
+				return fmt.Errorf("power %d%% is out of range 1..100", n)
+			} else if mode == 0 && n < 0 {
+				return fmt.Errorf("delay %d ms must not be negative", n)
+			}
+			return nil
+		},
+	},
+	{
+		Name: "throttle",
+		Help: "ESC pulse width 1000..2000 µs, 0 - slow down to 1000 µs",
+		Args: []dms.Arg{
+			{ Name: "pulse", Type: dms.ArgInt, Min: 0, Max: 2000 },
+		},
+		Check: func(args []dms.Value) error {
+			if n := args[0].Int(); n != 0 && n < 1000 {
+				return fmt.Errorf("pulse %d µs is out of range 1000..2000", n)
+			}
+			return nil
+		},
+	},
+}
+
+func (dev *device) Signatures() []dms.Signature {
+	return signatures
+}
+
+func (dev *device) Methods() []string {
+	var res []string
+	for _, s := range signatures {
+		res = append(res, s.Name)
 	}
+	return res
 }
 
 func (dev *device) close() error {
@@ -87,7 +155,7 @@ func (dev *device) control(cmd string, deadline time.Duration) (interface{}, err
 	dev.controlMtx.Lock()
 	defer dev.controlMtx.Unlock()
 
-	dev.lastText = "?" // reset before sending command
+	dev.lastText.Store("?") // reset before sending command
 	if n, err := dev.file.Write([]byte(cmd)); err != nil {
 		return nil, err
 	} else if n != len(cmd) {
@@ -104,13 +172,22 @@ func (dev *device) control(cmd string, deadline time.Duration) (interface{}, err
 		case <-ctx.Done():
 			return nil, errorf("control timeout")
 		case <-time.After(1 * time.Millisecond):
-			if len(dev.lastText) > 1 {
-				return dev.lastText, nil
+			if text := dev.lastText.Load().(string); len(text) > 1 {
+				return text, nil
 			}
 		}
 	}
 }
 
+func findSignature(cmd string) (dms.Signature, bool) {
+	for _, s := range signatures {
+		if s.Name == cmd {
+			return s, true
+		}
+	}
+	return dms.Signature{}, false
+}
+
 func (dev *device) Control(cmd string, args ...dms.Value) (res interface{}, err error) {
 	defer func() {
 		if r := recover(); r != nil {
@@ -124,13 +201,30 @@ func (dev *device) Control(cmd string, args ...dms.Value) (res interface{}, err
 
 	deadline := 1000 * time.Millisecond
 
+	if sig, ok := findSignature(cmd); !ok {
+		return nil, errorf("no such control command: %s", cmd)
+	} else if err := sig.Validate(args); err != nil {
+		return nil, err
+	}
+
 	switch cmd {
 	case "id":
 		if len(dev.id) > 0 {
-			return dev.id, nil
+			return dev.identity(dev.id), nil
+		} else if v, err := dev.control(cmdf("id"), deadline); err != nil {
+			return nil, err
 		} else {
-			return dev.control(cmdf("id"), deadline)
+			return dev.identity(v.(string)), nil
 		}
+	case "status":
+		return map[string]interface{}{
+			"id": dev.id,
+			"port": dev.dsn,
+			"status": dev.Status(),
+			"frames": dev.frames.Load(),
+			"errors": dev.frameErrors.Load(),
+			"sensors": sensors(dev.present.Load()),
+		}, nil
 	case "tare":
 		return dev.control(cmdf("tare"), deadline)
 	case "brake":
@@ -146,6 +240,59 @@ func (dev *device) Control(cmd string, args ...dms.Value) (res interface{}, err
 	return nil, errorf("no such control command")
 }
 
+// identity parses the id reply, e.g. "DMSX fw=1.2.0 sn=A1234": the first
+// bare word names the device, key=value (or key:value) pairs are attributes;
+// the reply cannot override text, sensors and name
+func (dev *device) identity(text string) map[string]interface{} {
+	res := map[string]interface{}{
+		"text": text,
+		"sensors": sensors(dev.present.Load()),
+	}
+
+	reserved := map[string]bool{
+		"text": true,
+		"sensors": true,
+		"name": true,
+	}
+
+	// the full name wins, then the aliases in this order
+	aliases := [][2]string{
+		{ "fw", "firmware" },
+		{ "ver", "firmware" },
+		{ "version", "firmware" },
+		{ "sn", "serial" },
+	}
+
+	fields := strings.FieldsFunc(text, func(r rune) bool {
+		return r == ' ' || r == ',' || r == ';' || r == '\t'
+	})
+
+	for _, f := range fields {
+		k, v, ok := strings.Cut(f, "=")
+		if !ok {
+			k, v, ok = strings.Cut(f, ":")
+		}
+
+		if !ok {
+			if _, ok := res["name"]; !ok {
+				res["name"] = f
+			}
+		} else if !reserved[k] {
+			res[k] = v
+		}
+	}
+
+	for _, a := range aliases {
+		if _, ok := res[a[1]]; ok {
+			continue
+		} else if v, ok := res[a[0]]; ok {
+			res[a[1]] = v
+		}
+	}
+
+	return res
+}
+
 func (dev *device) connect() (err error) {
 	defer func() {
 		if r := recover(); r != nil {
@@ -160,9 +307,9 @@ func (dev *device) connect() (err error) {
 	dev.Lock()
 	defer dev.Unlock()
 
-	if dev.status == StatusDisconnected {
+	if dev.status.Load() == StatusDisconnected {
 		dev.callbacks.OnConnect(dev)
-		dev.status = StatusConnected
+		dev.status.Store(StatusConnected)
 	}
 
 	return
@@ -179,7 +326,7 @@ func (dev *device) telemetry(t Telemetry) (err error) {
 		}
 	}()
 
-	if dev.status == StatusConnected {
+	if dev.status.Load() == StatusConnected {
 		dev.callbacks.OnTelemetry(dev, t)
 	}
 
@@ -200,8 +347,8 @@ func (dev *device) disconnect() (err error) {
 	dev.Lock()
 	defer dev.Unlock()
 
-	if dev.status == StatusConnected {
-		dev.status = StatusDisconnected
+	if dev.status.Load() == StatusConnected {
+		dev.status.Store(StatusDisconnected)
 		dev.callbacks.OnDisconnect(dev)
 	}
 
@@ -260,17 +407,23 @@ func (dev *device) process(parentCtx context.Context) error {
 			return ctx.Err()
 		} else if t := scanner.Text(); len(t) > 0 {
 			if f, err := decodeFrame([]byte(t)); err != nil {
-				fmt.Println(err)
+				dev.frameErrors.Add(1)
+				ReportError(dev.callbacks, dev, err)
 			} else {
+				dev.frames.Add(1)
 				switch f.Channel {
 				case frameChannelText:
-					dev.lastText = string(f.Payload)
+					dev.lastText.Store(string(f.Payload))
 				case frameChannelData:
 					d, err := dataTelemetry{}.decode(f)
 					if err != nil {
-						fmt.Println(err)
-					} else if err := dev.telemetry(d); err != nil {
-						fmt.Println(err)
+						dev.frameErrors.Add(1)
+						ReportError(dev.callbacks, dev, err)
+					} else {
+						dev.present.Store(d.(*dataTelemetry).present)
+						if err := dev.telemetry(d); err != nil {
+							ReportError(dev.callbacks, dev, err)
+						}
 					}
 				}
 			}
@@ -283,7 +436,7 @@ func (dev *device) process(parentCtx context.Context) error {
 func (dev *device) identify(ctx context.Context, timeout time.Duration) error {
 	deadline := time.Now().Add(timeout)
 	for {
-		if v, err := dev.Control("id"); err == nil {
+		if v, err := dev.control(cmdf("id"), timeout); err == nil {
 			dev.id = v.(string)
 			return nil
 		} else if time.Now().After(deadline) {
@@ -297,6 +450,7 @@ func (dev *device) identify(ctx context.Context, timeout time.Duration) error {
 func (dev *device) StartUp(parentCtx context.Context) error {
 	ctx, cancel := context.WithCancelCause(parentCtx)
 	if err := dev.open(); err != nil {
+		cancel(err)
 		return err
 	} else {
 		dev.cancel = cancel
@@ -305,7 +459,7 @@ func (dev *device) StartUp(parentCtx context.Context) error {
 			defer func() {
 				defer dev.close()
 				if err := dev.disconnect(); err != nil {
-					fmt.Println(err)
+					ReportError(dev.callbacks, dev, err)
 				}
 			}()
 			cancel(dev.process(ctx))
@@ -0,0 +1,39 @@
+package dmsx
+
+import (
+	"testing"
+)
+
+func TestIdentity(t *testing.T) {
+	tests := []struct {
+		text string
+		want map[string]string
+	}{
+		{ "DMSX fw=1.2.0 sn=A1234", map[string]string{ "name": "DMSX", "firmware": "1.2.0", "serial": "A1234" } },
+		{ "DMSX,ver:2.0;serial:B7", map[string]string{ "name": "DMSX", "firmware": "2.0", "serial": "B7" } },
+		{ "DMSX firmware=3.1 fw=3.0", map[string]string{ "firmware": "3.1", "fw": "3.0" } },
+		{ "DMSX fw=1.0 version=0.9", map[string]string{ "firmware": "1.0" } },
+		{ "fw=1.0 DMSX MK2", map[string]string{ "name": "DMSX" } },
+		{ "DMSX name=evil text=evil sensors=evil", map[string]string{ "name": "DMSX" } },
+		{ "", map[string]string{} },
+	}
+
+	for _, tt := range tests {
+		dev := &device{}
+		res := dev.identity(tt.text)
+
+		if res["text"] != tt.text {
+			t.Errorf("%q: text %v", tt.text, res["text"])
+		}
+
+		if _, ok := res["sensors"].(map[string]interface{}); !ok {
+			t.Errorf("%q: sensors %v", tt.text, res["sensors"])
+		}
+
+		for k, v := range tt.want {
+			if res[k] != v {
+				t.Errorf("%q: %s = %v, want %q", tt.text, k, res[k], v)
+			}
+		}
+	}
+}
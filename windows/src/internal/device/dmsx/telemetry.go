@@ -95,8 +95,36 @@ const (
 	TlmIdxGyroZ
 )
 
+var tlmNames = map[uint32]string{
+	TlmIdxLoad1:		"load1",
+	TlmIdxLoad2:		"load2",
+	TlmIdxLoad3:		"load3",
+	TlmIdxTemp1:		"temp1",
+	TlmIdxTemp2:		"temp2",
+	TlmIdxTemp3:		"temp3",
+	TlmIdxBrake:		"brake",
+	TlmIdxMotorI:		"motorI",
+	TlmIdxMotorU:		"motorU",
+	TlmIdxMotorP:		"motorP",
+	TlmIdxMotorRPM:		"motorRPM",
+	TlmIdxMotorThrottle:	"throttle",
+	TlmIdxGyroX:		"gyroX",
+	TlmIdxGyroY:		"gyroY",
+	TlmIdxGyroZ:		"gyroZ",
+}
+
+// sensors reports which telemetry channels are present in a frame
+func sensors(mask uint32) map[string]interface{} {
+	res := map[string]interface{}{}
+	for idx, name := range tlmNames {
+		res[name] = mask & (1 << (idx - TlmIdxTs)) != 0
+	}
+	return res
+}
+
 type dataTelemetry struct {
 	timeStamp time.Time
+	present uint32 // bit per TlmIdx
 
 	Ts int32
 	Load1 int32
@@ -128,6 +156,9 @@ func (t dataTelemetry) String() string {
 func (t *dataTelemetry) decodeBytes(d []byte) {
 	idx := binary.LittleEndian.Uint32(d[0:4])
 	val := binary.LittleEndian.Uint32(d[4:8])
+	if idx >= TlmIdxTs && idx <= TlmIdxGyroZ {
+		t.present |= 1 << (idx - TlmIdxTs)
+	}
 	switch idx {
 	case TlmIdxTs:
 		t.Ts = int32(val)
@@ -208,6 +239,7 @@ func (t dataTelemetry) AsKeys() []string {
 		"temp1",
 		"temp2",
 		"temp3",
+		"brake",
 		"motorI",
 		"motorU",
 		"motorP",
@@ -229,6 +261,7 @@ func (t dataTelemetry) AsValues() []string {
 		fmt.Sprintf("%.02f", t.Temp1),
 		fmt.Sprintf("%.02f", t.Temp2),
 		fmt.Sprintf("%.02f", t.Temp3),
+		fmt.Sprintf("%d", t.Brake),
 		fmt.Sprintf("%.02f", t.MotorI),
 		fmt.Sprintf("%.02f", t.MotorU),
 		fmt.Sprintf("%.02f", t.MotorP),
@@ -0,0 +1,364 @@
+package dmsx
+
+import (
+	"os"
+	"io"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+	"strconv"
+	"context"
+	"encoding/csv"
+	"path/filepath"
+
+	. "dronmotors/dmetrics/internal/device"
+
+	dms "dronmotors/dmetrics/internal/script"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+// devices living in virtual time: telemetry is scheduled on the script clock,
+// so a script runs as fast as it is computed and always the same way
+////////////////////////////////////////////////////////////////////////////////
+
+type source interface {
+	control(cmd string, args []dms.Value)
+	// next record and the delay before the following one, nil when over
+	next() (*dataTelemetry, time.Duration)
+}
+
+type virtual struct {
+	sync.Mutex
+	callbacks Callbacks
+	clock *dms.VirtualClock
+	src source
+
+	id string
+	status int
+	frames uint64
+	ctx context.Context
+}
+
+const presentAll uint32 = 1 << (TlmIdxGyroZ - TlmIdxTs + 1) - 1
+
+func NewSimulator(clock *dms.VirtualClock, callbacks Callbacks) Device {
+	return &virtual{
+		callbacks: callbacks,
+		clock: clock,
+		src: &simulator{ period: 10, temp: ambient },
+		id: "DMSX-SIM",
+		status: StatusDisconnected,
+	}
+}
+
+func NewReplay(filename string, clock *dms.VirtualClock, callbacks Callbacks) (Device, error) {
+	records, err := readTelemetry(filename)
+	if err != nil {
+		return nil, err
+	} else if len(records) == 0 {
+		return nil, errorf("%s: no telemetry", filename)
+	}
+
+	return &virtual{
+		callbacks: callbacks,
+		clock: clock,
+		src: &replay{ records: records },
+		id: "replay:" + filepath.Base(filename),
+		status: StatusDisconnected,
+	}, nil
+}
+
+func (dev *virtual) Id() string {
+	return dev.id
+}
+
+func (dev *virtual) Status() string {
+	dev.Lock()
+	defer dev.Unlock()
+	if dev.status == StatusConnected {
+		return "connected"
+	}
+	return "disconnected"
+}
+
+func (dev *virtual) Signatures() []dms.Signature {
+	return signatures
+}
+
+func (dev *virtual) Methods() []string {
+	var res []string
+	for _, s := range signatures {
+		res = append(res, s.Name)
+	}
+	return res
+}
+
+func (dev *virtual) Control(cmd string, args ...dms.Value) (interface{}, error) {
+	if sig, ok := findSignature(cmd); !ok {
+		return nil, errorf("no such control command: %s", cmd)
+	} else if err := sig.Validate(args); err != nil {
+		return nil, err
+	}
+
+	switch cmd {
+	case "id":
+		return map[string]interface{}{
+			"text": dev.id,
+			"name": dev.id,
+			"sensors": sensors(presentAll),
+		}, nil
+	case "status":
+		dev.Lock()
+		defer dev.Unlock()
+		return map[string]interface{}{
+			"id": dev.id,
+			"port": "virtual",
+			"status": "connected",
+			"frames": dev.frames,
+			"errors": 0,
+			"sensors": sensors(presentAll),
+		}, nil
+	}
+
+	dev.Lock()
+	dev.src.control(cmd, args)
+	dev.Unlock()
+
+	return "ok", nil
+}
+
+func (dev *virtual) tick() {
+	if dev.ctx.Err() != nil {
+		return
+	}
+
+	dev.Lock()
+	t, delay := dev.src.next()
+	connected := dev.status == StatusConnected
+	if t != nil {
+		dev.frames++
+	}
+	dev.Unlock()
+
+	if t == nil {
+		return // nothing more to deliver
+	}
+
+	t.timeStamp = dev.clock.Now()
+	if connected {
+		dev.callbacks.OnTelemetry(dev, t)
+	}
+
+	dev.clock.Schedule(t.timeStamp.Add(delay), dev.tick)
+}
+
+func (dev *virtual) StartUp(ctx context.Context) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			if v, ok := r.(error); ok {
+				err = v
+			} else {
+				err = errorf("unknown error in connect callback")
+			}
+		}
+	}()
+
+	dev.ctx = ctx
+	dev.clock.Schedule(dev.clock.Now(), dev.tick)
+
+	// connected before the callback: telemetry is delivered as soon as the
+	// script starts waiting
+	dev.Lock()
+	dev.status = StatusConnected
+	dev.Unlock()
+
+	dev.callbacks.OnConnect(dev)
+
+	return nil
+}
+
+func (dev *virtual) TearDown() error {
+	dev.Lock()
+	connected := dev.status == StatusConnected
+	dev.status = StatusDisconnected
+	dev.Unlock()
+
+	if connected {
+		dev.callbacks.OnDisconnect(dev)
+	}
+
+	return nil
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+const ambient = 25.0
+
+// simulator is a crude model of the stand: rpm follows the throttle with a
+// first order lag, the brake loads the motor, power heats the motor up.
+type simulator struct {
+	throttle int32
+	brake int32
+	period int32 // ms
+	ts int32
+	rpm float64
+	temp float64
+}
+
+func (s *simulator) control(cmd string, args []dms.Value) {
+	switch cmd {
+	case "tare":
+		s.brake = 0
+	case "brake":
+		if args[0].Int() == 0 {
+			s.brake = 0
+		} else {
+			s.brake += int32(args[1].Int())
+		}
+	case "sample":
+		s.period = int32(args[0].Int())
+	case "throttle":
+		s.throttle = int32(args[0].Int())
+	}
+}
+
+func (s *simulator) next() (*dataTelemetry, time.Duration) {
+	const tau = 100.0 // ms
+	const voltage = 16.0
+
+	dt := float64(s.period)
+
+	target := 0.0
+	if s.throttle > 1000 {
+		target = math.Max(float64(s.throttle - 1000) * 20 - float64(s.brake) / 2, 0)
+	}
+
+	s.rpm += (target - s.rpm) * (1 - math.Exp(-dt / tau))
+
+	krpm := s.rpm / 1000
+	current := 0.3 * krpm * krpm
+	power := voltage * current
+	s.temp += (ambient + power * 0.05 - s.temp) * dt / 30000
+
+	t := &dataTelemetry{
+		present: presentAll,
+		Ts: s.ts,
+		Load1: int32(s.rpm * s.rpm / 20000),
+		Load2: int32(s.rpm * s.rpm / 100000),
+		Load3: int32(s.rpm * s.rpm / 100000),
+		Temp1: math.Round(s.temp * 4) / 4,
+		Temp2: ambient,
+		Brake: s.brake,
+		MotorI: math.Round(current * 100) / 100,
+		MotorU: voltage,
+		MotorP: math.Round(power * 100) / 100,
+		MotorRPM: int32(s.rpm),
+		Throttle: s.throttle,
+	}
+
+	s.ts += s.period
+	return t, time.Duration(s.period) * time.Millisecond
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// replay plays back a captured telemetry.csv with its own timing, commands
+// are accepted and ignored.
+type replay struct {
+	records []dataTelemetry
+	idx int
+}
+
+func (r *replay) control(cmd string, args []dms.Value) {
+}
+
+func (r *replay) next() (*dataTelemetry, time.Duration) {
+	if r.idx >= len(r.records) {
+		return nil, 0
+	}
+
+	t := r.records[r.idx]
+	r.idx++
+
+	var delay time.Duration
+	if r.idx < len(r.records) {
+		delay = time.Duration(r.records[r.idx].Ts - t.Ts) * time.Millisecond
+	}
+	if delay < 0 {
+		delay = 0
+	}
+
+	return &t, delay
+}
+
+func readTelemetry(filename string) ([]dataTelemetry, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	defer f.Close()
+
+	r := csv.NewReader(f)
+	header, err := r.Read()
+	if err != nil {
+		return nil, errorf("%s: %v", filename, err)
+	}
+
+	cols := map[string]int{}
+	for i, name := range header {
+		cols[name] = i
+	}
+
+	var res []dataTelemetry
+	for line := 2; ; line++ {
+		row, err := r.Read()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, errorf("%s: %v", filename, err)
+		}
+
+		var perr error
+		num := func(name string) float64 {
+			i, ok := cols[name]
+			if !ok || i >= len(row) || len(row[i]) == 0 {
+				return 0
+			}
+			v, err := strconv.ParseFloat(row[i], 64)
+			if err != nil && perr == nil {
+				perr = fmt.Errorf("%s:%d: %s: %v", filename, line, name, err)
+			}
+			return v
+		}
+
+		t := dataTelemetry{
+			present: presentAll,
+			Ts: int32(num("ts")),
+			Load1: int32(num("load1")),
+			Load2: int32(num("load2")),
+			Load3: int32(num("load3")),
+			Temp1: num("temp1"),
+			Temp2: num("temp2"),
+			Temp3: num("temp3"),
+			Brake: int32(num("brake")),
+			MotorI: num("motorI"),
+			MotorU: num("motorU"),
+			MotorP: num("motorP"),
+			MotorRPM: int32(num("motorRPM")),
+			Throttle: int32(num("throttle")),
+			GyroX: int32(num("gyroX")),
+			GyroY: int32(num("gyroY")),
+			GyroZ: int32(num("gyroZ")),
+		}
+
+		if perr != nil {
+			return nil, errorf("%v", perr)
+		}
+
+		res = append(res, t)
+	}
+
+	return res, nil
+}
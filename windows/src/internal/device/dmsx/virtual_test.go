@@ -0,0 +1,214 @@
+package dmsx
+
+import (
+	"os"
+	"math"
+	"time"
+	"context"
+	"strings"
+	"testing"
+	"path/filepath"
+
+	. "dronmotors/dmetrics/internal/device"
+
+	dms "dronmotors/dmetrics/internal/script"
+)
+
+var epoch = time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// capture collects the telemetry of a virtual device
+type capture struct {
+	records []*dataTelemetry
+	connects, disconnects int
+}
+
+func (c *capture) callbacks() Callbacks {
+	return CallbacksWrapper{
+		Connect: func(Device) { c.connects++ },
+		Telemetry: func(_ Device, t Telemetry) { c.records = append(c.records, t.(*dataTelemetry)) },
+		Disconnect: func(Device) { c.disconnects++ },
+	}
+}
+
+func control(t *testing.T, dev Device, cmd string, args ...interface{}) {
+	t.Helper()
+	var values []dms.Value
+	for _, a := range args {
+		values = append(values, dms.NewValue(a))
+	}
+	if _, err := dev.Control(cmd, values...); err != nil {
+		t.Fatalf("%s: %v", cmd, err)
+	}
+}
+
+func TestSimulator(t *testing.T) {
+	ctx := context.Background()
+	clock := dms.NewVirtualClock(epoch)
+
+	var c capture
+	dev := NewSimulator(clock, c.callbacks())
+	if err := dev.StartUp(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	control(t, dev, "throttle", 1500)
+	clock.Sleep(ctx, time.Second)
+
+	// a record every 10ms, stamped with the virtual time
+	if n := len(c.records); n != 101 {
+		t.Fatalf("%d records in a second", n)
+	}
+	for i, r := range c.records {
+		if r.Ts != int32(i * 10) || !r.timeStamp.Equal(epoch.Add(time.Duration(r.Ts) * time.Millisecond)) {
+			t.Fatalf("record %d: ts %d at %v", i, r.Ts, r.timeStamp)
+		}
+	}
+
+	// the rpm settles at 20 per µs above 1000
+	last := c.records[len(c.records) - 1]
+	if last.Throttle != 1500 || math.Abs(float64(last.MotorRPM) - 10000) > 10 {
+		t.Errorf("throttle %d, rpm %d", last.Throttle, last.MotorRPM)
+	}
+
+	// the brake loads the motor, a slower rate spaces the records
+	control(t, dev, "brake", 1, 2000)
+	control(t, dev, "sample", 50)
+	c.records = nil
+	clock.Sleep(ctx, time.Second)
+
+	last = c.records[len(c.records) - 1]
+	if last.Brake != 2000 || math.Abs(float64(last.MotorRPM) - 9000) > 10 {
+		t.Errorf("brake %d, rpm %d", last.Brake, last.MotorRPM)
+	}
+	if n := len(c.records); n < 19 || n > 21 {
+		t.Errorf("%d records at 50ms", n)
+	}
+
+	if err := dev.TearDown(); err != nil {
+		t.Fatal(err)
+	} else if c.connects != 1 || c.disconnects != 1 {
+		t.Errorf("%d connects, %d disconnects", c.connects, c.disconnects)
+	}
+}
+
+func TestSimulatorRepeats(t *testing.T) {
+	run := func() []string {
+		ctx := context.Background()
+		clock := dms.NewVirtualClock(epoch)
+
+		var c capture
+		dev := NewSimulator(clock, c.callbacks())
+		dev.StartUp(ctx)
+		for _, pulse := range []int{ 1200, 1600, 1100 } {
+			control(t, dev, "throttle", pulse)
+			clock.Sleep(ctx, 300 * time.Millisecond)
+		}
+		dev.TearDown()
+
+		var res []string
+		for _, r := range c.records {
+			res = append(res, strings.Join(r.AsValues(), ","))
+		}
+		return res
+	}
+
+	a, b := run(), run()
+	if strings.Join(a, "\n") != strings.Join(b, "\n") {
+		t.Errorf("runs differ")
+	}
+}
+
+func TestSimulatorCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	clock := dms.NewVirtualClock(epoch)
+
+	var c capture
+	dev := NewSimulator(clock, c.callbacks())
+	dev.StartUp(ctx)
+	clock.Sleep(ctx, 100 * time.Millisecond)
+
+	cancel()
+	n := len(c.records)
+	clock.Sleep(context.Background(), time.Second)
+	if len(c.records) != n {
+		t.Errorf("%d records after cancel", len(c.records) - n)
+	}
+}
+
+func TestReplay(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, text string) string {
+		filename := filepath.Join(dir, name)
+		if err := os.WriteFile(filename, []byte(text), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return filename
+	}
+
+	tests := []struct {
+		name string
+		csv string
+		times []int // ms
+		err string
+	}{
+		{
+			"current layout",
+			strings.Join(dataTelemetry{}.AsKeys(), ",") + "\n" +
+				"100,300,10,20,40.00,25.00,0.00,150,1.50,16.00,24.00,5000,1200,0,0,0,hover\n" +
+				"110,310,10,20,40.25,25.00,0.00,160,1.50,16.00,24.00,5100,1200,0,0,0,hover\n" +
+				"150,320,10,20,40.50,25.00,0.00,170,1.50,16.00,24.00,5200,1300,0,0,0,max\n",
+			[]int{ 0, 10, 50 },
+			"",
+		},
+		{
+			"without brake",
+			"ts,load1,motorRPM,throttle\n0,300,5000,1200\n20,310,5100,1200\n",
+			[]int{ 0, 20 },
+			"",
+		},
+		{ "no records", "ts,load1\n", nil, "no telemetry" },
+		{ "bad number", "ts,load1\n0,x\n", nil, "load1" },
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			clock := dms.NewVirtualClock(epoch)
+
+			var c capture
+			dev, err := NewReplay(write(tt.name + ".csv", tt.csv), clock, c.callbacks())
+			if len(tt.err) > 0 {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("got %v, want %q", err, tt.err)
+				}
+				return
+			} else if err != nil {
+				t.Fatal(err)
+			}
+
+			if err := dev.StartUp(ctx); err != nil {
+				t.Fatal(err)
+			}
+
+			// commands are accepted and do not change the recording
+			control(t, dev, "throttle", 2000)
+			clock.Sleep(ctx, time.Second)
+
+			if len(c.records) != len(tt.times) {
+				t.Fatalf("%d records, want %d", len(c.records), len(tt.times))
+			}
+			for i, r := range c.records {
+				if at := r.timeStamp.Sub(epoch); at != time.Duration(tt.times[i]) * time.Millisecond {
+					t.Errorf("record %d at %v, want %dms", i, at, tt.times[i])
+				}
+				if r.Throttle == 2000 || r.Load1 != int32(300 + i * 10) {
+					t.Errorf("record %d: %+v", i, r)
+				}
+			}
+
+			if tt.name == "current layout" && (c.records[2].Brake != 170 || c.records[2].MotorRPM != 5200 || c.records[2].Temp1 != 40.5) {
+				t.Errorf("columns: %+v", c.records[2])
+			}
+		})
+	}
+}
@@ -0,0 +1,192 @@
+package device
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+const (
+	PolicyBlock		= "block"       // wait for the consumer, stalls the reader
+	PolicyDropOldest	= "drop-oldest" // keep the freshest records
+	PolicyDecimate		= "decimate"    // thin out the stream while behind
+)
+
+type QueueOptions struct {
+	Size   int
+	Policy string
+}
+
+func DefaultQueueOptions() QueueOptions {
+	return QueueOptions{
+		Size: 1024,
+		Policy: PolicyDropOldest,
+	}
+}
+
+func (o QueueOptions) Validate() error {
+	switch o.Policy {
+	case PolicyBlock, PolicyDropOldest, PolicyDecimate:
+	default:
+		return fmt.Errorf("queue: policy %q is not supported", o.Policy)
+	}
+
+	if o.Size < 1 {
+		return fmt.Errorf("queue: size must be positive")
+	}
+
+	return nil
+}
+
+type QueueStats struct {
+	Received  uint64 `json:"received"`
+	Delivered uint64 `json:"delivered"`
+	Dropped   uint64 `json:"dropped"`
+	MaxDepth  int    `json:"maxDepth"`
+}
+
+func (s QueueStats) String() string {
+	return fmt.Sprintf("received %d, delivered %d, dropped %d, max queue %d",
+		s.Received, s.Delivered, s.Dropped, s.MaxDepth)
+}
+
+type item struct {
+	dev Device
+	t Telemetry
+}
+
+// Queue decouples the device reader from the consumers: OnTelemetry only
+// queues a record, a separate goroutine passes it on. Connect, disconnect
+// and errors bypass the queue and are passed through at once, so they can
+// overtake queued telemetry: OnDisconnect may come before the last records
+// and OnTelemetry may run concurrently with either of them. A consumer that
+// needs every record of the link waits for Close after the disconnect.
+type Queue struct {
+	callbacks Callbacks
+	opts QueueOptions
+
+	ch chan item
+	done chan struct{}
+	once sync.Once
+
+	decimation atomic.Int32 // keep every n-th record
+	seq atomic.Uint64
+
+	received atomic.Uint64
+	delivered atomic.Uint64
+	dropped atomic.Uint64
+	maxDepth atomic.Int32
+}
+
+func NewQueue(callbacks Callbacks, opts QueueOptions) *Queue {
+	q := &Queue{
+		callbacks: callbacks,
+		opts: opts,
+		ch: make(chan item, opts.Size),
+		done: make(chan struct{}),
+	}
+
+	q.decimation.Store(1)
+
+	go q.run()
+	return q
+}
+
+func (q *Queue) run() {
+	defer close(q.done)
+
+	for it := range q.ch {
+		if n := len(q.ch); q.opts.Policy == PolicyDecimate && n < cap(q.ch) / 4 {
+			q.decimation.Store(1) // caught up
+		}
+
+		q.callbacks.OnTelemetry(it.dev, it.t)
+		q.delivered.Add(1)
+	}
+}
+
+// depth records the queue length, producers may race each other
+func (q *Queue) depth() {
+	n := int32(len(q.ch))
+	for {
+		if max := q.maxDepth.Load(); n <= max || q.maxDepth.CompareAndSwap(max, n) {
+			return
+		}
+	}
+}
+
+// OnConnect is passed through at once, see Queue
+func (q *Queue) OnConnect(dev Device) {
+	q.callbacks.OnConnect(dev)
+}
+
+func (q *Queue) OnTelemetry(dev Device, t Telemetry) {
+	q.received.Add(1)
+	it := item{ dev, t }
+
+	switch q.opts.Policy {
+	case PolicyBlock:
+		q.ch <- it
+	case PolicyDropOldest:
+		for {
+			select {
+			case q.ch <- it:
+				q.depth()
+				return
+			default:
+			}
+
+			select {
+			case <-q.ch:
+				q.dropped.Add(1)
+			default:
+			}
+		}
+	case PolicyDecimate:
+		if k := uint64(q.decimation.Load()); q.seq.Add(1) % k != 0 {
+			q.dropped.Add(1)
+			return
+		}
+
+		select {
+		case q.ch <- it:
+		default:
+			q.dropped.Add(1)
+			if k := q.decimation.Load(); k < 64 {
+				q.decimation.CompareAndSwap(k, k * 2)
+			}
+		}
+	}
+
+	q.depth()
+}
+
+// OnDisconnect is passed through at once, queued records follow it
+func (q *Queue) OnDisconnect(dev Device) {
+	q.callbacks.OnDisconnect(dev)
+}
+
+// OnError is passed through at once as well
+func (q *Queue) OnError(dev Device, err error) {
+	ReportError(q.callbacks, dev, err)
+}
+
+// Close delivers what is queued and stops the queue. No telemetry must be
+// queued after Close.
+func (q *Queue) Close() {
+	q.once.Do(func() {
+		close(q.ch)
+	})
+	<-q.done
+}
+
+func (q *Queue) Stats() QueueStats {
+	return QueueStats{
+		Received: q.received.Load(),
+		Delivered: q.delivered.Load(),
+		Dropped: q.dropped.Load(),
+		MaxDepth: int(q.maxDepth.Load()),
+	}
+}
@@ -0,0 +1,180 @@
+package device
+
+import (
+	"fmt"
+	"sync"
+	"time"
+	"testing"
+)
+
+type record struct {
+	n int
+	ts time.Time
+}
+
+func (r record) Id() string { return "test" }
+func (r record) AsKeys() []string { return []string{ "n" } }
+func (r record) AsValues() []string { return []string{ fmt.Sprint(r.n) } }
+func (r record) TimeStamp() time.Time { return r.ts }
+func (r record) String() string { return fmt.Sprint(r.n) }
+
+// consumer collects record numbers, the first record blocks it until release
+type consumer struct {
+	sync.Mutex
+	got []int
+	started chan struct{}
+	gate chan struct{}
+}
+
+func newConsumer() *consumer {
+	return &consumer{
+		started: make(chan struct{}),
+		gate: make(chan struct{}),
+	}
+}
+
+func (c *consumer) callbacks() Callbacks {
+	return CallbacksWrapper{
+		Telemetry: func(dev Device, t Telemetry) {
+			c.Lock()
+			c.got = append(c.got, t.(record).n)
+			first := len(c.got) == 1
+			c.Unlock()
+
+			if first {
+				close(c.started)
+				<-c.gate
+			}
+		},
+	}
+}
+
+func (c *consumer) records() []int {
+	c.Lock()
+	defer c.Unlock()
+	return append([]int{}, c.got...)
+}
+
+func TestQueuePolicies(t *testing.T) {
+	tests := []struct {
+		policy string
+		want []int // delivered while the consumer is stuck on the first one
+		dropped uint64
+	}{
+		{ PolicyDropOldest, []int{ 0, 7, 8, 9, 10 }, 6 },
+		{ PolicyDecimate, []int{ 0, 1, 2, 3, 4 }, 6 },
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.policy, func(t *testing.T) {
+			c := newConsumer()
+			q := NewQueue(c.callbacks(), QueueOptions{ Size: 4, Policy: tt.policy })
+
+			q.OnTelemetry(nil, record{ n: 0 })
+			<-c.started
+
+			for i := 1; i <= 10; i++ {
+				q.OnTelemetry(nil, record{ n: i })
+			}
+
+			close(c.gate)
+			q.Close()
+
+			if got := fmt.Sprint(c.records()); got != fmt.Sprint(tt.want) {
+				t.Errorf("delivered %s, want %v", got, tt.want)
+			}
+
+			st := q.Stats()
+			if st.Received != 11 || st.Dropped != tt.dropped || st.Delivered != uint64(len(tt.want)) || st.MaxDepth != 4 {
+				t.Errorf("stats %s", st)
+			}
+		})
+	}
+}
+
+func TestQueueBlock(t *testing.T) {
+	c := newConsumer()
+	q := NewQueue(c.callbacks(), QueueOptions{ Size: 2, Policy: PolicyBlock })
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 10; i++ {
+			q.OnTelemetry(nil, record{ n: i })
+		}
+	}()
+
+	<-c.started
+	select {
+	case <-done:
+		t.Fatalf("producer was not blocked")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	close(c.gate)
+	<-done
+	q.Close()
+
+	if got := fmt.Sprint(c.records()); got != "[0 1 2 3 4 5 6 7 8 9]" {
+		t.Errorf("delivered %s", got)
+	}
+
+	if st := q.Stats(); st.Dropped != 0 || st.Delivered != 10 || st.MaxDepth > 2 {
+		t.Errorf("stats %s", st)
+	}
+}
+
+func TestQueueDecimation(t *testing.T) {
+	c := newConsumer()
+	q := NewQueue(c.callbacks(), QueueOptions{ Size: 4, Policy: PolicyDecimate })
+
+	q.OnTelemetry(nil, record{ n: 0 })
+	<-c.started
+
+	// every overflow doubles the decimation, up to 64
+	for i := 1; i <= 100; i++ {
+		q.OnTelemetry(nil, record{ n: i })
+	}
+
+	if k := q.decimation.Load(); k != 64 {
+		t.Errorf("decimation %d after overflows", k)
+	}
+
+	close(c.gate)
+	q.Close()
+
+	if k := q.decimation.Load(); k != 1 {
+		t.Errorf("decimation %d after catching up", k)
+	}
+
+	if st := q.Stats(); st.Received != st.Delivered + st.Dropped {
+		t.Errorf("stats %s", st)
+	}
+}
+
+func TestQueueDepth(t *testing.T) {
+	c := newConsumer()
+	q := NewQueue(c.callbacks(), QueueOptions{ Size: 64, Policy: PolicyDropOldest })
+
+	q.OnTelemetry(nil, record{})
+	<-c.started
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				q.OnTelemetry(nil, record{ n: j })
+			}
+		}()
+	}
+	wg.Wait()
+
+	if st := q.Stats(); st.MaxDepth != 64 {
+		t.Errorf("max depth %d, want 64", st.MaxDepth)
+	}
+
+	close(c.gate)
+	q.Close()
+}
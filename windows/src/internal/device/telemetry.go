@@ -2,6 +2,7 @@ package device
 
 import (
 	"time"
+	"strconv"
 )
 
 type Telemetry interface {
@@ -13,3 +14,19 @@ type Telemetry interface {
 
 	String() string
 }
+
+// Fields maps record keys to values, numeric ones as float64, e.g. for json
+func Fields(t Telemetry) map[string]interface{} {
+	res := map[string]interface{}{}
+	values := t.AsValues()
+	for i, k := range t.AsKeys() {
+		if i >= len(values) {
+			break
+		} else if v, err := strconv.ParseFloat(values[i], 64); err == nil {
+			res[k] = v
+		} else {
+			res[k] = values[i]
+		}
+	}
+	return res
+}
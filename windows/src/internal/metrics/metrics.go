@@ -0,0 +1,217 @@
+// Package metrics exports the stand state in the Prometheus text format:
+// the latest telemetry values, link counters and whatever the owner
+// registers (queues, runs).
+package metrics
+
+import (
+	"io"
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+	"time"
+	"strconv"
+	"strings"
+	"net/http"
+
+	"dronmotors/dmetrics/internal/device"
+)
+
+const Prefix = "dm_"
+
+////////////////////////////////////////////////////////////////////////////////
+
+const (
+	Gauge	= "gauge"
+	Counter	= "counter"
+)
+
+// Writer renders metrics: a header, then the samples of that metric
+type Writer struct {
+	w io.Writer
+	name string
+}
+
+func (w *Writer) Header(name, typ, help string) {
+	w.name = Prefix + name
+	fmt.Fprintf(w.w, "# HELP %s %s\n# TYPE %s %s\n", w.name, help, w.name, typ)
+}
+
+// Sample writes a sample of the current metric, labels are name, value pairs
+func (w *Writer) Sample(value float64, labels ...string) {
+	var b strings.Builder
+	b.WriteString(w.name)
+
+	if len(labels) > 1 {
+		b.WriteString("{")
+		for i := 0; i + 1 < len(labels); i += 2 {
+			if i > 0 {
+				b.WriteString(",")
+			}
+			fmt.Fprintf(&b, "%s=\"%s\"", labels[i], labelEscaper.Replace(labels[i + 1]))
+		}
+		b.WriteString("}")
+	}
+
+	fmt.Fprintf(w.w, "%s %s\n", b.String(), formatValue(value))
+}
+
+func (w *Writer) Gauge(name, help string, value float64, labels ...string) {
+	w.Header(name, Gauge, help)
+	w.Sample(value, labels...)
+}
+
+func (w *Writer) Counter(name, help string, value float64, labels ...string) {
+	w.Header(name, Counter, help)
+	w.Sample(value, labels...)
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func formatValue(v float64) string {
+	switch {
+	case math.IsNaN(v):
+		return "NaN"
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	default:
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	}
+}
+
+func Bool(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// Exporter is a bus subscriber keeping the latest telemetry and an HTTP
+// handler for /metrics
+type Exporter struct {
+	sync.Mutex
+
+	dev device.Device
+	connected bool
+	values map[string]float64
+	records uint64
+	last time.Time
+
+	collectors []func(*Writer)
+}
+
+func New() *Exporter {
+	return &Exporter{
+		values: map[string]float64{},
+	}
+}
+
+// Register adds metrics collected on every scrape
+func (e *Exporter) Register(collect func(*Writer)) {
+	e.Lock()
+	e.collectors = append(e.collectors, collect)
+	e.Unlock()
+}
+
+func (e *Exporter) OnConnect(dev device.Device) {
+	e.Lock()
+	e.dev, e.connected = dev, true
+	e.Unlock()
+}
+
+func (e *Exporter) OnTelemetry(dev device.Device, t device.Telemetry) {
+	fields := device.Fields(t)
+
+	e.Lock()
+	defer e.Unlock()
+
+	for k, v := range fields {
+		if f, ok := v.(float64); ok {
+			e.values[k] = f
+		}
+	}
+
+	e.records++
+	e.last = t.TimeStamp()
+}
+
+func (e *Exporter) OnDisconnect(dev device.Device) {
+	e.Lock()
+	e.connected = false
+	e.Unlock()
+}
+
+func (e *Exporter) collect(w *Writer) {
+	e.Lock()
+	dev, connected := e.dev, e.connected
+	records, last := e.records, e.last
+
+	keys := make([]string, 0, len(e.values))
+	for k := range e.values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	values := make([]float64, len(keys))
+	for i, k := range keys {
+		values[i] = e.values[k]
+	}
+
+	collectors := append([]func(*Writer){}, e.collectors...)
+	e.Unlock()
+
+	w.Gauge("device_connected", "1 if the device is connected", Bool(connected))
+
+	if len(keys) > 0 {
+		w.Header("telemetry", Gauge, "latest telemetry value by field")
+		for i, k := range keys {
+			w.Sample(values[i], "field", k)
+		}
+	}
+
+	w.Counter("telemetry_records_total", "telemetry records received", float64(records))
+	if !last.IsZero() {
+		w.Gauge("telemetry_last_timestamp_seconds", "time the latest telemetry record was received", float64(last.UnixNano()) / 1e9)
+	}
+
+	// link counters come from the device status, not every device has them
+	if dev != nil && connected {
+		if res, err := dev.Control("status"); err == nil {
+			if m, ok := res.(map[string]interface{}); ok {
+				if n, ok := m["frames"].(uint64); ok {
+					w.Counter("frames_total", "frames decoded", float64(n))
+				}
+				if n, ok := m["errors"].(uint64); ok {
+					w.Counter("frame_errors_total", "broken frames: length, checksum or payload errors", float64(n))
+				}
+			}
+		}
+	}
+
+	for _, c := range collectors {
+		c(w)
+	}
+}
+
+// QueueCollector reports dispatch queue counters
+func QueueCollector(name string, q *device.Queue) func(*Writer) {
+	return func(w *Writer) {
+		st := q.Stats()
+		w.Counter("queue_received_total", "records queued", float64(st.Received), "queue", name)
+		w.Counter("queue_delivered_total", "records delivered to the consumers", float64(st.Delivered), "queue", name)
+		w.Counter("queue_dropped_total", "records dropped on overflow", float64(st.Dropped), "queue", name)
+		w.Gauge("queue_max_depth", "maximal queue depth", float64(st.MaxDepth), "queue", name)
+	}
+}
+
+func (e *Exporter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var b strings.Builder
+	e.collect(&Writer{ w: &b })
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	io.WriteString(w, b.String())
+}
@@ -0,0 +1,114 @@
+package metrics
+
+import (
+	"math"
+	"time"
+	"context"
+	"strings"
+	"testing"
+	"net/http/httptest"
+
+	"dronmotors/dmetrics/internal/device"
+
+	dms "dronmotors/dmetrics/internal/script"
+)
+
+type record struct {
+	keys, values []string
+	ts time.Time
+}
+
+func (r record) Id() string { return "test" }
+func (r record) AsKeys() []string { return r.keys }
+func (r record) AsValues() []string { return r.values }
+func (r record) TimeStamp() time.Time { return r.ts }
+func (r record) String() string { return strings.Join(r.values, ",") }
+
+// link is a device reporting frame counters
+type link struct {
+	device.Device
+}
+
+func (link) Id() string { return "DMSX" }
+func (link) StartUp(context.Context) error { return nil }
+func (link) Control(cmd string, args ...dms.Value) (interface{}, error) {
+	return map[string]interface{}{ "frames": uint64(120), "errors": uint64(3) }, nil
+}
+
+func TestSample(t *testing.T) {
+	tests := []struct {
+		value float64
+		labels []string
+		want string
+	}{
+		{ 1.5, nil, "dm_x 1.5\n" },
+		{ 1e21, nil, "dm_x 1e+21\n" },
+		{ math.NaN(), nil, "dm_x NaN\n" },
+		{ math.Inf(1), nil, "dm_x +Inf\n" },
+		{ math.Inf(-1), nil, "dm_x -Inf\n" },
+		{ 2, []string{ "queue", "run" }, "dm_x{queue=\"run\"} 2\n" },
+		{ 2, []string{ "a", "1", "b", "2" }, "dm_x{a=\"1\",b=\"2\"} 2\n" },
+		{ 2, []string{ "f", "say \"hi\"\\\n" }, "dm_x{f=\"say \\\"hi\\\"\\\\\\n\"} 2\n" },
+		{ 2, []string{ "odd" }, "dm_x 2\n" },
+	}
+
+	for _, tt := range tests {
+		var b strings.Builder
+		w := &Writer{ w: &b, name: Prefix + "x" }
+		w.Sample(tt.value, tt.labels...)
+		if b.String() != tt.want {
+			t.Errorf("%v %q: got %q, want %q", tt.value, tt.labels, b.String(), tt.want)
+		}
+	}
+}
+
+func scrape(t *testing.T, e *Exporter) string {
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+		t.Errorf("content type %q", ct)
+	}
+	return rec.Body.String()
+}
+
+func TestExporter(t *testing.T) {
+	e := New()
+
+	if got := scrape(t, e); !strings.Contains(got, "dm_device_connected 0\n") || strings.Contains(got, "dm_telemetry{") {
+		t.Errorf("before connect:\n%s", got)
+	}
+
+	e.OnConnect(link{})
+	e.OnTelemetry(nil, record{ []string{ "motorRPM", "tag" }, []string{ "5000", "hover" }, time.Unix(100, 0) })
+	e.OnTelemetry(nil, record{ []string{ "motorRPM", "temp1" }, []string{ "6000", "25.5" }, time.Unix(101, 500e6) })
+
+	q := device.NewQueue(device.CallbacksWrapper{}, device.QueueOptions{ Size: 4 })
+	defer q.Close()
+	e.Register(QueueCollector("run", q))
+
+	got := scrape(t, e)
+	for _, want := range []string{
+		"# TYPE dm_device_connected gauge\ndm_device_connected 1\n",
+		"# TYPE dm_telemetry gauge\ndm_telemetry{field=\"motorRPM\"} 6000\ndm_telemetry{field=\"temp1\"} 25.5\n",
+		"# TYPE dm_telemetry_records_total counter\ndm_telemetry_records_total 2\n",
+		"dm_telemetry_last_timestamp_seconds 101.5\n",
+		"dm_frames_total 120\n",
+		"dm_frame_errors_total 3\n",
+		"dm_queue_received_total{queue=\"run\"} 0\n",
+		"dm_queue_max_depth{queue=\"run\"} 0\n",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("no %q in\n%s", want, got)
+		}
+	}
+
+	// string fields are not metrics
+	if strings.Contains(got, "hover") {
+		t.Errorf("tag exported:\n%s", got)
+	}
+
+	e.OnDisconnect(link{})
+	if got := scrape(t, e); !strings.Contains(got, "dm_device_connected 0\n") || strings.Contains(got, "dm_frames_total") {
+		t.Errorf("after disconnect:\n%s", got)
+	}
+}
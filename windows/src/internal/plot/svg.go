@@ -0,0 +1,187 @@
+package plot
+
+import (
+	"io"
+	"fmt"
+	"math"
+	"strings"
+	"html"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+const (
+	StyleLine = iota
+	StylePoints
+	StyleBand // area between Y and Y2
+)
+
+type Series struct {
+	Name  string
+	Style int
+	X     []float64
+	Y     []float64
+	Y2    []float64
+}
+
+// Marker is a vertical line at X, e.g. an event of the run
+type Marker struct {
+	X     float64
+	Label string
+}
+
+type Chart struct {
+	Title   string
+	XLabel  string
+	YLabel  string
+	Width   int
+	Height  int
+	Series  []Series
+	Markers []Marker
+}
+
+var palette = []string{
+	"#1f77b4", "#d62728", "#2ca02c", "#ff7f0e", "#9467bd", "#8c564b",
+}
+
+const (
+	marginLeft   = 70
+	marginRight  = 20
+	marginTop    = 40
+	marginBottom = 50
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+func (c Chart) bounds() (x0, x1, y0, y1 float64) {
+	x0, y0 = math.Inf(1), math.Inf(1)
+	x1, y1 = math.Inf(-1), math.Inf(-1)
+
+	for _, s := range c.Series {
+		for i := range s.X {
+			x0, x1 = math.Min(x0, s.X[i]), math.Max(x1, s.X[i])
+			y0, y1 = math.Min(y0, s.Y[i]), math.Max(y1, s.Y[i])
+			if i < len(s.Y2) {
+				y0, y1 = math.Min(y0, s.Y2[i]), math.Max(y1, s.Y2[i])
+			}
+		}
+	}
+
+	if math.IsInf(x0, 0) {
+		return 0, 1, 0, 1
+	}
+
+	if x0 == x1 {
+		x0, x1 = x0 - 1, x1 + 1
+	}
+
+	if y0 == y1 {
+		y0, y1 = y0 - 1, y1 + 1
+	}
+
+	return
+}
+
+// ticks returns "nice" tick positions covering [lo, hi]
+func ticks(lo, hi float64, n int) []float64 {
+	step := math.Pow(10, math.Floor(math.Log10((hi - lo) / float64(n))))
+	for _, k := range []float64{ 1, 2, 5, 10 } {
+		if (hi - lo) / (step * k) <= float64(n) {
+			step *= k
+			break
+		}
+	}
+
+	var res []float64
+	for v := math.Ceil(lo / step) * step; v <= hi + step * 1e-9; v += step {
+		res = append(res, v)
+	}
+	return res
+}
+
+func (c Chart) WriteSVG(w io.Writer) error {
+	width, height := c.Width, c.Height
+	if width == 0 {
+		width = 800
+	}
+	if height == 0 {
+		height = 500
+	}
+
+	pw := float64(width - marginLeft - marginRight)
+	ph := float64(height - marginTop - marginBottom)
+
+	x0, x1, y0, y1 := c.bounds()
+	px := func(x float64) float64 { return marginLeft + (x - x0) / (x1 - x0) * pw }
+	py := func(y float64) float64 { return marginTop + ph - (y - y0) / (y1 - y0) * ph }
+
+	var b strings.Builder
+	p := func(t string, args ...interface{}) {
+		fmt.Fprintf(&b, t + "\n", args...)
+	}
+
+	p(`<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" font-family="sans-serif" font-size="12">`, width, height)
+	p(`<rect width="100%%" height="100%%" fill="white"/>`)
+	p(`<text x="%d" y="24" font-size="16" text-anchor="middle">%s</text>`, width / 2, html.EscapeString(c.Title))
+
+	for _, v := range ticks(x0, x1, 8) {
+		p(`<line x1="%.1f" y1="%d" x2="%.1f" y2="%.1f" stroke="#ddd"/>`, px(v), marginTop, px(v), marginTop + ph)
+		p(`<text x="%.1f" y="%.1f" text-anchor="middle">%g</text>`, px(v), marginTop + ph + 16, v)
+	}
+
+	for _, v := range ticks(y0, y1, 6) {
+		p(`<line x1="%d" y1="%.1f" x2="%.1f" y2="%.1f" stroke="#ddd"/>`, marginLeft, py(v), marginLeft + pw, py(v))
+		p(`<text x="%d" y="%.1f" text-anchor="end">%g</text>`, marginLeft - 6, py(v) + 4, v)
+	}
+
+	p(`<rect x="%d" y="%d" width="%.1f" height="%.1f" fill="none" stroke="black"/>`, marginLeft, marginTop, pw, ph)
+	p(`<text x="%.1f" y="%d" text-anchor="middle">%s</text>`, marginLeft + pw / 2, height - 12, html.EscapeString(c.XLabel))
+	p(`<text x="16" y="%.1f" text-anchor="middle" transform="rotate(-90 16 %.1f)">%s</text>`,
+		marginTop + ph / 2, marginTop + ph / 2, html.EscapeString(c.YLabel))
+
+	for i, s := range c.Series {
+		color := palette[i % len(palette)]
+
+		switch s.Style {
+		case StyleLine:
+			var pts []string
+			for j := range s.X {
+				pts = append(pts, fmt.Sprintf("%.1f,%.1f", px(s.X[j]), py(s.Y[j])))
+			}
+			p(`<polyline points="%s" fill="none" stroke="%s" stroke-width="2"/>`, strings.Join(pts, " "), color)
+		case StylePoints:
+			for j := range s.X {
+				p(`<circle cx="%.1f" cy="%.1f" r="3" fill="%s"/>`, px(s.X[j]), py(s.Y[j]), color)
+			}
+		case StyleBand:
+			var pts []string
+			for j := range s.X {
+				pts = append(pts, fmt.Sprintf("%.1f,%.1f", px(s.X[j]), py(s.Y[j])))
+			}
+			for j := len(s.X) - 1; j >= 0 && j < len(s.Y2); j-- {
+				pts = append(pts, fmt.Sprintf("%.1f,%.1f", px(s.X[j]), py(s.Y2[j])))
+			}
+			p(`<polygon points="%s" fill="%s" fill-opacity="0.2" stroke="none"/>`, strings.Join(pts, " "), color)
+		}
+
+		if len(s.Name) > 0 {
+			ly := marginTop + 16 + i * 16
+			p(`<rect x="%.1f" y="%d" width="10" height="10" fill="%s"/>`, marginLeft + pw - 150, ly - 9, color)
+			p(`<text x="%.1f" y="%d">%s</text>`, marginLeft + pw - 135, ly, html.EscapeString(s.Name))
+		}
+	}
+
+	for _, m := range c.Markers {
+		if m.X < x0 || m.X > x1 {
+			continue
+		}
+		p(`<line x1="%.1f" y1="%d" x2="%.1f" y2="%.1f" stroke="#555" stroke-dasharray="4,3"/>`, px(m.X), marginTop, px(m.X), marginTop + ph)
+		p(`<text x="%.1f" y="%d" font-size="10" transform="rotate(-90 %.1f %d)" text-anchor="end">%s</text>`,
+			px(m.X) - 3, marginTop + 4, px(m.X) - 3, marginTop + 4, html.EscapeString(m.Label))
+	}
+
+	p(`</svg>`)
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
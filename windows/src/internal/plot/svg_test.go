@@ -0,0 +1,26 @@
+package plot
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestMarkers(t *testing.T) {
+	c := Chart{
+		Series: []Series{ { Name: "rpm", Style: StyleLine, X: []float64{ 0, 10 }, Y: []float64{ 0, 1 } } },
+		Markers: []Marker{ { X: 5, Label: "prop <changed>" }, { X: 20, Label: "outside" } },
+	}
+
+	var b strings.Builder
+	if err := c.WriteSVG(&b); err != nil {
+		t.Fatal(err)
+	}
+	svg := b.String()
+
+	if n := strings.Count(svg, `stroke-dasharray`); n != 1 {
+		t.Errorf("%d marker lines", n)
+	}
+	if !strings.Contains(svg, "prop &lt;changed&gt;") || strings.Contains(svg, "outside") {
+		t.Errorf("marker labels in %s", svg)
+	}
+}
@@ -0,0 +1,151 @@
+package script
+
+import (
+	"sort"
+	"sync"
+	"time"
+	"context"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+// Clock drives script time: sleep and the blocking wait primitives.
+type Clock interface {
+	Now() time.Time
+	// Sleep blocks for d or until ctx is done
+	Sleep(ctx context.Context, d time.Duration)
+	// Wait blocks until ch delivers a value, the deadline passes (zero
+	// deadline means no deadline) or ctx is done
+	Wait(ctx context.Context, ch <-chan interface{}, deadline time.Time) (interface{}, bool)
+}
+
+type realClock struct{}
+
+var RealClock Clock = realClock{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) Sleep(ctx context.Context, d time.Duration) {
+	select {
+	case <-ctx.Done():
+	case <-time.After(d):
+	}
+}
+
+func (realClock) Wait(ctx context.Context, ch <-chan interface{}, deadline time.Time) (interface{}, bool) {
+	var timeout <-chan time.Time
+	if !deadline.IsZero() {
+		timer := time.NewTimer(time.Until(deadline))
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	select {
+	case v := <-ch:
+		return v, true
+	case <-timeout:
+	case <-ctx.Done():
+	}
+
+	return nil, false
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// VirtualClock is a deterministic clock where time only moves when the
+// script sleeps or waits: scheduled events (e.g. telemetry of a simulated
+// device) run in time order on the waiting goroutine, the rest of the wait
+// takes no real time.
+type VirtualClock struct {
+	sync.Mutex
+	now time.Time
+	events []event // ordered by time
+}
+
+type event struct {
+	at time.Time
+	fn func()
+}
+
+func NewVirtualClock(start time.Time) *VirtualClock {
+	return &VirtualClock{ now: start }
+}
+
+func (c *VirtualClock) Now() time.Time {
+	c.Lock()
+	defer c.Unlock()
+	return c.now
+}
+
+// Schedule runs fn at the given virtual time, events in the past run on the
+// next sleep or wait.
+func (c *VirtualClock) Schedule(at time.Time, fn func()) {
+	c.Lock()
+	defer c.Unlock()
+
+	// after the events already scheduled at the same time
+	i := sort.Search(len(c.events), func(i int) bool {
+		return c.events[i].at.After(at)
+	})
+
+	c.events = append(c.events, event{})
+	copy(c.events[i+1:], c.events[i:])
+	c.events[i] = event{ at, fn }
+}
+
+// step runs the earliest event due not later than until (zero - any),
+// returns false if there is none
+func (c *VirtualClock) step(until time.Time) bool {
+	c.Lock()
+	if len(c.events) == 0 || (!until.IsZero() && c.events[0].at.After(until)) {
+		c.Unlock()
+		return false
+	}
+
+	e := c.events[0]
+	c.events = c.events[1:]
+	if e.at.After(c.now) {
+		c.now = e.at
+	}
+	c.Unlock()
+
+	e.fn()
+	return true
+}
+
+func (c *VirtualClock) advance(to time.Time) {
+	c.Lock()
+	defer c.Unlock()
+	if to.After(c.now) {
+		c.now = to
+	}
+}
+
+func (c *VirtualClock) Sleep(ctx context.Context, d time.Duration) {
+	until := c.Now().Add(d)
+	for ctx.Err() == nil && c.step(until) {
+	}
+	c.advance(until)
+}
+
+// Wait runs events until one of them delivers a value to ch. Without a
+// deadline it gives up when there are no more events: nothing else is going
+// to happen in virtual time.
+func (c *VirtualClock) Wait(ctx context.Context, ch <-chan interface{}, deadline time.Time) (interface{}, bool) {
+	for ctx.Err() == nil {
+		select {
+		case v := <-ch:
+			return v, true
+		default:
+		}
+
+		if !c.step(deadline) {
+			break
+		}
+	}
+
+	c.advance(deadline)
+	return nil, false
+}
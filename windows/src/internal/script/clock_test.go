@@ -0,0 +1,118 @@
+package script
+
+import (
+	"strings"
+	"time"
+	"context"
+	"testing"
+)
+
+var epoch = time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+
+func TestVirtualClockSchedule(t *testing.T) {
+	c := NewVirtualClock(epoch)
+
+	var got []string
+	at := func(ms int, name string) {
+		c.Schedule(epoch.Add(time.Duration(ms) * time.Millisecond), func() {
+			got = append(got, name)
+		})
+	}
+
+	at(30, "c")
+	at(10, "a1")
+	at(20, "b")
+	at(10, "a2")
+	at(0, "now")
+
+	c.Sleep(context.Background(), 25 * time.Millisecond)
+
+	if want := "now a1 a2 b"; strings.Join(got, " ") != want {
+		t.Errorf("got %q, want %q", strings.Join(got, " "), want)
+	}
+
+	if d := c.Now().Sub(epoch); d != 25 * time.Millisecond {
+		t.Errorf("now %v after sleep", d)
+	}
+
+	c.Sleep(context.Background(), time.Second)
+	if want := "now a1 a2 b c"; strings.Join(got, " ") != want {
+		t.Errorf("got %q, want %q", strings.Join(got, " "), want)
+	}
+}
+
+func TestVirtualClockPeriodic(t *testing.T) {
+	c := NewVirtualClock(epoch)
+
+	// an event scheduling the next one, like telemetry of the simulator
+	n := 0
+	var tick func()
+	tick = func() {
+		n++
+		c.Schedule(c.Now().Add(10 * time.Millisecond), tick)
+	}
+	c.Schedule(epoch, tick)
+
+	c.Sleep(context.Background(), time.Second)
+	if n != 101 {
+		t.Errorf("%d ticks in a second", n)
+	}
+}
+
+func TestVirtualClockWait(t *testing.T) {
+	tests := []struct {
+		name string
+		fire int // ms, < 0 - never
+		deadline int // ms, 0 - none
+		ok bool
+		now int
+	}{
+		{ "delivered", 50, 100, true, 50 },
+		{ "deadline", 150, 100, false, 100 },
+		{ "no deadline", 150, 0, true, 150 },
+		{ "nothing to wait for", -1, 0, false, 20 },
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := NewVirtualClock(epoch)
+			ch := make(chan interface{}, 1)
+
+			// unrelated events keep the clock going
+			for ms := 0; ms <= 20; ms += 10 {
+				c.Schedule(epoch.Add(time.Duration(ms) * time.Millisecond), func() {})
+			}
+
+			if tt.fire >= 0 {
+				c.Schedule(epoch.Add(time.Duration(tt.fire) * time.Millisecond), func() { ch <- "ok" })
+			}
+
+			var deadline time.Time
+			if tt.deadline > 0 {
+				deadline = epoch.Add(time.Duration(tt.deadline) * time.Millisecond)
+			}
+
+			v, ok := c.Wait(context.Background(), ch, deadline)
+			if ok != tt.ok || (ok && v != "ok") {
+				t.Errorf("got %v, %v", v, ok)
+			}
+
+			if d := c.Now().Sub(epoch); d != time.Duration(tt.now) * time.Millisecond {
+				t.Errorf("now %v", d)
+			}
+		})
+	}
+}
+
+func TestVirtualClockCancel(t *testing.T) {
+	c := NewVirtualClock(epoch)
+	ctx, cancel := context.WithCancel(context.Background())
+
+	ran := false
+	c.Schedule(epoch.Add(time.Millisecond), cancel)
+	c.Schedule(epoch.Add(2 * time.Millisecond), func() { ran = true })
+
+	if _, ok := c.Wait(ctx, make(chan interface{}), time.Time{}); ok || ran {
+		t.Errorf("wait went on after cancel: %v, %v", ok, ran)
+	}
+}
@@ -0,0 +1,87 @@
+package script
+
+import (
+	"fmt"
+	"sort"
+	"time"
+	"strings"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+const (
+	LogDebug	= "debug"
+	LogInfo		= "info"
+	LogWarn		= "warn"
+	LogError	= "error"
+	LogPrint	= "print" // plain script output
+)
+
+type LogEntry struct {
+	Time    time.Time
+	Ts      string // device ts of the record being handled, "" - unknown
+	Level   string
+	Message string
+	Fields  map[string]interface{}
+}
+
+type Logger interface {
+	Log(LogEntry)
+}
+
+// Mark is a named event inserted into the run, e.g. "prop changed"
+type Mark struct {
+	Time time.Time
+	Ts   string // device ts of the record being handled, "" - unknown
+	Name string
+	Data map[string]interface{}
+}
+
+type Marker interface {
+	Mark(Mark)
+}
+
+// FormatValue renders a value for a key=value pair, quoting when needed
+func FormatValue(v interface{}) string {
+	s := fmt.Sprint(v)
+	if len(s) == 0 || strings.ContainsAny(s, " \t\n\"=") {
+		return fmt.Sprintf("%q", s)
+	}
+	return s
+}
+
+// FormatFields renders fields as key=value pairs sorted by key
+func FormatFields(fields map[string]interface{}) string {
+	var keys []string
+	for k := range fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var res []string
+	for _, k := range keys {
+		res = append(res, k + "=" + FormatValue(fields[k]))
+	}
+
+	return strings.Join(res, " ")
+}
+
+func (e LogEntry) FormatFields() string {
+	return FormatFields(e.Fields)
+}
+
+type stdLogger struct{}
+
+// StdLogger prints entries to stdout
+var StdLogger Logger = stdLogger{}
+
+func (stdLogger) Log(e LogEntry) {
+	s := e.Message
+	if e.Level != LogPrint {
+		s = strings.ToUpper(e.Level) + " " + s
+	}
+	if f := e.FormatFields(); len(f) > 0 {
+		s += " " + f
+	}
+	fmt.Println(s)
+}
@@ -0,0 +1,87 @@
+package lua
+
+import (
+	"strings"
+
+	"github.com/yuin/gopher-lua"
+
+	dms "dronmotors/dmetrics/internal/script"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+func (s *script) log(level string, msg string, fields map[string]interface{}) {
+	ts, _ := s.feed.ts.Load().(string)
+	s.logger.Log(dms.LogEntry{
+		Time: s.clock.Now(),
+		Ts: ts,
+		Level: level,
+		Message: msg,
+		Fields: fields,
+	})
+}
+
+func logFields(L *lua.LState, v lua.LValue) map[string]interface{} {
+	tbl, ok := v.(*lua.LTable)
+	if !ok {
+		return nil
+	}
+
+	res := map[string]interface{}{}
+	tbl.ForEach(func(k lua.LValue, v lua.LValue) {
+		switch v := v.(type) {
+		case lua.LNumber:
+			res[k.String()] = float64(v)
+		case lua.LBool:
+			res[k.String()] = bool(v)
+		default:
+			res[k.String()] = L.ToStringMeta(v).String()
+		}
+	})
+
+	return res
+}
+
+// setLogGlobals replaces print and adds log.debug/info/warn/error(msg, fields)
+// so that all script output goes through the logger
+func (s *script) setLogGlobals() {
+	s.l.Register("print", func(L *lua.LState) int {
+		var args []string
+		for i := 1; i <= L.GetTop(); i++ {
+			args = append(args, L.ToStringMeta(L.Get(i)).String())
+		}
+		s.log(dms.LogPrint, strings.Join(args, "\t"), nil)
+		return 0
+	})
+
+	levels := []string{ dms.LogDebug, dms.LogInfo, dms.LogWarn, dms.LogError }
+
+	tbl := s.l.NewTable()
+	for _, level := range levels {
+		level := level
+		tbl.RawSetString(level, s.l.NewFunction(func(L *lua.LState) int {
+			s.log(level, L.ToStringMeta(L.Get(1)).String(), logFields(L, L.Get(2)))
+			return 0
+		}))
+	}
+
+	s.l.SetGlobal("log", tbl)
+
+	// mark(name, data) - timestamped event of the run, also logged
+	s.l.Register("mark", func(L *lua.LState) int {
+		ts, _ := s.feed.ts.Load().(string)
+		m := dms.Mark{
+			Time: s.clock.Now(),
+			Ts: ts,
+			Name: L.CheckString(1),
+			Data: logFields(L, L.Get(2)),
+		}
+
+		if s.marker != nil {
+			s.marker.Mark(m)
+		}
+
+		s.log(dms.LogInfo, "mark: " + m.Name, m.Data)
+		return 0
+	})
+}
@@ -0,0 +1,78 @@
+package lua
+
+import (
+	"fmt"
+	"context"
+	"strings"
+	"testing"
+
+	dms "dronmotors/dmetrics/internal/script"
+)
+
+type entries struct {
+	logs []dms.LogEntry
+	marks []dms.Mark
+}
+
+func (e *entries) Log(l dms.LogEntry) { e.logs = append(e.logs, l) }
+func (e *entries) Mark(m dms.Mark) { e.marks = append(e.marks, m) }
+
+// stamped is a record with a device time stamp
+type stamped struct {
+	Ts int
+}
+
+func (r *stamped) AsKeys() []string { return []string{ "ts" } }
+func (r *stamped) AsValues() []string { return []string{ fmt.Sprint(r.Ts) } }
+
+func TestLogTs(t *testing.T) {
+	const text = `
+		return {
+			OnTelemetry = function(t)
+				log.info('record', { ts = t.Ts })
+				if t.Ts == 20 then
+					mark('half')
+				end
+			end,
+			Test = function()
+				log.info('before')
+				sleep(10)
+				print('after')
+			end,
+		}`
+
+	var e entries
+	s, err := NewScript(text, Options{ Name: t.Name(), Logger: &e, Marker: &e })
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Release()
+
+	if r, err := s.Bind(provider{}); err != nil {
+		t.Fatal(err)
+	} else {
+		defer r.Release()
+	}
+
+	// all queued before the script handles any: each line gets the ts of
+	// the record handled, not of the latest one received
+	for ts := 10; ts <= 30; ts += 10 {
+		s.Feed(context.Background(), &stamped{ Ts: ts })
+	}
+
+	if err := s.Execute(context.Background(), "Test"); err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	for _, l := range e.logs {
+		got = append(got, l.Message + "@" + l.Ts)
+	}
+	if want := "before@ record@10 record@20 mark: half@20 record@30 after@30"; strings.Join(got, " ") != want {
+		t.Errorf("got %q, want %q", strings.Join(got, " "), want)
+	}
+
+	if len(e.marks) != 1 || e.marks[0].Ts != "20" {
+		t.Errorf("marks %+v", e.marks)
+	}
+}
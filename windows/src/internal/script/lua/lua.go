@@ -5,9 +5,11 @@ import (
 
 	"sync"
 	"time"
-	"strconv"
 	"strings"
 	"context"
+	"reflect"
+	"sync/atomic"
+	"path/filepath"
 
 	"layeh.com/gopher-luar"
 	"github.com/yuin/gopher-lua"
@@ -32,7 +34,13 @@ type script struct {
 
 	l *lua.LState
 	fns sfnstbl
-	params map[string]string
+	params paramsTbl
+	result dms.Result
+	feed feed
+	clock dms.Clock
+	logger dms.Logger
+	marker dms.Marker
+	tag atomic.Value // phase tag, string
 
 	threads map[string]*thread
 }
@@ -43,19 +51,30 @@ type thread struct {
 	cancel context.CancelFunc
 }
 
-func (s *script) newScript(text string) error {
+func (s *script) newScript(text string, name string) error {
 	s.l.SetContext(context.Background())
-	if err := s.l.DoString(text); err != nil {
+
+	fn, err := s.l.Load(strings.NewReader(text), name)
+	if err != nil {
+		return err
+	}
+
+	s.l.Push(fn)
+	if err := s.l.PCall(0, lua.MultRet, nil); err != nil {
 		return err
 	} else if res, ok := s.l.Get(-1).(*lua.LTable); !ok {
 		return errorf("no table found")
 	} else if err := gluamapper.Map(res, &s.fns); err != nil {
 		return err
+	} else if s.params.schema, err = parseSchema(res); err != nil {
+		return err
 	} else if s.fns.Test == nil {
 		return errorf("no test function found")
-	} else {
-		return nil
+	} else if s.params.schema == nil {
+		s.params.schema = readParams(text) // no Params, what the getters read
 	}
+
+	return nil
 }
 
 type releasable struct {
@@ -69,55 +88,62 @@ func (r *releasable) Release() {
 func (s *script) setGlobals(provider dms.Scriptable) {
 	s.l.Register("stop", func(L *lua.LState) int {
 		if msg := L.ToString(1); len(msg) > 0 {
-			fmt.Println(errorf(msg))
+			s.log(dms.LogError, msg, nil)
 		}
 		panic(dms.ErrStopped)
-		return 0
 	})
 
 	s.l.Register("sleep", func(L *lua.LState) int {
-		select {
-		case <-L.Context().Done():
-		case <-time.After(time.Duration(L.ToInt(1)) * time.Millisecond):
+		deadline := s.clock.Now().Add(time.Duration(L.ToInt(1)) * time.Millisecond)
+		s.dispatch(L)
+		for s.next(L, deadline) != nil {
 		}
 		return 0
 	})
 
-	s.l.Register("intParam", func(L *lua.LState) int {
-		arg := L.ToString(1)
+	s.setParamGlobals()
 
-		if v, ok := s.params[arg]; ok {
-			if n, err := strconv.Atoi(v); err != nil {
-				L.Push(lua.LNumber(L.ToInt(2)))
-			} else {
-				L.Push(lua.LNumber(n))
+	s.l.Register("expect", func(L *lua.LState) int {
+		limit := func(n int) *float64 {
+			if v, ok := L.Get(n).(lua.LNumber); ok {
+				f := float64(v)
+				return &f
 			}
+			return nil
+		}
+
+		name := L.CheckString(1)
+		if v, ok := L.Get(2).(lua.LNumber); !ok {
+			s.result.Error(name, fmt.Sprintf("expected number, got %s", L.Get(2).Type()))
+			L.Push(lua.LFalse)
 		} else {
-			L.Push(lua.LNumber(L.ToInt(2)))
+			L.Push(lua.LBool(s.result.Expect(name, float64(v), limit(3), limit(4))))
 		}
 
 		return 1
 	})
-	
-	s.l.Register("strParam", func(L *lua.LState) int {
-		arg := L.ToString(1)
 
-		if v, ok := s.params[arg]; ok {
-			L.Push(lua.LString(v))
-		} else {
-			L.Push(lua.LString(L.ToString(2)))
-		}
+	s.l.Register("check", func(L *lua.LState) int {
+		L.Push(lua.LBool(s.result.Check(L.ToBool(1), L.OptString(2, "check"))))
+		return 1
+	})
 
+	s.l.Register("verdict", func(L *lua.LState) int {
+		L.Push(lua.LString(s.result.Verdict()))
 		return 1
 	})
 
+	s.setWaitGlobals()
 }
 
 func (s *script) releaseGlobals() {
 	s.l.SetGlobal("stop", nil)
 	s.l.SetGlobal("sleep", nil)
-	s.l.SetGlobal("intParam", nil)
-	s.l.SetGlobal("strParam", nil)
+	s.releaseParamGlobals()
+	s.l.SetGlobal("expect", nil)
+	s.l.SetGlobal("check", nil)
+	s.l.SetGlobal("verdict", nil)
+	s.releaseWaitGlobals()
 }
 
 func (s *script) Bind(provider dms.Scriptable) (dms.Releasable, error) {
@@ -128,25 +154,18 @@ func (s *script) Bind(provider dms.Scriptable) (dms.Releasable, error) {
 	for _, m := range provider.Methods() {
 		method := m
 		s.l.Register(method, func(L *lua.LState) int {
-			args := []dms.Value{
-				value{ L.Get(1) },
-				value{ L.Get(2) },
-				// FIXME: add more values, if needed
+			args := []dms.Value{}
+			for i := 1; i <= L.GetTop(); i++ {
+				args = append(args, value{ L.Get(i) })
 			}
 
+			s.dispatch(L)
+
 			if v, err := provider.Control(method, args...); err != nil {
-				panic(err)
+				L.RaiseError("%s", err.Error())
+				return 0
 			} else if (v != nil) {
-				switch n := v.(type) {
-				case int:
-					L.Push(lua.LNumber(n))
-				case string:
-					L.Push(lua.LString(n))
-				default:
-					fmt.Println(errorf("control return value type is not supported"))
-					return 0
-				}
-
+				L.Push(toLValue(L, v))
 				return 1
 			} else {
 				return 0 // nil case
@@ -229,18 +248,146 @@ func (s *script) Execute(ctx context.Context, entry string, args ...interface{})
 	return nil
 }
 
+// recordTag is the Tag field of a telemetry record, nil if it has none
+func recordTag(t interface{}) *reflect.Value {
+	if v := reflect.Indirect(reflect.ValueOf(t)); v.Kind() == reflect.Struct {
+		if f := v.FieldByName("Tag"); f.CanSet() && f.Kind() == reflect.String {
+			return &f
+		}
+	}
+	return nil
+}
+
+// recordCopy gives the script its own copy of a record: other subscribers
+// read the original concurrently
+func recordCopy(t interface{}) interface{} {
+	v := reflect.ValueOf(t)
+	if v.Kind() != reflect.Pointer || v.Elem().Kind() != reflect.Struct {
+		return t
+	}
+
+	c := reflect.New(v.Elem().Type())
+	c.Elem().Set(v.Elem())
+	return c.Interface()
+}
+
+// Feed applies the phase tag and queues a copy of the telemetry record for
+// the script goroutine: OnTelemetry gets its own copy, so a Tag assigned
+// there tags the records from the next one on, not the one being handled.
+// It never blocks: if the script lags behind, the record is dropped.
+func (s *script) Feed(ctx context.Context, t interface{}) error {
+	if tag, _ := s.tag.Load().(string); len(tag) > 0 {
+		if f := recordTag(t); f != nil {
+			f.SetString(tag)
+		}
+	}
+
+	select {
+	case s.feed.queue <- recordCopy(t):
+	default:
+		if s.feed.dropped.Add(1) == 1 {
+			s.log(dms.LogWarn, "script is lagging behind, telemetry dropped", nil)
+		}
+	}
+
+	return nil
+}
+
+func (s *script) native() *lua.LTable {
+	t := s.l.NewTable()
+
+	t.RawSetString("tag", s.l.NewFunction(func(L *lua.LState) int {
+		tag, _ := s.tag.Load().(string)
+		L.Push(lua.LString(tag))
+		return 1
+	}))
+
+	t.RawSetString("setTag", s.l.NewFunction(func(L *lua.LState) int {
+		s.tag.Store(L.OptString(1, ""))
+		return 0
+	}))
+
+	return t
+}
+
+func (s *script) Result() *dms.Result {
+	return &s.result
+}
+
+func (s *script) Dropped() uint64 {
+	return s.feed.dropped.Load()
+}
+
 func (s *script) Release() {
 	s.l.Close()
 }
 
-func NewScript(text string, params map[string]string) (dms.Script, error) {
+// Options of a new script: Name is used in error messages, Path lists
+// directories require() looks for modules in (before the embedded stdlib),
+// Clock drives sleep and waits (real time if nil), Logger receives log.*()
+// and print() output (stdout if nil), Marker receives mark() events.
+type Options struct {
+	Name   string
+	Params map[string]string
+	Path   []string
+	Clock  dms.Clock
+	Logger dms.Logger
+	Marker dms.Marker
+}
+
+func (s *script) setPath(dirs []string) {
+	var path []string
+	for _, dir := range dirs {
+		path = append(path,
+			filepath.Join(dir, "?.lua"),
+			filepath.Join(dir, "?", "init.lua"),
+		)
+	}
+
+	// no default ./?.lua: the working directory is not a module source
+	if pkg, ok := s.l.GetGlobal("package").(*lua.LTable); ok {
+		pkg.RawSetString("path", lua.LString(strings.Join(path, ";")))
+	}
+}
+
+func NewScript(text string, opts Options) (dms.Script, error) {
 	s := &script{
-		params: params,
+		params: paramsTbl{
+			args: opts.Params,
+			used: map[string]string{},
+		},
 		l: lua.NewState(),
+		feed: feed{
+			queue: make(chan interface{}, feedQueueSize),
+		},
+		clock: opts.Clock,
+		logger: opts.Logger,
+		marker: opts.Marker,
 		threads: make(map[string]*thread),
 	}
 
-	if err := s.newScript(text); err != nil {
+	if s.clock == nil {
+		s.clock = dms.RealClock
+	}
+
+	s.result.Clock = s.clock
+
+	if s.logger == nil {
+		s.logger = dms.StdLogger
+	}
+
+	if len(opts.Name) == 0 {
+		opts.Name = "<string>"
+	}
+
+	s.setPath(opts.Path)
+	s.setLogGlobals()
+
+	if err := s.preloadStdlib(s.native()); err != nil {
+		s.l.Close()
+		return nil, err
+	} else if err := s.newScript(text, opts.Name); err != nil {
+		s.l.Close()
 		return nil, err
 	} else {
 		return s, nil
@@ -0,0 +1,153 @@
+package lua
+
+import (
+	"context"
+	"testing"
+
+	dms "dronmotors/dmetrics/internal/script"
+)
+
+type provider struct{}
+
+func (provider) Methods() []string {
+	return nil
+}
+
+func (provider) Signatures() []dms.Signature {
+	return nil
+}
+
+func (provider) Control(string, ...dms.Value) (interface{}, error) {
+	return nil, nil
+}
+
+type record struct {
+	N int
+	RPM float64
+	Tag string
+}
+
+func run(t *testing.T, text string, feed func(dms.Script)) dms.Script {
+	s, err := NewScript(text, Options{ Name: t.Name() })
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(s.Release)
+
+	r, err := s.Bind(provider{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Release()
+
+	feed(s)
+	if err := s.Execute(context.Background(), "Test"); err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestFeedCopy(t *testing.T) {
+	const text = `
+		local seen = 0
+		return {
+			OnTelemetry = function(t)
+				seen = seen + 1
+				t.RPM = -1
+			end,
+			Test = function()
+				local t = latest()
+				check(t ~= nil and t.N == 3 and t.RPM == -1 and seen == 3, 'records')
+			end,
+		}`
+
+	var recs []*record
+	s := run(t, text, func(s dms.Script) {
+		for i := 1; i <= 3; i++ {
+			r := &record{ N: i, RPM: 5000 }
+			recs = append(recs, r)
+			s.Feed(context.Background(), r)
+		}
+	})
+
+	if v := s.Result().Verdict(); v != dms.VerdictPass {
+		t.Errorf("verdict %s: %+v", v, s.Result())
+	}
+
+	// other subscribers read the originals
+	for _, r := range recs {
+		if r.RPM != 5000 {
+			t.Errorf("record %d changed by the script: %+v", r.N, r)
+		}
+	}
+}
+
+func TestFeedDrops(t *testing.T) {
+	const text = `return { Test = function() end }`
+
+	s := run(t, text, func(s dms.Script) {
+		// nothing delivers while the script is not running
+		for i := 0; i < feedQueueSize + 10; i++ {
+			s.Feed(context.Background(), &record{ N: i })
+		}
+	})
+
+	if n := s.Dropped(); n != 10 {
+		t.Errorf("dropped %d, want 10", n)
+	}
+}
+
+func TestFeedTag(t *testing.T) {
+	const text = `
+		local stand = require 'stand'
+		local started = false
+		return {
+			OnTelemetry = function(t)
+				if t.N == 2 then
+					t.Tag = 'b'
+				end
+			end,
+			Test = function()
+				if not started then
+					stand.setTag('a')
+					started = true
+				else
+					latest()
+				end
+			end,
+		}`
+
+	recs := []*record{ { N: 1 }, { N: 2 }, { N: 3 } }
+	ctx := context.Background()
+
+	s, err := NewScript(text, Options{ Name: t.Name() })
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Release()
+
+	if r, err := s.Bind(provider{}); err != nil {
+		t.Fatal(err)
+	} else {
+		defer r.Release()
+	}
+
+	if err := s.Execute(ctx, "Test"); err != nil {
+		t.Fatal(err)
+	}
+
+	// the tag is applied when a record is fed, assigning it in OnTelemetry
+	// works from the next record on
+	s.Feed(ctx, recs[0])
+	s.Feed(ctx, recs[1])
+	if err := s.Execute(ctx, "Test"); err != nil {
+		t.Fatal(err)
+	}
+	s.Feed(ctx, recs[2])
+
+	for i, want := range []string{ "a", "a", "b" } {
+		if recs[i].Tag != want {
+			t.Errorf("record %d: tag %q, want %q", recs[i].N, recs[i].Tag, want)
+		}
+	}
+}
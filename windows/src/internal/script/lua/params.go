@@ -0,0 +1,203 @@
+package lua
+
+import (
+	"fmt"
+	"sync"
+	"regexp"
+
+	"github.com/yuin/gopher-lua"
+
+	dms "dronmotors/dmetrics/internal/script"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+type paramsTbl struct {
+	sync.Mutex
+	args map[string]string
+	schema []dms.Param
+	used map[string]string
+}
+
+// parseSchema reads the Params field of the script table:
+//
+//   Params = {
+//      { name = 'delay', type = 'int', default = 250, min = 10, max = 5000,
+//        description = 'step delay, ms' },
+//   }
+func parseSchema(tbl *lua.LTable) ([]dms.Param, error) {
+	var schema []dms.Param
+
+	decl, ok := tbl.RawGetString("Params").(*lua.LTable)
+	if !ok {
+		return nil, nil
+	}
+
+	var err error
+	decl.ForEach(func(_, v lua.LValue) {
+		t, ok := v.(*lua.LTable)
+		if !ok {
+			err = errorf("param declaration must be a table")
+			return
+		}
+
+		p := dms.Param{
+			Name: lua.LVAsString(t.RawGetString("name")),
+			Type: lua.LVAsString(t.RawGetString("type")),
+			Description: lua.LVAsString(t.RawGetString("description")),
+		}
+
+		if len(p.Name) == 0 {
+			err = errorf("param declaration without name")
+			return
+		} else if len(p.Type) == 0 {
+			p.Type = dms.ParamString
+		}
+
+		switch d := t.RawGetString("default"); d.Type() {
+		case lua.LTNil:
+		case lua.LTBool:
+			p.Default = fmt.Sprintf("%t", lua.LVAsBool(d))
+		default:
+			p.Default = lua.LVAsString(d)
+		}
+
+		for _, lim := range []struct{ key string; dst **float64 }{ { "min", &p.Min }, { "max", &p.Max } } {
+			if n, ok := t.RawGetString(lim.key).(lua.LNumber); ok {
+				f := float64(n)
+				*lim.dst = &f
+			}
+		}
+
+		if len(p.Default) > 0 {
+			if _, e := p.Parse(p.Default); e != nil {
+				err = errorf("default: %v", e)
+				return
+			}
+		}
+
+		schema = append(schema, p)
+	})
+
+	return schema, err
+}
+
+// getterCall is a typed getter call with a literal name
+var getterCall = regexp.MustCompile(`\b(int|float|bool|str)Param\s*\(?\s*['"]([^'"]+)['"]`)
+
+// readParams lists the params the script text reads through the typed
+// getters, the schema of a script without Params: a name composed at run
+// time is not seen, such a script has to declare its params
+func readParams(text string) []dms.Param {
+	types := map[string]string{
+		"int": dms.ParamInt,
+		"float": dms.ParamFloat,
+		"bool": dms.ParamBool,
+		"str": dms.ParamString,
+	}
+
+	var schema []dms.Param
+	seen := map[string]bool{}
+	for _, m := range getterCall.FindAllStringSubmatch(text, -1) {
+		if !seen[m[2]] {
+			seen[m[2]] = true
+			schema = append(schema, dms.Param{ Name: m[2], Type: types[m[1]] })
+		}
+	}
+	return schema
+}
+
+// value returns the parameter value: command line argument first, then the
+// declared default, then the default passed to the call, then zero value
+func (p *paramsTbl) value(L *lua.LState, typ string) lua.LValue {
+	name := L.CheckString(1)
+
+	decl := dms.Param{ Name: name }
+	for _, d := range p.schema {
+		if d.Name == name {
+			decl = d
+		}
+	}
+	decl.Type = typ
+
+	raw, ok := p.args[name]
+	if !ok {
+		switch def := L.Get(2); {
+		case len(decl.Default) > 0:
+			raw, ok = decl.Default, true
+		case def.Type() == lua.LTBool:
+			raw, ok = fmt.Sprintf("%t", lua.LVAsBool(def)), true
+		case def.Type() != lua.LTNil:
+			raw, ok = lua.LVAsString(def), true
+		}
+	}
+
+	var res interface{}
+	if ok {
+		v, err := decl.Parse(raw)
+		if err != nil {
+			L.RaiseError("%s", err.Error())
+			return lua.LNil
+		}
+		res = v
+	} else {
+		res = map[string]interface{}{
+			dms.ParamInt: 0,
+			dms.ParamFloat: 0.0,
+			dms.ParamBool: false,
+			dms.ParamString: "",
+		}[typ]
+	}
+
+	p.Lock()
+	p.used[name] = fmt.Sprint(res)
+	p.Unlock()
+
+	switch v := res.(type) {
+	case int:
+		return lua.LNumber(v)
+	case float64:
+		return lua.LNumber(v)
+	case bool:
+		return lua.LBool(v)
+	default:
+		return lua.LString(fmt.Sprint(v))
+	}
+}
+
+func (s *script) setParamGlobals() {
+	for name, typ := range map[string]string{
+		"intParam": dms.ParamInt,
+		"floatParam": dms.ParamFloat,
+		"boolParam": dms.ParamBool,
+		"strParam": dms.ParamString,
+	} {
+		typ := typ
+		s.l.Register(name, func(L *lua.LState) int {
+			L.Push(s.params.value(L, typ))
+			return 1
+		})
+	}
+}
+
+func (s *script) releaseParamGlobals() {
+	s.l.SetGlobal("intParam", nil)
+	s.l.SetGlobal("floatParam", nil)
+	s.l.SetGlobal("boolParam", nil)
+	s.l.SetGlobal("strParam", nil)
+}
+
+func (s *script) Params() []dms.Param {
+	return s.params.schema
+}
+
+func (s *script) UsedParams() map[string]string {
+	s.params.Lock()
+	defer s.params.Unlock()
+
+	res := map[string]string{}
+	for k, v := range s.params.used {
+		res[k] = v
+	}
+	return res
+}
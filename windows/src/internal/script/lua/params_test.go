@@ -0,0 +1,65 @@
+package lua
+
+import (
+	"testing"
+
+	dms "dronmotors/dmetrics/internal/script"
+)
+
+func TestScriptParams(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want []dms.Param
+	}{
+		{
+			"declared",
+			`return {
+				Test = function() intParam('delay', 250); intParam('other') end,
+				Params = { { name = 'delay', type = 'int', default = 250, min = 10 } },
+			}`,
+			[]dms.Param{ { Name: "delay", Type: dms.ParamInt, Default: "250" } },
+		},
+		{
+			"read by the getters",
+			`return {
+				OnConnect = function() sample(intParam('sampleRate', 10)) end,
+				Test = function()
+					floatParam("gain")
+					boolParam 'dry'
+					strParam('label', 'x')
+					intParam('sampleRate')
+				end,
+			}`,
+			[]dms.Param{
+				{ Name: "sampleRate", Type: dms.ParamInt },
+				{ Name: "gain", Type: dms.ParamFloat },
+				{ Name: "dry", Type: dms.ParamBool },
+				{ Name: "label", Type: dms.ParamString },
+			},
+		},
+		{
+			"none",
+			`return { Test = function() end }`,
+			nil,
+		},
+	}
+
+	for _, tt := range tests {
+		s, err := NewScript(tt.text, Options{ Name: tt.name })
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		got := s.Params()
+		if len(got) != len(tt.want) {
+			t.Errorf("%s: got %+v, want %+v", tt.name, got, tt.want)
+		}
+		for i := 0; i < len(got) && i < len(tt.want); i++ {
+			if got[i].Name != tt.want[i].Name || got[i].Type != tt.want[i].Type || got[i].Default != tt.want[i].Default {
+				t.Errorf("%s: %d: got %+v, want %+v", tt.name, i, got[i], tt.want[i])
+			}
+		}
+		s.Release()
+	}
+}
@@ -0,0 +1,222 @@
+package lua
+
+import (
+	"fmt"
+	"time"
+	"context"
+	"strings"
+	"testing"
+
+	dms "dronmotors/dmetrics/internal/script"
+)
+
+// bench records device calls with their virtual time
+type bench struct {
+	clock *dms.VirtualClock
+	calls []string
+}
+
+func (*bench) Methods() []string {
+	return []string{ "throttle", "brake", "chiller" }
+}
+
+func (*bench) Signatures() []dms.Signature {
+	return nil
+}
+
+func (b *bench) Control(method string, args ...dms.Value) (interface{}, error) {
+	var s []string
+	for _, a := range args {
+		s = append(s, a.String())
+	}
+	b.calls = append(b.calls, fmt.Sprintf("%s(%s)@%d", method, strings.Join(s, ","), b.clock.Now().Sub(epoch).Milliseconds()))
+	return nil, nil
+}
+
+type standRecord struct {
+	N int
+	MotorRPM float64
+	Brake float64
+	Temp1, Temp2 float64
+	Tag string
+}
+
+// runStand runs Test on the bench, a record is fed every 10ms from 5ms on;
+// failed checks fail the test
+func runStand(t *testing.T, text string, n int) (*bench, []*standRecord, error) {
+	clock := dms.NewVirtualClock(epoch)
+	b := &bench{ clock: clock }
+
+	s, err := NewScript(text, Options{ Name: t.Name(), Clock: clock })
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(s.Release)
+
+	if r, err := s.Bind(b); err != nil {
+		t.Fatal(err)
+	} else {
+		defer r.Release()
+	}
+
+	var recs []*standRecord
+	for i := 0; i < n; i++ {
+		r := &standRecord{ N: i, MotorRPM: 8000, Temp1: 60, Temp2: 55 }
+		recs = append(recs, r)
+		clock.Schedule(epoch.Add(time.Duration(i * 10 + 5) * time.Millisecond), func() {
+			s.Feed(context.Background(), r)
+		})
+	}
+
+	err = s.Execute(context.Background(), "Test")
+	passed(t, s)
+	return b, recs, err
+}
+
+// tags lists the tag of every record, runs of equal tags collapsed to
+// "tag×count"
+func tags(recs []*standRecord) string {
+	var res []string
+	for i := 0; i < len(recs); {
+		j := i
+		for j < len(recs) && recs[j].Tag == recs[i].Tag {
+			j++
+		}
+		res = append(res, fmt.Sprintf("%s×%d", recs[i].Tag, j - i))
+		i = j
+	}
+	return strings.Join(res, " ")
+}
+
+func TestStandRamp(t *testing.T) {
+	tests := []struct {
+		call string
+		want string
+	}{
+		{ "stand.ramp(1000, 1200, 100, 50)", "throttle(1000)@0 throttle(1100)@50 throttle(1200)@100 throttle(1200)@150" },
+		{ "stand.ramp(1200, 1000, 100, 50)", "throttle(1200)@0 throttle(1100)@50 throttle(1000)@100 throttle(1000)@150" },
+		{ "stand.ramp(1000, 1100, -60, 10)", "throttle(1000)@0 throttle(1060)@10 throttle(1100)@20" },
+		{ "stand.ramp(1000, 1000, 10, 10)", "throttle(1000)@0 throttle(1000)@10" },
+	}
+
+	for _, tt := range tests {
+		b, _, err := runStand(t, `local stand = require 'stand'; return { Test = function() ` + tt.call + ` end }`, 0)
+		if err != nil {
+			t.Errorf("%s: %v", tt.call, err)
+		} else if got := strings.Join(b.calls, " "); got != tt.want {
+			t.Errorf("%s: got %s, want %s", tt.call, got, tt.want)
+		}
+	}
+}
+
+func TestStandRampZeroStep(t *testing.T) {
+	b, _, err := runStand(t, `local stand = require 'stand'; return { Test = function() stand.ramp(1000, 1000, 0) end }`, 0)
+	if err == nil || !strings.Contains(err.Error(), "ramp: step must not be 0") {
+		t.Errorf("got %v", err)
+	}
+	if len(b.calls) > 0 {
+		t.Errorf("throttle applied: %v", b.calls)
+	}
+}
+
+func TestStandHold(t *testing.T) {
+	const text = `
+		local stand = require 'stand'
+		return {
+			Test = function()
+				stand.hold(30)
+				stand.hold(50, 'hold')
+				stand.hold(20)
+			end,
+		}`
+
+	_, recs, err := runStand(t, text, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := tags(recs), "×3 hold×5 ×2"; got != want {
+		t.Errorf("tags %q, want %q", got, want)
+	}
+}
+
+func TestStandStepSequence(t *testing.T) {
+	tests := []struct {
+		seq string
+		calls string
+		tags string
+	}{
+		{
+			"{ 1100, { throttle = 1300, hold = 20, tag = 'max' }, hold = 40, tag = 'step' }",
+			"throttle(1100)@0 throttle(1300)@40",
+			"step×4 max×2",
+		},
+		{
+			"{ 1100, 1200, hold = 30, step = 50, delay = 10 }",
+			"throttle(1100)@0 throttle(1100)@30 throttle(1150)@40 throttle(1200)@50 throttle(1200)@60",
+			"×6",
+		},
+	}
+
+	for _, tt := range tests {
+		b, recs, err := runStand(t, `local stand = require 'stand'; return { Test = function() stand.stepSequence` + tt.seq + ` end }`, 9)
+		if err != nil {
+			t.Errorf("%s: %v", tt.seq, err)
+			continue
+		}
+		if got := strings.Join(b.calls, " "); got != tt.calls {
+			t.Errorf("%s: calls %s, want %s", tt.seq, got, tt.calls)
+		}
+		if got := tags(recs[:6]); got != tt.tags {
+			t.Errorf("%s: tags %q, want %q", tt.seq, got, tt.tags)
+		}
+	}
+}
+
+func TestStandSetTag(t *testing.T) {
+	const text = `
+		local stand = require 'stand'
+		return {
+			Test = function()
+				check(stand.tag() == '', 'no tag')
+				stand.setTag('a')
+				check(stand.tag() == 'a', 'tag a')
+				sleep(20)
+				stand.phase('b', sleep, 20)
+				check(stand.tag() == 'a', 'restored after phase')
+				stand.setTag(nil)
+				check(stand.tag() == '', 'cleared')
+				sleep(20)
+			end,
+		}`
+
+	_, recs, err := runStand(t, text, 6)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := tags(recs), "a×2 b×2 ×2"; got != want {
+		t.Errorf("tags %q, want %q", got, want)
+	}
+}
+
+func TestStandTimeouts(t *testing.T) {
+	// the brake never moves and the motor stays hot: both give up on
+	// their default timeouts instead of waiting forever
+	const text = `
+		local stand = require 'stand'
+		return {
+			Test = function()
+				check(not stand.brakeTo(100), 'brake position not reached')
+				check(not stand.cooldown(40, { power = 50 }), 'not cooled down')
+			end,
+		}`
+
+	b, _, err := runStand(t, text, 70000)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := "brake(1,100)@0 brake(1,0)@10000 chiller(1,50)@10000 chiller(0,0)@610000"
+	if got := strings.Join(b.calls, " "); got != want {
+		t.Errorf("calls %s, want %s", got, want)
+	}
+}
@@ -0,0 +1,63 @@
+package lua
+
+import (
+	"fmt"
+	"path"
+	"strings"
+	"embed"
+
+	"github.com/yuin/gopher-lua"
+)
+
+//go:embed stdlib/*.lua
+var stdlib embed.FS
+
+// preloadStdlib makes every embedded stdlib/<name>.lua available through
+// require '<name>'. The stdlib loader goes last, so a module of the same
+// name in the script directory or a --lib one shadows the embedded one.
+// A module chunk receives the native helpers table as its first argument.
+func (s *script) preloadStdlib(native *lua.LTable) error {
+	entries, err := stdlib.ReadDir("stdlib")
+	if err != nil {
+		return err
+	}
+
+	modules := map[string]string{}
+	for _, e := range entries {
+		if data, err := stdlib.ReadFile(path.Join("stdlib", e.Name())); err != nil {
+			return err
+		} else {
+			modules[strings.TrimSuffix(e.Name(), ".lua")] = string(data)
+		}
+	}
+
+	loaders, ok := s.l.GetField(s.l.GetGlobal("package"), "loaders").(*lua.LTable)
+	if !ok {
+		return errorf("no package.loaders")
+	}
+
+	loaders.Append(s.l.NewFunction(func(L *lua.LState) int {
+		name := L.CheckString(1)
+		src, ok := modules[name]
+		if !ok {
+			L.Push(lua.LString(fmt.Sprintf("no embedded module '%s'", name)))
+			return 1
+		}
+
+		fn, err := L.Load(strings.NewReader(src), "stdlib/" + name)
+		if err != nil {
+			L.RaiseError("%s", err.Error())
+			return 0
+		}
+
+		L.Push(L.NewFunction(func(L *lua.LState) int {
+			L.Push(fn)
+			L.Push(native)
+			L.Call(1, 1)
+			return 1
+		}))
+		return 1
+	}))
+
+	return nil
+}
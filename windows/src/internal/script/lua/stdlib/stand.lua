@@ -0,0 +1,132 @@
+--
+-- stand: test building blocks
+--
+--   local stand = require 'stand'
+--
+
+local native = ...
+
+local M = {}
+
+local function clamp(v, lo, hi)
+   return math.max(lo, math.min(hi, v))
+end
+
+-- setTag(name) - tags every telemetry record received from now on with name,
+-- nil clears the tag
+function M.setTag(name)
+   native.setTag(name or '')
+end
+
+-- tag() - the current tag
+function M.tag()
+   return native.tag()
+end
+
+-- phase(name, fn, ...) - runs fn, every telemetry record received meanwhile
+-- is tagged with name
+function M.phase(name, fn, ...)
+   local prev = native.tag()
+   native.setTag(name)
+   local res = { pcall(fn, ...) }
+   native.setTag(prev)
+   if not res[1] then
+      error(res[2], 0)
+   end
+   return unpack(res, 2)
+end
+
+-- ramp(from, to, step, delay) - moves throttle from..to, delay ms per step
+function M.ramp(from, to, step, delay)
+   if step == 0 then
+      error('ramp: step must not be 0', 2)
+   end
+   step = math.abs(step or 50)
+   if to < from then
+      step = -step
+   end
+   for i = from, to, step do
+      throttle(i)
+      sleep(delay or 200)
+   end
+   throttle(to)
+end
+
+-- hold(ms, tag) - holds current state for ms, optionally tagged
+function M.hold(ms, tag)
+   if tag then
+      M.phase(tag, sleep, ms)
+   else
+      sleep(ms)
+   end
+end
+
+-- stepSequence{ 1100, 1200, { throttle = 1500, hold = 3000, tag = 'max' },
+--               hold = 1500, tag = 'step', delay = 200 }
+-- sets every throttle step and holds it; ramps between steps if step is set
+function M.stepSequence(seq)
+   local current = nil
+   for _, s in ipairs(seq) do
+      if type(s) == 'number' then
+         s = { throttle = s }
+      end
+      local tag = s.tag or seq.tag
+      if seq.step and current then
+         M.ramp(current, s.throttle, seq.step, seq.delay)
+      else
+         throttle(s.throttle)
+      end
+      current = s.throttle
+      M.hold(s.hold or seq.hold or 1000, tag)
+   end
+end
+
+-- brakeTo(position, { minRPM = 6000, timeout = 10000 }) - moves the brake
+-- disc to position, stops the disc once motor slows down below minRPM;
+-- returns true if position is reached. The timeout is 10 s by default,
+-- 0 waits forever.
+function M.brakeTo(position, opts)
+   opts = opts or {}
+   local t = latest()
+   local from = t and t.Brake or 0
+   if position == from then
+      return true
+   end
+
+   brake(1, position - from)
+
+   local forward = position > from
+   t = waitUntil(function(t)
+      if opts.minRPM and t.MotorRPM < opts.minRPM then
+         return true
+      elseif forward then
+         return t.Brake >= position
+      else
+         return t.Brake <= position
+      end
+   end, opts.timeout or 10000)
+
+   if t and ((forward and t.Brake >= position) or (not forward and t.Brake <= position)) then
+      return true
+   end
+
+   brake(1, 0)
+   return false
+end
+
+-- cooldown(untilTempC, { power = 100, timeout = 600000, off = true }) - runs
+-- the chiller until both thermocouples are below untilTempC, 10 min at most
+-- by default (timeout 0 waits forever); returns true if cooled down
+function M.cooldown(untilTempC, opts)
+   opts = opts or {}
+   chiller(1, clamp(opts.power or 100, 1, 100))
+   local t = waitUntil(function(t)
+      return math.max(t.Temp1, t.Temp2) <= untilTempC
+   end, opts.timeout or 600000)
+   if opts.off ~= false then
+      chiller(0, 0)
+   end
+   return t ~= nil
+end
+
+return M
@@ -0,0 +1,53 @@
+--
+-- util: helpers shared by test scripts
+--
+--   local util = require 'util'
+--
+
+local M = {}
+
+-- isInTable(value, tbl) - checks whether the array tbl contains value
+function M.isInTable(value, tbl)
+   if type(tbl) ~= 'table' then
+      error('Second argument must be a table', 2)
+   end
+   for _, v in ipairs(tbl) do
+      if v == value then
+         return true
+      end
+   end
+   return false
+end
+
+-- formatTelemetry(t) - full telemetry line
+function M.formatTelemetry(t)
+   return string.format(
+      '%dµs r/min %d b/pos %d | %.02fA x %.02fV = %.02fW | %.02f°C %.02f°C | %d %d %d | %d %d %d',
+      t.Throttle, t.MotorRPM, t.Brake, t.MotorI, t.MotorU, t.MotorP, t.Temp1, t.Temp2,
+      t.Load1, t.Load2, t.Load3, t.GyroX, t.GyroY, t.GyroZ
+   )
+end
+
+-- formatSummary(t) - throttle, torque, thrust, rpm, current, voltage, power
+function M.formatSummary(t)
+   return string.format(
+      'Скорость:%d:Момент:%.02f:Тяга:%d:Об/мин:%d:Ток:%.02f:Напряжение:%.02f:КПД:%.02f',
+      t.Throttle,
+      (t.Load2 + t.Load3) / 2,
+      t.Load1,
+      t.MotorRPM,
+      t.MotorI,
+      t.MotorU,
+      t.MotorP
+   )
+end
+
+function M.printTelemetry(t)
+   print(M.formatTelemetry(t))
+end
+
+function M.printSummary(t)
+   print(M.formatSummary(t))
+end
+
+return M
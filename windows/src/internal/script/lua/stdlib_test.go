@@ -0,0 +1,87 @@
+package lua
+
+import (
+	"os"
+	"context"
+	"strings"
+	"testing"
+	"path/filepath"
+)
+
+func TestRequireOrder(t *testing.T) {
+	dir, lib := t.TempDir(), t.TempDir()
+	module := func(dir, name, text string) {
+		if err := os.MkdirAll(filepath.Dir(filepath.Join(dir, name)), 0755); err != nil {
+			t.Fatal(err)
+		} else if err := os.WriteFile(filepath.Join(dir, name), []byte(text), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	module(dir, "stand.lua", `return { origin = 'script dir' }`)
+	module(dir, "shared.lua", `return { origin = 'script dir' }`)
+	module(lib, "shared.lua", `return { origin = 'lib' }`)
+	module(lib, "util.lua", `return { origin = 'lib' }`)
+	module(lib, "helpers/init.lua", `return { origin = 'lib init' }`)
+
+	tests := []struct {
+		name string
+		path []string
+		want string
+	}{
+		{ "stand", []string{ dir, lib }, "script dir" },
+		{ "shared", []string{ dir, lib }, "script dir" },
+		{ "shared", []string{ lib }, "lib" },
+		{ "util", []string{ dir, lib }, "lib" },
+		{ "helpers", []string{ dir, lib }, "lib init" },
+		{ "stand", []string{ lib }, "embedded" },
+		{ "stand", nil, "embedded" },
+	}
+
+	for _, tt := range tests {
+		text := `
+			local m = require '` + tt.name + `'
+			return {
+				Test = function()
+					check((m.origin or 'embedded') == '` + tt.want + `', 'origin ' .. tostring(m.origin))
+				end,
+			}`
+
+		s, err := NewScript(text, Options{ Name: t.Name(), Path: tt.path })
+		if err != nil {
+			t.Errorf("%s %v: %v", tt.name, tt.path, err)
+			continue
+		}
+
+		r, err := s.Bind(provider{})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := s.Execute(context.Background(), "Test"); err != nil {
+			t.Errorf("%s %v: %v", tt.name, tt.path, err)
+		}
+
+		for _, c := range s.Result().Failed() {
+			t.Errorf("%s %v: %s", tt.name, tt.path, c.Message)
+		}
+		r.Release()
+		s.Release()
+	}
+}
+
+func TestRequireMissing(t *testing.T) {
+	dir := t.TempDir()
+
+	_, err := NewScript(`local m = require 'nosuch'; return {}`, Options{ Name: t.Name(), Path: []string{ dir } })
+	if err == nil {
+		t.Fatal("no error")
+	}
+
+	// every place looked at is listed
+	for _, s := range []string{ "module nosuch not found", filepath.Join(dir, "nosuch.lua"), "no embedded module 'nosuch'" } {
+		if !strings.Contains(err.Error(), s) {
+			t.Errorf("%q not in %v", s, err)
+		}
+	}
+}
@@ -1,6 +1,10 @@
 package lua
 
 import (
+	"sort"
+	"reflect"
+
+	"layeh.com/gopher-luar"
 	"github.com/yuin/gopher-lua"
 
 	dms "dronmotors/dmetrics/internal/script"
@@ -28,3 +32,48 @@ func NewValue(v interface{}) dms.Value {
 		panic(errorf("value type is not supported"))
 	}
 }
+
+// toLValue converts control replies to plain lua values: maps and slices
+// become tables, everything else not covered is wrapped by luar.
+func toLValue(L *lua.LState, v interface{}) lua.LValue {
+	if v == nil {
+		return lua.LNil
+	}
+
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Bool:
+		return lua.LBool(rv.Bool())
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return lua.LNumber(rv.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return lua.LNumber(rv.Uint())
+	case reflect.Float32, reflect.Float64:
+		return lua.LNumber(rv.Float())
+	case reflect.String:
+		return lua.LString(rv.String())
+	case reflect.Slice, reflect.Array:
+		t := L.NewTable()
+		for i := 0; i < rv.Len(); i++ {
+			t.Append(toLValue(L, rv.Index(i).Interface()))
+		}
+		return t
+	case reflect.Map:
+		if rv.Type().Key().Kind() != reflect.String {
+			break
+		}
+
+		keys := rv.MapKeys()
+		sort.Slice(keys, func(i, j int) bool {
+			return keys[i].String() < keys[j].String()
+		})
+
+		t := L.NewTable()
+		for _, k := range keys {
+			t.RawSetString(k.String(), toLValue(L, rv.MapIndex(k).Interface()))
+		}
+		return t
+	}
+
+	return luar.New(L, v)
+}
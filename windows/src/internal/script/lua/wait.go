@@ -0,0 +1,246 @@
+package lua
+
+import (
+	"time"
+	"math"
+	"reflect"
+	"sync/atomic"
+
+	"layeh.com/gopher-luar"
+	"github.com/yuin/gopher-lua"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+// feed carries telemetry from the device reader to the script goroutine.
+// Feed() only queues a record, it is delivered (OnTelemetry, latest, wait
+// primitives) by the script goroutine itself at the yield points of Test:
+// sleep, waits, latest() and device calls. So the Lua state is never used
+// by two goroutines at once and a slow script never stalls the reader.
+type feed struct {
+	queue chan interface{}
+	dropped atomic.Uint64
+	last interface{} // the latest delivered record, script goroutine only
+	ts atomic.Value // its device ts, string: stamps log lines and marks
+	delivering bool // inside OnTelemetry, script goroutine only
+}
+
+const feedQueueSize = 4096
+
+// deliver runs OnTelemetry for a queued record on the calling thread, an
+// error in the callback is raised in the caller (Test)
+func (s *script) deliver(L *lua.LState, t interface{}) {
+	s.feed.last = t
+	s.feed.ts.Store(recordTs(t))
+
+	if s.fns.OnTelemetry == nil {
+		return
+	}
+
+	s.feed.delivering = true
+	defer func() { s.feed.delivering = false }()
+
+	// the script handles a copy, assigning its Tag works as setTag: the
+	// records from the next one on are tagged
+	f := recordTag(t)
+	var tag string
+	if f != nil {
+		tag = f.String()
+	}
+
+	L.CallByParam(lua.P{ Fn: s.fns.OnTelemetry, NRet: 0, Protect: false }, luar.New(L, t))
+
+	if f != nil && f.String() != tag {
+		s.tag.Store(f.String())
+	}
+}
+
+// dispatch delivers everything queued so far
+func (s *script) dispatch(L *lua.LState) {
+	for !s.feed.delivering {
+		select {
+		case t := <-s.feed.queue:
+			s.deliver(L, t)
+		default:
+			return
+		}
+	}
+}
+
+// next waits for the next record and delivers it, nil on timeout or
+// cancellation. Inside OnTelemetry (sleep) it only waits: records are
+// delivered one at a time.
+func (s *script) next(L *lua.LState, deadline time.Time) interface{} {
+	if s.feed.delivering {
+		if !deadline.IsZero() {
+			s.clock.Sleep(L.Context(), deadline.Sub(s.clock.Now()))
+		}
+		return nil
+	}
+
+	if t, ok := s.clock.Wait(L.Context(), s.feed.queue, deadline); ok {
+		s.deliver(L, t)
+		return t
+	}
+
+	return nil
+}
+
+// outsideTelemetry raises an error when a telemetry primitive is called in
+// OnTelemetry: no other record can arrive until the callback returns
+func (s *script) outsideTelemetry(L *lua.LState, name string) {
+	if s.feed.delivering {
+		L.RaiseError("%s: cannot be called inside OnTelemetry", name)
+	}
+}
+
+func (s *script) deadline(ms int) time.Time {
+	if ms <= 0 {
+		return time.Time{} // wait forever
+	}
+	return s.clock.Now().Add(time.Duration(ms) * time.Millisecond)
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+func fieldValue(t interface{}, name string) (float64, bool) {
+	v := reflect.Indirect(reflect.ValueOf(t))
+	if v.Kind() != reflect.Struct {
+		return 0, false
+	}
+
+	f := v.FieldByName(name)
+	switch f.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(f.Int()), true
+	case reflect.Float32, reflect.Float64:
+		return f.Float(), true
+	default:
+		return 0, false
+	}
+}
+
+// recordTs is the device time stamp of a telemetry record, as written to
+// telemetry.csv
+func recordTs(t interface{}) string {
+	if r, ok := t.(interface{ AsKeys() []string; AsValues() []string }); ok {
+		for i, k := range r.AsKeys() {
+			if v := r.AsValues(); k == "ts" && i < len(v) {
+				return v[i]
+			}
+		}
+	}
+	return ""
+}
+
+func (s *script) timeStamp(t interface{}) time.Time {
+	if v, ok := t.(interface{ TimeStamp() time.Time }); ok {
+		return v.TimeStamp()
+	}
+	return s.clock.Now()
+}
+
+func (s *script) setWaitGlobals() {
+	s.l.Register("latest", func(L *lua.LState) int {
+		s.outsideTelemetry(L, "latest")
+		s.dispatch(L)
+		if t := s.feed.last; t != nil {
+			L.Push(luar.New(L, t))
+		} else {
+			L.Push(lua.LNil)
+		}
+		return 1
+	})
+
+	// waitUntil(fn, timeoutMs) - returns the first record fn(t) is true for,
+	// nil on timeout
+	s.l.Register("waitUntil", func(L *lua.LState) int {
+		s.outsideTelemetry(L, "waitUntil")
+		fn := L.CheckFunction(1)
+		deadline := s.deadline(L.OptInt(2, 0))
+
+		s.dispatch(L)
+
+		for {
+			t := s.next(L, deadline)
+			if t == nil {
+				L.Push(lua.LNil)
+				return 1
+			}
+
+			lt := luar.New(L, t)
+			L.Push(fn)
+			L.Push(lt)
+			L.Call(1, 1)
+
+			ok := L.ToBool(-1)
+			L.Pop(1)
+
+			if ok {
+				L.Push(lt)
+				return 1
+			}
+		}
+	})
+
+	// waitStable(field, tolerance, windowMs, timeoutMs) - waits until the
+	// field stays within ±tolerance of the window mean for windowMs, returns
+	// the mean or nil on timeout
+	s.l.Register("waitStable", func(L *lua.LState) int {
+		s.outsideTelemetry(L, "waitStable")
+		field := L.CheckString(1)
+		tolerance := float64(L.CheckNumber(2))
+		window := time.Duration(L.CheckInt(3)) * time.Millisecond
+		deadline := s.deadline(L.OptInt(4, 0))
+
+		type sample struct {
+			ts time.Time
+			v float64
+		}
+
+		var samples []sample
+
+		s.dispatch(L)
+
+		for {
+			t := s.next(L, deadline)
+			if t == nil {
+				L.Push(lua.LNil)
+				return 1
+			}
+
+			v, ok := fieldValue(t, field)
+			if !ok {
+				L.RaiseError("waitStable: no numeric telemetry field '%s'", field)
+				return 0
+			}
+
+			ts := s.timeStamp(t)
+			samples = append(samples, sample{ ts, v })
+			for len(samples) > 1 && ts.Sub(samples[1].ts) >= window {
+				samples = samples[1:]
+			}
+
+			if ts.Sub(samples[0].ts) < window {
+				continue
+			}
+
+			sum, lo, hi := 0.0, math.Inf(1), math.Inf(-1)
+			for _, s := range samples {
+				sum, lo, hi = sum + s.v, math.Min(lo, s.v), math.Max(hi, s.v)
+			}
+
+			mean := sum / float64(len(samples))
+			if hi - mean <= tolerance && mean - lo <= tolerance {
+				L.Push(lua.LNumber(mean))
+				return 1
+			}
+		}
+	})
+}
+
+func (s *script) releaseWaitGlobals() {
+	s.l.SetGlobal("latest", nil)
+	s.l.SetGlobal("waitUntil", nil)
+	s.l.SetGlobal("waitStable", nil)
+}
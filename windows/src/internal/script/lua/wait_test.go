@@ -0,0 +1,139 @@
+package lua
+
+import (
+	"time"
+	"context"
+	"strings"
+	"testing"
+
+	dms "dronmotors/dmetrics/internal/script"
+)
+
+var epoch = time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// simulate runs Test with records fed every 10ms of virtual time, rpm(i)
+// gives the RPM of the i-th one
+func simulate(t *testing.T, text string, n int, rpm func(int) float64) (dms.Script, error) {
+	clock := dms.NewVirtualClock(epoch)
+
+	s, err := NewScript(text, Options{ Name: t.Name(), Clock: clock })
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(s.Release)
+
+	if r, err := s.Bind(provider{}); err != nil {
+		t.Fatal(err)
+	} else {
+		defer r.Release()
+	}
+
+	for i := 0; i < n; i++ {
+		r := &record{ N: i, RPM: rpm(i) }
+		clock.Schedule(epoch.Add(time.Duration(i) * 10 * time.Millisecond), func() {
+			s.Feed(context.Background(), r)
+		})
+	}
+
+	return s, s.Execute(context.Background(), "Test")
+}
+
+func passed(t *testing.T, s dms.Script) {
+	t.Helper()
+	for _, c := range s.Result().Failed() {
+		t.Errorf("%s: %s", c.Name, c.Message)
+	}
+}
+
+func TestLatest(t *testing.T) {
+	const text = `
+		return {
+			Test = function()
+				check(latest() == nil, 'nothing yet')
+				sleep(25)
+				local t = latest()
+				check(t ~= nil and t.N == 2, 'third record')
+				sleep(1000)
+				check(latest().N == 9, 'last record')
+			end,
+		}`
+
+	s, err := simulate(t, text, 10, func(int) float64 { return 0 })
+	if err != nil {
+		t.Fatal(err)
+	}
+	passed(t, s)
+}
+
+func TestWaitUntil(t *testing.T) {
+	const text = `
+		return {
+			Test = function()
+				local t = waitUntil(function(t) return t.RPM >= 50 end, 1000)
+				check(t ~= nil and t.N == 5, 'found')
+				check(latest().N == 5, 'stopped at the record')
+
+				t = waitUntil(function(t) return t.RPM < 0 end, 200)
+				check(t == nil, 'timeout')
+				check(latest().N >= 24 and latest().N <= 25, 'stopped at the deadline')
+
+				t = waitUntil(function(t) return t.RPM < 0 end)
+				check(t == nil, 'no more telemetry')
+			end,
+		}`
+
+	s, err := simulate(t, text, 100, func(i int) float64 { return float64(i * 10) })
+	if err != nil {
+		t.Fatal(err)
+	}
+	passed(t, s)
+}
+
+func TestWaitStable(t *testing.T) {
+	const text = `
+		return {
+			Test = function()
+				local v = waitStable('RPM', 2, 50, 1000)
+				check(v ~= nil and math.abs(v - 100) <= 1, 'mean ' .. tostring(v))
+				check(latest().N >= 15 and latest().N <= 16, 'settled at ' .. latest().N)
+
+				v = waitStable('RPM', 0.1, 50, 100)
+				check(v == nil, 'never that stable')
+			end,
+		}`
+
+	// ramps up to 100 in 10 records, then wobbles by ±1
+	s, err := simulate(t, text, 100, func(i int) float64 {
+		if i < 10 {
+			return float64(i * 10)
+		}
+		return float64(100 + i % 2 * 2 - 1)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	passed(t, s)
+}
+
+func TestWaitStableField(t *testing.T) {
+	const text = `return { Test = function() waitStable('Torque', 1, 50, 100) end }`
+
+	if _, err := simulate(t, text, 10, func(int) float64 { return 0 }); err == nil || !strings.Contains(err.Error(), "no numeric telemetry field 'Torque'") {
+		t.Errorf("got %v", err)
+	}
+}
+
+func TestWaitInTelemetry(t *testing.T) {
+	for _, call := range []string{ "latest()", "waitUntil(function() return true end, 10)", "waitStable('RPM', 1, 10, 10)" } {
+		text := `
+			return {
+				OnTelemetry = function(t) ` + call + ` end,
+				Test = function() sleep(100) end,
+			}`
+
+		name := call[:strings.Index(call, "(")]
+		if _, err := simulate(t, text, 3, func(int) float64 { return 0 }); err == nil || !strings.Contains(err.Error(), name + ": cannot be called inside OnTelemetry") {
+			t.Errorf("%s: got %v", name, err)
+		}
+	}
+}
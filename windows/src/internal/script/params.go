@@ -0,0 +1,116 @@
+package script
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+const (
+	ParamInt	= "int"
+	ParamFloat	= "float"
+	ParamBool	= "bool"
+	ParamString	= "string"
+)
+
+// Param is a script parameter declaration. Default is kept in its textual
+// form, the same way values come from the command line.
+type Param struct {
+	Name        string   `json:"name"`
+	Type        string   `json:"type"`
+	Default     string   `json:"default,omitempty"`
+	Min         *float64 `json:"min,omitempty"`
+	Max         *float64 `json:"max,omitempty"`
+	Description string   `json:"description,omitempty"`
+}
+
+func ParseBool(v string) (bool, error) {
+	switch strings.ToLower(strings.TrimSpace(v)) {
+	case "1", "t", "true", "y", "yes", "on":
+		return true, nil
+	case "0", "f", "false", "n", "no", "off":
+		return false, nil
+	}
+	return false, fmt.Errorf("%q is not a boolean", v)
+}
+
+// Parse converts a textual value to int, float64, bool or string according
+// to the parameter type and checks the range.
+func (p Param) Parse(v string) (interface{}, error) {
+	var res interface{}
+	var num float64
+
+	switch p.Type {
+	case ParamInt:
+		n, err := strconv.Atoi(strings.TrimSpace(v))
+		if err != nil {
+			return nil, errorf("param '%s': %q is not an integer", p.Name, v)
+		}
+		res, num = n, float64(n)
+	case ParamFloat:
+		n, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+		if err != nil {
+			return nil, errorf("param '%s': %q is not a number", p.Name, v)
+		}
+		res, num = n, n
+	case ParamBool:
+		b, err := ParseBool(v)
+		if err != nil {
+			return nil, errorf("param '%s': %v", p.Name, err)
+		}
+		return b, nil
+	case ParamString, "":
+		return v, nil
+	default:
+		return nil, errorf("param '%s': type '%s' is not supported", p.Name, p.Type)
+	}
+
+	if p.Min != nil && num < *p.Min {
+		return nil, errorf("param '%s': %s is less than %g", p.Name, v, *p.Min)
+	} else if p.Max != nil && num > *p.Max {
+		return nil, errorf("param '%s': %s is greater than %g", p.Name, v, *p.Max)
+	}
+
+	return res, nil
+}
+
+func (p Param) Range() string {
+	switch {
+	case p.Min != nil && p.Max != nil:
+		return fmt.Sprintf("%g..%g", *p.Min, *p.Max)
+	case p.Min != nil:
+		return fmt.Sprintf(">= %g", *p.Min)
+	case p.Max != nil:
+		return fmt.Sprintf("<= %g", *p.Max)
+	}
+	return ""
+}
+
+// ValidateParams checks command line arguments against the script
+// parameters: unknown names and invalid values are rejected, any argument
+// is unknown to a script without parameters.
+func ValidateParams(schema []Param, args map[string]string) error {
+	declared := map[string]Param{}
+	for _, p := range schema {
+		declared[p.Name] = p
+	}
+
+	var names []string
+	for name := range args {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if p, ok := declared[name]; !ok {
+			return errorf("unknown param '%s'", name)
+		} else if _, err := p.Parse(args[name]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,105 @@
+package script
+
+import (
+	"strings"
+	"testing"
+)
+
+func bound(v float64) *float64 {
+	return &v
+}
+
+func TestParamParse(t *testing.T) {
+	count := Param{ Name: "count", Type: ParamInt, Min: bound(1), Max: bound(10) }
+	gain := Param{ Name: "gain", Type: ParamFloat, Min: bound(0) }
+	dry := Param{ Name: "dry", Type: ParamBool }
+	name := Param{ Name: "name" }
+
+	tests := []struct {
+		p Param
+		in string
+		want interface{}
+		err string
+	}{
+		{ count, "5", 5, "" },
+		{ count, " 10 ", 10, "" },
+		{ count, "1", 1, "" },
+		{ count, "0", nil, "less than 1" },
+		{ count, "11", nil, "greater than 10" },
+		{ count, "2.5", nil, "not an integer" },
+		{ gain, "0.25", 0.25, "" },
+		{ gain, "1e3", 1000.0, "" },
+		{ gain, "-0.1", nil, "less than 0" },
+		{ gain, "x", nil, "not a number" },
+		{ dry, "yes", true, "" },
+		{ dry, "Off", false, "" },
+		{ dry, "maybe", nil, "not a boolean" },
+		{ name, "hover 1", "hover 1", "" },
+		{ Param{ Name: "at", Type: "time" }, "1", nil, "not supported" },
+	}
+
+	for _, tt := range tests {
+		got, err := tt.p.Parse(tt.in)
+		if len(tt.err) > 0 {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("%s=%q: got error %v, want %q", tt.p.Name, tt.in, err, tt.err)
+			}
+		} else if err != nil {
+			t.Errorf("%s=%q: %v", tt.p.Name, tt.in, err)
+		} else if got != tt.want {
+			t.Errorf("%s=%q: got %#v, want %#v", tt.p.Name, tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestParamRange(t *testing.T) {
+	tests := []struct {
+		p Param
+		want string
+	}{
+		{ Param{ Min: bound(1), Max: bound(10) }, "1..10" },
+		{ Param{ Min: bound(0.5) }, ">= 0.5" },
+		{ Param{ Max: bound(-1) }, "<= -1" },
+		{ Param{}, "" },
+	}
+
+	for _, tt := range tests {
+		if got := tt.p.Range(); got != tt.want {
+			t.Errorf("got %q, want %q", got, tt.want)
+		}
+	}
+}
+
+func TestValidateParams(t *testing.T) {
+	schema := []Param{
+		{ Name: "count", Type: ParamInt, Min: bound(1) },
+		{ Name: "dry", Type: ParamBool },
+	}
+
+	tests := []struct {
+		name string
+		schema []Param
+		args map[string]string
+		err string
+	}{
+		{ "ok", schema, map[string]string{ "count": "3", "dry": "1" }, "" },
+		{ "defaults", schema, nil, "" },
+		{ "unknown", schema, map[string]string{ "count": "3", "cuont": "3" }, "unknown param 'cuont'" },
+		{ "invalid", schema, map[string]string{ "count": "0" }, "param 'count'" },
+		{ "no params", nil, map[string]string{ "anything": "x" }, "unknown param 'anything'" },
+		{ "no params, no args", nil, nil, "" },
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateParams(tt.schema, tt.args)
+			if len(tt.err) == 0 {
+				if err != nil {
+					t.Errorf("unexpected error: %v", err)
+				}
+			} else if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("got error %v, want %q", err, tt.err)
+			}
+		})
+	}
+}
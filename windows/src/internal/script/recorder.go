@@ -0,0 +1,105 @@
+package script
+
+import (
+	"io"
+	"fmt"
+	"sync"
+	"time"
+	"strings"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+type Call struct {
+	At     time.Duration
+	Method string
+	Args   []string
+	Err    error
+}
+
+func (c Call) String() string {
+	s := fmt.Sprintf("%s(%s)", c.Method, strings.Join(c.Args, ", "))
+	if c.Err != nil {
+		s += " -> " + c.Err.Error()
+	}
+	return s
+}
+
+// Recorder is a fake Scriptable which validates and records every control
+// call with the clock time it was made at.
+type Recorder struct {
+	sync.Mutex
+
+	Clock Clock
+	Start time.Time
+	Sigs  []Signature
+	Calls []Call
+}
+
+func NewRecorder(clock Clock, sigs []Signature) *Recorder {
+	return &Recorder{
+		Clock: clock,
+		Start: clock.Now(),
+		Sigs: sigs,
+	}
+}
+
+func (r *Recorder) Methods() []string {
+	var res []string
+	for _, s := range r.Sigs {
+		res = append(res, s.Name)
+	}
+	return res
+}
+
+func (r *Recorder) Signatures() []Signature {
+	return r.Sigs
+}
+
+func (r *Recorder) Control(cmd string, args ...Value) (interface{}, error) {
+	call := Call{
+		At: r.Clock.Now().Sub(r.Start),
+		Method: cmd,
+	}
+
+	for _, a := range args {
+		call.Args = append(call.Args, a.String())
+	}
+
+	call.Err = errorf("no such control command: %s", cmd)
+	for _, s := range r.Sigs {
+		if s.Name == cmd {
+			call.Err = s.Validate(args)
+		}
+	}
+
+	r.Lock()
+	r.Calls = append(r.Calls, call)
+	r.Unlock()
+
+	if call.Err != nil {
+		return nil, call.Err
+	}
+
+	switch cmd {
+	case "id":
+		return map[string]interface{}{ "text": "dry-run", "name": "dry-run" }, nil
+	case "status":
+		return map[string]interface{}{ "id": "dry-run", "status": "connected" }, nil
+	}
+
+	return nil, nil
+}
+
+func (r *Recorder) WriteTimeline(w io.Writer) error {
+	r.Lock()
+	defer r.Unlock()
+
+	for _, c := range r.Calls {
+		if _, err := fmt.Fprintf(w, "%10.3fs  %s\n", c.At.Seconds(), c); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
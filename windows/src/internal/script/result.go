@@ -0,0 +1,126 @@
+package script
+
+import (
+	"fmt"
+	"sync"
+	"time"
+	"math"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+const (
+	VerdictPass	= "pass"
+	VerdictFail	= "fail"
+	VerdictError	= "error"
+)
+
+// Check is a single named measurement or condition recorded by a script.
+// Min and Max are optional, nil means the limit is not set.
+type Check struct {
+	Name    string    `json:"name"`
+	Status  string    `json:"status"`
+	Value   *float64  `json:"value,omitempty"`
+	Min     *float64  `json:"min,omitempty"`
+	Max     *float64  `json:"max,omitempty"`
+	Message string    `json:"message,omitempty"`
+	Time    time.Time `json:"time"`
+}
+
+type Result struct {
+	sync.Mutex
+	Checks []Check
+	Clock Clock // stamps the checks, real time if nil
+}
+
+func (r *Result) add(c Check) string {
+	r.Lock()
+	defer r.Unlock()
+	if r.Clock != nil {
+		c.Time = r.Clock.Now()
+	} else {
+		c.Time = time.Now()
+	}
+	r.Checks = append(r.Checks, c)
+	return c.Status
+}
+
+// Expect records a measurement and checks it against the limits.
+func (r *Result) Expect(name string, value float64, min, max *float64) bool {
+	c := Check{
+		Name: name,
+		Status: VerdictPass,
+		Value: &value,
+		Min: min,
+		Max: max,
+	}
+
+	if math.IsNaN(value) {
+		c.Status = VerdictError
+		c.Message = "value is not a number"
+	} else if min != nil && value < *min {
+		c.Status = VerdictFail
+		c.Message = fmt.Sprintf("%g < %g", value, *min)
+	} else if max != nil && value > *max {
+		c.Status = VerdictFail
+		c.Message = fmt.Sprintf("%g > %g", value, *max)
+	}
+
+	return r.add(c) == VerdictPass
+}
+
+// Check records a condition, the message names the check.
+func (r *Result) Check(cond bool, msg string) bool {
+	c := Check{
+		Name: msg,
+		Status: VerdictPass,
+	}
+
+	if !cond {
+		c.Status = VerdictFail
+		c.Message = msg
+	}
+
+	return r.add(c) == VerdictPass
+}
+
+// Error records a check which could not be evaluated at all.
+func (r *Result) Error(name, msg string) {
+	r.add(Check{
+		Name: name,
+		Status: VerdictError,
+		Message: msg,
+	})
+}
+
+// Verdict is error if any check errored, fail if any check failed and pass
+// otherwise (including the case of no checks at all).
+func (r *Result) Verdict() string {
+	r.Lock()
+	defer r.Unlock()
+
+	verdict := VerdictPass
+	for _, c := range r.Checks {
+		switch c.Status {
+		case VerdictError:
+			return VerdictError
+		case VerdictFail:
+			verdict = VerdictFail
+		}
+	}
+
+	return verdict
+}
+
+func (r *Result) Failed() []Check {
+	r.Lock()
+	defer r.Unlock()
+
+	var res []Check
+	for _, c := range r.Checks {
+		if c.Status != VerdictPass {
+			res = append(res, c)
+		}
+	}
+	return res
+}
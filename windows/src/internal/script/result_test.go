@@ -0,0 +1,111 @@
+package script
+
+import (
+	"math"
+	"time"
+	"context"
+	"strings"
+	"testing"
+)
+
+func limit(v float64) *float64 {
+	return &v
+}
+
+func TestExpect(t *testing.T) {
+	tests := []struct {
+		name string
+		value float64
+		min, max *float64
+		status string
+		msg string
+	}{
+		{ "within", 5, limit(1), limit(10), VerdictPass, "" },
+		{ "on the limits", 10, limit(10), limit(10), VerdictPass, "" },
+		{ "no limits", -1e9, nil, nil, VerdictPass, "" },
+		{ "below", 0.5, limit(1), nil, VerdictFail, "0.5 < 1" },
+		{ "above", 11, nil, limit(10), VerdictFail, "11 > 10" },
+		{ "nan", math.NaN(), limit(1), limit(10), VerdictError, "not a number" },
+	}
+
+	for _, tt := range tests {
+		var r Result
+		if ok := r.Expect(tt.name, tt.value, tt.min, tt.max); ok != (tt.status == VerdictPass) {
+			t.Errorf("%s: returned %v", tt.name, ok)
+		}
+
+		c := r.Checks[0]
+		if c.Name != tt.name || c.Status != tt.status || !strings.Contains(c.Message, tt.msg) || (len(tt.msg) == 0) != (len(c.Message) == 0) {
+			t.Errorf("%s: got %+v, want %s %q", tt.name, c, tt.status, tt.msg)
+		}
+	}
+}
+
+func TestCheck(t *testing.T) {
+	var r Result
+	if !r.Check(true, "connected") || r.Check(false, "rpm reached") {
+		t.Fatalf("check results")
+	}
+
+	r.Error("thrust", "expected number")
+
+	want := []Check{
+		{ Name: "connected", Status: VerdictPass },
+		{ Name: "rpm reached", Status: VerdictFail, Message: "rpm reached" },
+		{ Name: "thrust", Status: VerdictError, Message: "expected number" },
+	}
+
+	for i, w := range want {
+		if c := r.Checks[i]; c.Name != w.Name || c.Status != w.Status || c.Message != w.Message {
+			t.Errorf("%d: got %+v, want %+v", i, c, w)
+		}
+	}
+
+	if failed := r.Failed(); len(failed) != 2 || failed[0].Name != "rpm reached" {
+		t.Errorf("failed %+v", failed)
+	}
+}
+
+func TestVerdict(t *testing.T) {
+	tests := []struct {
+		checks []string
+		want string
+	}{
+		{ nil, VerdictPass },
+		{ []string{ VerdictPass, VerdictPass }, VerdictPass },
+		{ []string{ VerdictPass, VerdictFail, VerdictPass }, VerdictFail },
+		{ []string{ VerdictFail, VerdictError }, VerdictError },
+		{ []string{ VerdictError, VerdictFail }, VerdictError },
+	}
+
+	for _, tt := range tests {
+		var r Result
+		for _, status := range tt.checks {
+			switch status {
+			case VerdictPass:
+				r.Check(true, "ok")
+			case VerdictFail:
+				r.Check(false, "not ok")
+			case VerdictError:
+				r.Error("broken", "no value")
+			}
+		}
+
+		if got := r.Verdict(); got != tt.want {
+			t.Errorf("%v: got %s, want %s", tt.checks, got, tt.want)
+		}
+	}
+}
+
+func TestResultClock(t *testing.T) {
+	c := NewVirtualClock(epoch)
+	r := Result{ Clock: c }
+
+	r.Check(true, "first")
+	c.Sleep(context.Background(), 1500 * time.Millisecond)
+	r.Expect("second", 1, nil, nil)
+
+	if r.Checks[0].Time != epoch || r.Checks[1].Time != epoch.Add(1500 * time.Millisecond) {
+		t.Errorf("times %v, %v", r.Checks[0].Time, r.Checks[1].Time)
+	}
+}
@@ -4,6 +4,8 @@ import (
 	"fmt"
 	"context"
 	"strconv"
+
+	"encoding/json"
 )
 
 ////////////////////////////////////////////////////////////////////////////////
@@ -20,6 +22,11 @@ var ErrStopped		= errorf("stopped")
 type Script interface {
 	Bind(Scriptable) (Releasable, error)
 	Execute(context.Context, string, ...interface{}) error
+	Feed(context.Context, interface{}) error // OnTelemetry gets a copy of the record
+	Params() []Param // declared parameters
+	UsedParams() map[string]string
+	Result() *Result
+	Dropped() uint64 // telemetry records the script did not keep up with
 	Release()
 }
 
@@ -29,6 +36,7 @@ type Releasable interface {
 
 type Scriptable interface {
 	Methods() []string
+	Signatures() []Signature
 	Control(string, ...Value) (interface{}, error)
 }
 
@@ -74,3 +82,29 @@ func NewValue(v interface{}) Value {
 		panic(errorf("value type is not supported"))
 	}
 }
+
+// JSONValues converts decoded JSON arguments of a control command, numbers
+// must be integers
+func JSONValues(args []interface{}) ([]Value, error) {
+	var res []Value
+	for _, a := range args {
+		switch v := a.(type) {
+		case json.Number:
+			n, err := strconv.Atoi(v.String())
+			if err != nil {
+				return nil, errorf("argument %s is not an integer", v)
+			}
+			res = append(res, NewValue(n))
+		case float64:
+			if v != float64(int(v)) {
+				return nil, errorf("argument %v is not an integer", v)
+			}
+			res = append(res, NewValue(int(v)))
+		case string:
+			res = append(res, NewValue(v))
+		default:
+			return nil, errorf("argument %v: integer or string expected", a)
+		}
+	}
+	return res, nil
+}
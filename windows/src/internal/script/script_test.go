@@ -0,0 +1,46 @@
+package script
+
+import (
+	"strings"
+	"testing"
+
+	"encoding/json"
+)
+
+func TestJSONValues(t *testing.T) {
+	tests := []struct {
+		args []interface{}
+		want string
+		err string
+	}{
+		{ []interface{}{ json.Number("1200") }, "1200", "" },
+		{ []interface{}{ 1.0, "on", json.Number("-5") }, "1 on -5", "" },
+		{ nil, "", "" },
+		{ []interface{}{ json.Number("1.5") }, "", "not an integer" },
+		{ []interface{}{ 2.5 }, "", "not an integer" },
+		{ []interface{}{ true }, "", "integer or string expected" },
+		{ []interface{}{ nil }, "", "integer or string expected" },
+	}
+
+	for _, tt := range tests {
+		values, err := JSONValues(tt.args)
+		if len(tt.err) > 0 {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("%v: got error %v, want %q", tt.args, err, tt.err)
+			}
+			continue
+		} else if err != nil {
+			t.Errorf("%v: %v", tt.args, err)
+			continue
+		}
+
+		var got []string
+		for _, v := range values {
+			got = append(got, v.String())
+		}
+
+		if strings.Join(got, " ") != tt.want {
+			t.Errorf("%v: got %q, want %q", tt.args, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package script
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+const (
+	ArgInt = iota
+	ArgString
+)
+
+// Arg describes a single argument of a scriptable method. The range is
+// checked for integer arguments only when Min < Max.
+type Arg struct {
+	Name string
+	Type int
+	Min  int
+	Max  int
+	Help string
+}
+
+// Signature describes a scriptable method. Check is an optional extra
+// validation for constraints spanning several arguments.
+type Signature struct {
+	Name  string
+	Args  []Arg
+	Help  string
+	Check func(args []Value) error
+}
+
+func (a Arg) String() string {
+	switch {
+	case a.Type == ArgString:
+		return a.Name + ": string"
+	case a.Min < a.Max:
+		return fmt.Sprintf("%s: int %d..%d", a.Name, a.Min, a.Max)
+	default:
+		return a.Name + ": int"
+	}
+}
+
+func (s Signature) String() string {
+	var args []string
+	for _, a := range s.Args {
+		args = append(args, a.String())
+	}
+	return fmt.Sprintf("%s(%s)", s.Name, strings.Join(args, ", "))
+}
+
+func (s Signature) Validate(args []Value) error {
+	if len(args) != len(s.Args) {
+		return errorf("%s: expected %d argument(s), got %d, usage: %s", s.Name, len(s.Args), len(args), s)
+	}
+
+	for i, a := range s.Args {
+		if a.Type != ArgInt {
+			continue
+		}
+
+		n, err := strconv.Atoi(args[i].String())
+		if err != nil {
+			return errorf("%s: argument '%s' must be an integer, got %q", s.Name, a.Name, args[i].String())
+		} else if a.Min < a.Max && (n < a.Min || n > a.Max) {
+			return errorf("%s: argument '%s' = %d is out of range %d..%d", s.Name, a.Name, n, a.Min, a.Max)
+		}
+	}
+
+	if s.Check != nil {
+		if err := s.Check(args); err != nil {
+			return errorf("%s: %v", s.Name, err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,68 @@
+package script
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestSignatureValidate(t *testing.T) {
+	throttle := Signature{
+		Name: "throttle",
+		Args: []Arg{ { Name: "pulse", Type: ArgInt, Min: 0, Max: 2000 } },
+	}
+
+	pair := Signature{
+		Name: "range",
+		Args: []Arg{ { Name: "from", Type: ArgInt }, { Name: "to", Type: ArgInt }, { Name: "unit", Type: ArgString } },
+		Check: func(args []Value) error {
+			if args[0].Int() > args[1].Int() {
+				return errors.New("from > to")
+			}
+			return nil
+		},
+	}
+
+	tests := []struct {
+		name string
+		sig Signature
+		args []Value
+		err string
+	}{
+		{ "ok", throttle, []Value{ NewValue(1500) }, "" },
+		{ "min", throttle, []Value{ NewValue(0) }, "" },
+		{ "max", throttle, []Value{ NewValue(2000) }, "" },
+		{ "integer string", throttle, []Value{ NewValue("1200") }, "" },
+		{ "above max", throttle, []Value{ NewValue(2001) }, "out of range 0..2000" },
+		{ "below min", throttle, []Value{ NewValue(-1) }, "out of range" },
+		{ "not an integer", throttle, []Value{ NewValue("fast") }, "must be an integer" },
+		{ "no args", throttle, nil, "expected 1 argument(s), got 0" },
+		{ "too many args", throttle, []Value{ NewValue(1), NewValue(2) }, "got 2" },
+		{ "no range", pair, []Value{ NewValue(-5), NewValue(100000), NewValue("ms") }, "" },
+		{ "check", pair, []Value{ NewValue(5), NewValue(1), NewValue("ms") }, "range: from > to" },
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.sig.Validate(tt.args)
+			if len(tt.err) == 0 {
+				if err != nil {
+					t.Errorf("unexpected error: %v", err)
+				}
+			} else if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("got error %v, want %q", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestSignatureString(t *testing.T) {
+	sig := Signature{
+		Name: "sample",
+		Args: []Arg{ { Name: "ms", Type: ArgInt, Min: 1, Max: 1000 }, { Name: "mode", Type: ArgString }, { Name: "n", Type: ArgInt } },
+	}
+
+	if got, want := sig.String(), "sample(ms: int 1..1000, mode: string, n: int)"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
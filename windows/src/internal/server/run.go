@@ -0,0 +1,409 @@
+package server
+
+import (
+	"os"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+	"errors"
+	"context"
+	"strings"
+	"path/filepath"
+
+	"dronmotors/dmetrics/internal/device"
+	"dronmotors/dmetrics/internal/session"
+
+	dms "dronmotors/dmetrics/internal/script"
+	"dronmotors/dmetrics/internal/script/lua"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+const (
+	RunRunning	= "running"
+	RunFinished	= "finished"
+)
+
+var (
+	errStopped = errorf("stopped")
+	errDisconnected = errorf("disconnected")
+	errBusy = errorf("a script is already running")
+	errRunning = errorf("a script is running, only a stop is accepted")
+)
+
+// Run is a script run started through the server. Its results are stored
+// in a session directory under Options.Runs, the id is the directory name.
+type Run struct {
+	Id       string            `json:"id"`
+	Script   string            `json:"script"`
+	Params   map[string]string `json:"params,omitempty"`
+	State    string            `json:"state"`
+	Started  time.Time         `json:"started"`
+	Duration float64           `json:"duration,omitempty"`
+	Outcome  string            `json:"outcome,omitempty"`
+	Verdict  string            `json:"verdict,omitempty"`
+	Error    string            `json:"error,omitempty"`
+
+	cancel context.CancelCauseFunc
+	done chan struct{}
+}
+
+func runOf(id string, sess *session.Session) Run {
+	return Run{
+		Id: id,
+		Script: sess.Script,
+		Params: sess.Params,
+		State: RunFinished,
+		Started: sess.Started,
+		Duration: sess.Duration,
+		Outcome: sess.Outcome,
+		Verdict: sess.Verdict,
+		Error: sess.Error,
+	}
+}
+
+// scriptInfo is what listing a script found out, valid while the file is
+// not modified
+type scriptInfo struct {
+	modified time.Time
+	size int64
+	params []dms.Param
+	err error
+}
+
+// Scripts lists the runnable scripts with their parameters
+func (s *Server) Scripts() (map[string][]dms.Param, error) {
+	files, err := filepath.Glob(filepath.Join(s.opts.Scripts, "*.lua"))
+	if err != nil {
+		return nil, err
+	}
+
+	s.scriptsMtx.Lock()
+	defer s.scriptsMtx.Unlock()
+
+	res := map[string][]dms.Param{}
+	for _, f := range files {
+		fi, err := os.Stat(f)
+		if err != nil {
+			return nil, err
+		}
+
+		name := filepath.Base(f)
+		info, ok := s.scripts[name]
+		if !ok || !info.modified.Equal(fi.ModTime()) || info.size != fi.Size() {
+			info = s.loadParams(f)
+			info.modified, info.size = fi.ModTime(), fi.Size()
+			s.scripts[name] = info
+
+			if info.err != nil {
+				s.logger.Printf(dms.LogWarn, "%s: %v", name, info.err)
+			}
+		}
+
+		if info.err == nil {
+			res[name] = info.params
+		}
+	}
+
+	return res, nil
+}
+
+// loadParams loads a script to get its declared parameters. Loading runs the
+// top level code of the script (it returns the table of the functions and
+// parameters), so a file is loaded again only when it changes.
+func (s *Server) loadParams(filename string) scriptInfo {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return scriptInfo{ err: err }
+	}
+
+	ls, err := lua.NewScript(string(data), lua.Options{
+		Name: filepath.Base(filename),
+		Path: append([]string{ s.opts.Scripts }, s.opts.Lib...),
+		Logger: s.logger,
+	})
+	if err != nil {
+		return scriptInfo{ err: err }
+	}
+
+	defer ls.Release()
+	return scriptInfo{ params: append([]dms.Param{}, ls.Params()...) }
+}
+
+// Runs lists finished runs found in the runs directory and the current one
+func (s *Server) Runs() ([]Run, error) {
+	dirs, err := os.ReadDir(s.opts.Runs)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	res := []Run{}
+	for _, d := range dirs {
+		if !d.IsDir() {
+			continue
+		} else if sess, err := session.Load(filepath.Join(s.opts.Runs, d.Name())); err == nil {
+			res = append(res, runOf(d.Name(), sess))
+		}
+	}
+
+	s.Lock()
+	if s.run != nil && s.run.State == RunRunning {
+		res = append(res, *s.run)
+	}
+	s.Unlock()
+
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].Started.Before(res[j].Started)
+	})
+
+	return res, nil
+}
+
+// Current returns the running (or the last started) run
+func (s *Server) Current() (Run, bool) {
+	s.Lock()
+	defer s.Unlock()
+
+	if s.run == nil {
+		return Run{}, false
+	}
+	return *s.run, true
+}
+
+// runId names the directory of a new run, runs started within the same
+// second get a suffix
+func (s *Server) runId(started time.Time, script string) (string, error) {
+	base := started.Format("20060102-150405") + "-" + strings.TrimSuffix(script, ".lua")
+
+	id := base
+	for n := 2; ; n++ {
+		if _, err := os.Stat(filepath.Join(s.opts.Runs, id)); os.IsNotExist(err) {
+			return id, nil
+		} else if err != nil {
+			return "", err
+		}
+		id = fmt.Sprintf("%s-%d", base, n)
+	}
+}
+
+// Start runs a script from the scripts directory, one at a time
+func (s *Server) Start(script string, params map[string]string) (Run, error) {
+	name := filepath.Base(script)
+	if !strings.HasSuffix(name, ".lua") {
+		return Run{}, errorf("script %q: .lua file expected", script)
+	}
+
+	filename := filepath.Join(s.opts.Scripts, name)
+	filedata, err := os.ReadFile(filename)
+	if err != nil {
+		return Run{}, err
+	}
+
+	s.Lock()
+	defer s.Unlock()
+
+	if s.run != nil && s.run.State == RunRunning {
+		return Run{}, errBusy
+	}
+
+	if params == nil {
+		params = map[string]string{}
+	}
+
+	logger := session.NewLogger(s.console)
+	events := &session.Events{ Notify: s.event }
+
+	ls, err := lua.NewScript(string(filedata), lua.Options{
+		Name: name,
+		Params: params,
+		Path: append([]string{ s.opts.Scripts }, s.opts.Lib...),
+		Logger: logger,
+		Marker: events,
+	})
+	if err != nil {
+		return Run{}, err
+	} else if err := dms.ValidateParams(ls.Params(), params); err != nil {
+		ls.Release()
+		return Run{}, err
+	}
+
+	started := time.Now()
+	id, err := s.runId(started, name)
+	if err != nil {
+		ls.Release()
+		return Run{}, err
+	}
+
+	sess, err := session.New(filepath.Join(s.opts.Runs, id))
+	if err != nil {
+		ls.Release()
+		return Run{}, err
+	}
+
+	sess.Script = name
+
+	if err := logger.Open(sess.Path("session.log")); err != nil {
+		ls.Release()
+		return Run{}, err
+	}
+
+	// not bound to the server context: a run is stopped by Close
+	ctx, cancel := context.WithCancelCause(context.Background())
+
+	run := &Run{
+		Id: id,
+		Script: name,
+		Params: params,
+		State: RunRunning,
+		Started: started,
+		cancel: cancel,
+		done: make(chan struct{}),
+	}
+
+	s.run = run
+	s.script = ls
+	go s.execute(ctx, run, ls, sess, logger, events)
+
+	s.publish("run", *run)
+	return *run, nil
+}
+
+// Stop aborts the current run and waits for it to finish
+func (s *Server) Stop() (Run, error) {
+	s.Lock()
+	if s.run == nil || s.run.State != RunRunning {
+		s.Unlock()
+		return Run{}, errorf("no script is running")
+	}
+	cancel, done := s.run.cancel, s.run.done
+	s.Unlock()
+
+	cancel(errStopped)
+	<-done
+
+	run, _ := s.Current()
+	return run, nil
+}
+
+func (s *Server) execute(ctx context.Context, run *Run, ls dms.Script, sess *session.Session, logger *session.Logger, events *session.Events) {
+	defer close(run.done)
+	defer ls.Release()
+	defer logger.Close()
+
+	var mtx sync.Mutex
+	var telemetry []device.Telemetry
+
+	s.Lock()
+	cancel := run.cancel
+	s.Unlock()
+
+	unsubscribe := s.bus.Subscribe(&device.CallbacksWrapper{
+		Telemetry: func(dev device.Device, t device.Telemetry) {
+			logger.Observe(t)
+			events.Observe(t)
+
+			// tags the record, so before it is kept
+			if err := ls.Feed(ctx, t); err != nil {
+				cancel(err)
+			}
+
+			mtx.Lock()
+			telemetry = append(telemetry, t)
+			mtx.Unlock()
+		},
+		Disconnect: func(dev device.Device) {
+			cancel(errDisconnected)
+		},
+	}, device.SubscribeOptions{ Front: true }) // the stream gets tagged records
+
+	sess.Device = s.dev.Id()
+
+	err := func() error {
+		res, err := ls.Bind(s.dev)
+		if err != nil {
+			return err
+		}
+
+		defer res.Release()
+
+		if err := ls.Execute(context.Background(), "OnConnect"); err != nil {
+			return err
+		}
+
+		defer ls.Execute(context.Background(), "OnDisconnect")
+
+		cancel(ls.Execute(ctx, "Test"))
+		return context.Cause(ctx)
+	}()
+
+	unsubscribe()
+	cancel(nil)
+
+	if err == context.Canceled {
+		err = nil // test is over
+	}
+
+	for k, v := range run.Params {
+		sess.Params[k] = v
+	}
+
+	for k, v := range ls.UsedParams() {
+		sess.Params[k] = v
+	}
+
+	var outcome string
+	switch verdict := ls.Result().Verdict(); {
+	case errors.Is(err, errStopped):
+		outcome = session.OutcomeAborted
+	case errors.Is(err, errDisconnected):
+		outcome = session.OutcomeDisconnected
+	case err != nil, verdict == dms.VerdictError:
+		outcome = session.OutcomeError
+	case verdict == dms.VerdictFail:
+		outcome = session.OutcomeFail
+	default:
+		outcome = session.OutcomePass
+	}
+
+	sess.Finish(ls.Result(), outcome, err)
+	sess.Events = events.List()
+	sess.ScriptDropped = ls.Dropped()
+
+	mtx.Lock()
+	if err := sess.SaveTelemetry(telemetry); err != nil {
+		logger.Printf(dms.LogError, "%v", err)
+	}
+
+	if len(s.opts.Influx) > 0 {
+		if err := sess.SaveInflux(s.opts.Influx, s.opts.InfluxToken, telemetry); err != nil {
+			logger.Printf(dms.LogError, "%v", err)
+		}
+	}
+	mtx.Unlock()
+
+	if err := sess.SaveEvents(); err != nil {
+		logger.Printf(dms.LogError, "%v", err)
+	}
+
+	if err := sess.Save(); err != nil {
+		logger.Printf(dms.LogError, "%v", err)
+	}
+
+	if err != nil {
+		logger.Printf(dms.LogError, "%s: test %s: %v", run.Id, outcome, err)
+	} else {
+		logger.Printf(dms.LogInfo, "%s: test %s", run.Id, outcome)
+	}
+
+	s.Lock()
+	s.script = nil
+	s.outcomes[outcome]++
+	finished := runOf(run.Id, sess)
+	finished.cancel, finished.done = run.cancel, run.done
+	*run = finished
+	current := *run
+	s.Unlock()
+
+	s.publish("run", current)
+}
@@ -0,0 +1,658 @@
+package server
+
+import (
+	"io"
+	"fmt"
+	"mime"
+	"sync"
+	"time"
+	"context"
+	"strconv"
+	"strings"
+	"net"
+	"net/url"
+	"net/http"
+	"path/filepath"
+	"sync/atomic"
+
+	"encoding/json"
+
+	"dronmotors/dmetrics/pkg/websocket"
+	"dronmotors/dmetrics/internal/device"
+	"dronmotors/dmetrics/internal/metrics"
+	"dronmotors/dmetrics/internal/session"
+
+	dms "dronmotors/dmetrics/internal/script"
+)
+
+func errorf(t string, args ...interface{}) error {
+	return fmt.Errorf("server: " + t, args...)
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+type Options struct {
+	Scripts string   // directory of the scripts that can be run
+	Runs    string   // directory to store run sessions in
+	Lib     []string // additional lua module directories
+
+	Influx      string // file or write url for the telemetry of the runs
+	InfluxToken string
+
+	Hosts []string // names the API is requested at besides localhost and the listen address
+
+	OnEvent func(session.Event) // optional, markers of the runs
+}
+
+// Server exposes the device it owns over HTTP: device info and commands,
+// script runs and a websocket stream of telemetry, run state and log.
+type Server struct {
+	sync.Mutex
+
+	opts Options
+	hosts []string // allowed in the Host header, ip addresses are always
+	ctx context.Context
+	dev device.Device
+	bus *device.Bus
+
+	console io.Writer // run logs go here and to the websocket clients
+	logger *session.Logger
+	run *Run
+	script dms.Script // of the running run
+	outcomes map[string]int // of the finished runs
+
+	metrics *metrics.Exporter
+	streamDropped atomic.Uint64 // of the closed clients
+
+	clientsMtx sync.Mutex
+	clients map[*client]struct{}
+
+	scriptsMtx sync.Mutex
+	scripts map[string]scriptInfo // parameters by file name
+}
+
+// New creates a server for a device, its telemetry comes through the bus
+func New(ctx context.Context, dev device.Device, bus *device.Bus, console io.Writer, opts Options) *Server {
+	s := &Server{
+		opts: opts,
+		hosts: append([]string{ "localhost" }, opts.Hosts...),
+		ctx: ctx,
+		dev: dev,
+		bus: bus,
+		clients: map[*client]struct{}{},
+		scripts: map[string]scriptInfo{},
+		outcomes: map[string]int{},
+		metrics: metrics.New(),
+	}
+
+	s.console = io.MultiWriter(console, logWriter{ s })
+	s.logger = session.NewLogger(s.console)
+
+	bus.Subscribe(s.metrics, device.SubscribeOptions{})
+	s.metrics.Register(s.collect)
+	return s
+}
+
+// Logger is the log of the server, run logs go there as well
+func (s *Server) Logger() *session.Logger {
+	return s.logger
+}
+
+// Metrics is the /metrics exporter, e.g. to register more collectors
+func (s *Server) Metrics() *metrics.Exporter {
+	return s.metrics
+}
+
+func (s *Server) collect(w *metrics.Writer) {
+	s.Lock()
+	var run Run
+	if s.run != nil {
+		run = *s.run
+	}
+
+	var dropped uint64
+	if s.script != nil {
+		dropped = s.script.Dropped()
+	}
+
+	outcomes := map[string]int{}
+	for k, v := range s.outcomes {
+		outcomes[k] = v
+	}
+	s.Unlock()
+
+	w.Gauge("run_running", "1 while a script is running", metrics.Bool(run.State == RunRunning))
+	if len(run.Id) > 0 {
+		w.Gauge("run_info", "the current (last) run", 1, "id", run.Id, "script", run.Script, "state", run.State, "outcome", run.Outcome)
+		w.Gauge("run_started_timestamp_seconds", "start time of the current (last) run", float64(run.Started.UnixNano()) / 1e9)
+	}
+
+	w.Header("runs_total", metrics.Counter, "finished runs by outcome")
+	for _, o := range []string{ session.OutcomePass, session.OutcomeFail, session.OutcomeError, session.OutcomeDisconnected, session.OutcomeAborted } {
+		w.Sample(float64(outcomes[o]), "outcome", o)
+	}
+
+	w.Counter("script_dropped_total", "telemetry records the running script did not keep up with", float64(dropped))
+
+	total := s.streamDropped.Load()
+	s.clientsMtx.Lock()
+	clients := len(s.clients)
+	for c := range s.clients {
+		total += c.dropped.Load()
+	}
+	s.clientsMtx.Unlock()
+
+	w.Gauge("stream_clients", "websocket clients connected", float64(clients))
+	w.Counter("stream_dropped_total", "websocket messages dropped for slow clients", float64(total))
+}
+
+// Close stops the current run
+func (s *Server) Close() {
+	s.Stop()
+}
+
+////////////////////////////////////////////////////////////////////////////////
+// websocket clients
+////////////////////////////////////////////////////////////////////////////////
+
+const clientQueueSize = 256
+
+type message struct {
+	Type string      `json:"type"` // telemetry, run, log
+	Data interface{} `json:"data"`
+}
+
+type client struct {
+	send chan []byte
+	dropped atomic.Uint64
+}
+
+// push never blocks the publisher, a slow client loses messages
+func (c *client) push(msg []byte) {
+	select {
+	case c.send <- msg:
+	default:
+		c.dropped.Add(1)
+	}
+}
+
+func (s *Server) publish(typ string, data interface{}) {
+	msg, err := json.Marshal(message{ Type: typ, Data: data })
+	if err != nil {
+		return
+	}
+
+	s.clientsMtx.Lock()
+	defer s.clientsMtx.Unlock()
+
+	for c := range s.clients {
+		c.push(msg)
+	}
+}
+
+// logWriter publishes log lines
+type logWriter struct {
+	s *Server
+}
+
+func (w logWriter) Write(p []byte) (int, error) {
+	for _, line := range strings.Split(strings.TrimRight(string(p), "\n"), "\n") {
+		w.s.publish("log", line)
+	}
+	return len(p), nil
+}
+
+// event forwards a marker of the running script
+func (s *Server) event(e session.Event) {
+	s.publish("event", e)
+
+	if s.opts.OnEvent != nil {
+		s.opts.OnEvent(e)
+	}
+}
+
+func telemetryMessage(t device.Telemetry) ([]byte, error) {
+	fields := device.Fields(t)
+	fields["time"] = t.TimeStamp()
+	return json.Marshal(message{ Type: "telemetry", Data: fields })
+}
+
+func (s *Server) serveStream(w http.ResponseWriter, r *http.Request) {
+	var rate float64
+	if v := r.URL.Query().Get("rate"); len(v) > 0 {
+		var err error
+		if rate, err = strconv.ParseFloat(v, 64); err != nil || rate < 0 {
+			writeError(w, http.StatusBadRequest, errorf("rate %q is not valid", v))
+			return
+		}
+	}
+
+	conn, err := websocket.Upgrade(w, r)
+	if err != nil {
+		return
+	}
+
+	defer conn.Close()
+
+	ctx, cancel := context.WithCancel(s.ctx)
+	defer cancel()
+
+	c := &client{ send: make(chan []byte, clientQueueSize) }
+
+	s.clientsMtx.Lock()
+	s.clients[c] = struct{}{}
+	s.clientsMtx.Unlock()
+
+	defer func() {
+		s.clientsMtx.Lock()
+		delete(s.clients, c)
+		s.streamDropped.Add(c.dropped.Load())
+		s.clientsMtx.Unlock()
+	}()
+
+	unsubscribe := s.bus.Subscribe(&device.CallbacksWrapper{
+		Telemetry: func(dev device.Device, t device.Telemetry) {
+			if msg, err := telemetryMessage(t); err == nil {
+				c.push(msg)
+			}
+		},
+	}, device.SubscribeOptions{ Rate: rate })
+
+	defer unsubscribe()
+
+	// nothing is expected from the client, reading answers pings and
+	// notices the close
+	go func() {
+		defer cancel()
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case msg := <-c.send:
+			if err := conn.WriteMessage(websocket.OpText, msg); err != nil {
+				return
+			}
+		}
+	}
+}
+
+////////////////////////////////////////////////////////////////////////////////
+// REST API
+////////////////////////////////////////////////////////////////////////////////
+
+type argInfo struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+	Min  *int   `json:"min,omitempty"`
+	Max  *int   `json:"max,omitempty"`
+}
+
+type methodInfo struct {
+	Name  string    `json:"name"`
+	Help  string    `json:"help,omitempty"`
+	Usage string    `json:"usage"`
+	Args  []argInfo `json:"args"`
+}
+
+func methods(sigs []dms.Signature) []methodInfo {
+	res := []methodInfo{}
+	for _, sig := range sigs {
+		m := methodInfo{ Name: sig.Name, Help: sig.Help, Usage: sig.String(), Args: []argInfo{} }
+		for _, a := range sig.Args {
+			arg := argInfo{ Name: a.Name, Type: "int" }
+			if a.Type == dms.ArgString {
+				arg.Type = "string"
+			} else if a.Min < a.Max {
+				min, max := a.Min, a.Max
+				arg.Min, arg.Max = &min, &max
+			}
+			m.Args = append(m.Args, arg)
+		}
+		res = append(res, m)
+	}
+	return res
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	enc.Encode(v)
+}
+
+func writeError(w http.ResponseWriter, code int, err error) {
+	writeJSON(w, code, map[string]string{ "error": err.Error() })
+}
+
+func readJSON(r *http.Request, v interface{}) error {
+	dec := json.NewDecoder(io.LimitReader(r.Body, 1 << 20))
+	dec.UseNumber()
+	if err := dec.Decode(v); err != nil {
+		return errorf("bad request body: %v", err)
+	}
+	return nil
+}
+
+// actuators are the commands that move the stand
+var actuators = map[string]bool{
+	"throttle": true,
+	"brake": true,
+	"chiller": true,
+}
+
+// isStop tells the commands that bring the stand to rest: throttle down to
+// 1000 µs (or 0) and stopping the brake disc
+func isStop(cmd string, args []dms.Value) bool {
+	switch {
+	case len(args) == 1 && cmd == "throttle":
+		n, err := strconv.Atoi(args[0].String())
+		return err == nil && n <= 1000
+	case len(args) == 2 && cmd == "brake":
+		return args[0].String() == "1" && args[1].String() == "0"
+	}
+	return false
+}
+
+// Control sends a command to the device on behalf of a client, the same
+// validation as for scripts happens in the device. While a run is in
+// progress it owns the stand: actuator commands other than a stop are
+// refused.
+func (s *Server) Control(cmd string, args ...dms.Value) (interface{}, error) {
+	s.Lock()
+	running := s.run != nil && s.run.State == RunRunning
+	s.Unlock()
+
+	if running && actuators[cmd] && !isStop(cmd, args) {
+		return nil, errRunning
+	}
+
+	res, err := s.dev.Control(cmd, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	s.logger.Printf(dms.LogInfo, "control: %s %v", cmd, args)
+	return res, nil
+}
+
+func (s *Server) serveControl(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Cmd  string        `json:"cmd"`
+		Args []interface{} `json:"args"`
+	}
+
+	if err := readJSON(r, &req); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	args, err := dms.JSONValues(req.Args)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	res, err := s.Control(req.Cmd, args...)
+	if err == errRunning {
+		writeError(w, http.StatusConflict, err)
+		return
+	} else if err != nil {
+		writeError(w, http.StatusUnprocessableEntity, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{ "result": res })
+}
+
+func (s *Server) serveStart(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Script string            `json:"script"`
+		Params map[string]string `json:"params"`
+	}
+
+	if err := readJSON(r, &req); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	run, err := s.Start(req.Script, req.Params)
+	switch {
+	case err == errBusy:
+		writeError(w, http.StatusConflict, err)
+	case err != nil:
+		writeError(w, http.StatusUnprocessableEntity, err)
+	default:
+		writeJSON(w, http.StatusCreated, run)
+	}
+}
+
+// runFiles are the files of a run directory served as they are
+var runFiles = map[string]string{
+	"session.json": "application/json",
+	"session.log": "text/plain; charset=utf-8",
+	"telemetry.csv": "text/csv",
+	"events.csv": "text/csv",
+}
+
+func (s *Server) serveRun(w http.ResponseWriter, r *http.Request, path string) {
+	id, file, _ := strings.Cut(path, "/")
+	if len(file) == 0 {
+		file = "session.json"
+	}
+
+	typ, ok := runFiles[file]
+	if !ok || id != filepath.Base(id) || id == "." || id == ".." {
+		writeError(w, http.StatusNotFound, errorf("not found"))
+		return
+	}
+
+	w.Header().Set("Content-Type", typ)
+	http.ServeFile(w, r, filepath.Join(s.opts.Runs, id, file))
+}
+
+func allow(w http.ResponseWriter, r *http.Request, method string) bool {
+	if r.Method != method && !(method == http.MethodGet && r.Method == http.MethodHead) {
+		w.Header().Set("Allow", method)
+		writeError(w, http.StatusMethodNotAllowed, errorf("method %s is not allowed", r.Method))
+		return false
+	}
+	return true
+}
+
+// knownHost tells the Host of a request is one the API is served at. A page
+// of another site can rebind its name to the stand address (DNS rebinding):
+// the browser then takes it for same-origin, but the Host is that name. An
+// ip address can not be rebound.
+func knownHost(r *http.Request, hosts []string) bool {
+	host := r.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.TrimSuffix(strings.TrimPrefix(host, "["), "]")
+
+	if net.ParseIP(host) != nil {
+		return true
+	}
+
+	for _, h := range hosts {
+		if strings.EqualFold(h, host) {
+			return true
+		}
+	}
+	return false
+}
+
+// sameOrigin tells a request a browser sends on behalf of another site: the
+// Origin, if any, must be the host the API is served at
+func sameOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if len(origin) == 0 {
+		return true // not a browser
+	}
+
+	u, err := url.Parse(origin)
+	return err == nil && strings.EqualFold(u.Host, r.Host)
+}
+
+// guard rejects requests for unknown hosts and cross-origin requests,
+// including the websocket upgrade, and POST bodies other than json: a page of
+// another site can not drive the stand with a form or a plain fetch
+func guard(hosts []string, h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !knownHost(r, hosts) {
+			writeError(w, http.StatusForbidden, errorf("host %s is not allowed", r.Host))
+			return
+		} else if !sameOrigin(r) {
+			writeError(w, http.StatusForbidden, errorf("origin %s is not allowed", r.Header.Get("Origin")))
+			return
+		}
+
+		if r.Method == http.MethodPost {
+			if typ, _, err := mime.ParseMediaType(r.Header.Get("Content-Type")); err != nil || typ != "application/json" {
+				writeError(w, http.StatusUnsupportedMediaType, errorf("content type application/json expected"))
+				return
+			}
+		}
+
+		h(w, r)
+	}
+}
+
+// Handler routes the API:
+//
+//	GET  /api/device               id, status and methods
+//	GET  /api/device/status        device status
+//	POST /api/device/control       {"cmd": "throttle", "args": [1200]}
+//	GET  /api/scripts              scripts and their parameters
+//	GET  /api/runs                 runs
+//	POST /api/runs                 {"script": "test.lua", "params": {...}}
+//	GET  /api/runs/current         the current (last) run
+//	POST /api/runs/current/stop    abort the current run
+//	GET  /api/runs/<id>[/<file>]   session.json, session.log, telemetry.csv, events.csv
+//	GET  /api/stream[?rate=Hz]     websocket: telemetry, run and log messages
+//	GET  /metrics                  prometheus metrics
+//	GET  /                         browser ui
+//
+// POST requests must be json, cross-origin requests and requests for a host
+// other than an ip address, localhost or Options.Hosts are rejected.
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	hosts := s.hosts
+
+	mux.HandleFunc("/api/device", guard(hosts, func(w http.ResponseWriter, r *http.Request) {
+		if allow(w, r, http.MethodGet) {
+			writeJSON(w, http.StatusOK, map[string]interface{}{
+				"id": s.dev.Id(),
+				"status": s.dev.Status(),
+				"methods": methods(s.dev.Signatures()),
+			})
+		}
+	}))
+
+	mux.HandleFunc("/api/device/status", guard(hosts, func(w http.ResponseWriter, r *http.Request) {
+		if !allow(w, r, http.MethodGet) {
+			return
+		} else if res, err := s.dev.Control("status"); err != nil {
+			writeError(w, http.StatusBadGateway, err)
+		} else {
+			writeJSON(w, http.StatusOK, res)
+		}
+	}))
+
+	mux.HandleFunc("/api/device/control", guard(hosts, func(w http.ResponseWriter, r *http.Request) {
+		if allow(w, r, http.MethodPost) {
+			s.serveControl(w, r)
+		}
+	}))
+
+	mux.HandleFunc("/api/scripts", guard(hosts, func(w http.ResponseWriter, r *http.Request) {
+		if !allow(w, r, http.MethodGet) {
+			return
+		} else if res, err := s.Scripts(); err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+		} else {
+			writeJSON(w, http.StatusOK, res)
+		}
+	}))
+
+	mux.HandleFunc("/api/runs", guard(hosts, func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodPost:
+			s.serveStart(w, r)
+		case http.MethodGet:
+			if res, err := s.Runs(); err != nil {
+				writeError(w, http.StatusInternalServerError, err)
+			} else {
+				writeJSON(w, http.StatusOK, res)
+			}
+		default:
+			allow(w, r, http.MethodGet)
+		}
+	}))
+
+	mux.HandleFunc("/api/runs/", guard(hosts, func(w http.ResponseWriter, r *http.Request) {
+		switch path := strings.TrimPrefix(r.URL.Path, "/api/runs/"); path {
+		case "current":
+			if !allow(w, r, http.MethodGet) {
+				return
+			} else if run, ok := s.Current(); !ok {
+				writeError(w, http.StatusNotFound, errorf("no script was started"))
+			} else {
+				writeJSON(w, http.StatusOK, run)
+			}
+		case "current/stop":
+			if !allow(w, r, http.MethodPost) {
+				return
+			} else if run, err := s.Stop(); err != nil {
+				writeError(w, http.StatusConflict, err)
+			} else {
+				writeJSON(w, http.StatusOK, run)
+			}
+		default:
+			if allow(w, r, http.MethodGet) {
+				s.serveRun(w, r, path)
+			}
+		}
+	}))
+
+	mux.HandleFunc("/api/stream", guard(hosts, s.serveStream))
+	mux.HandleFunc("/metrics", guard(hosts, s.metrics.ServeHTTP))
+	mux.HandleFunc("/", guard(hosts, uiHandler().ServeHTTP))
+
+	return mux
+}
+
+// ListenAndServe serves the API until the server context is done, the host
+// name of addr is allowed in requests
+func (s *Server) ListenAndServe(addr string) error {
+	if host, _, err := net.SplitHostPort(addr); err == nil && len(host) > 0 {
+		s.hosts = append(s.hosts, host)
+	}
+
+	srv := &http.Server{
+		Addr: addr,
+		Handler: s.Handler(),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	go func() {
+		<-s.ctx.Done()
+		ctx, cancel := context.WithTimeout(context.Background(), 5 * time.Second)
+		defer cancel()
+		srv.Shutdown(ctx)
+	}()
+
+	if err := srv.ListenAndServe(); err != http.ErrServerClosed {
+		return err
+	}
+	return nil
+}
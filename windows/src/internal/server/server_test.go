@@ -0,0 +1,93 @@
+package server
+
+import (
+	"strings"
+	"testing"
+	"net/http"
+	"net/http/httptest"
+
+	dms "dronmotors/dmetrics/internal/script"
+)
+
+func TestIsStop(t *testing.T) {
+	tests := []struct {
+		cmd string
+		args []interface{}
+		want bool
+	}{
+		{ "throttle", []interface{}{ 1000 }, true },
+		{ "throttle", []interface{}{ 0 }, true },
+		{ "throttle", []interface{}{ "1000" }, true },
+		{ "throttle", []interface{}{ 1001 }, false },
+		{ "throttle", nil, false },
+		{ "brake", []interface{}{ 1, 0 }, true },
+		{ "brake", []interface{}{ 0, 0 }, false },
+		{ "brake", []interface{}{ 1, -200 }, false },
+		{ "chiller", []interface{}{ 0, 0 }, false },
+		{ "tare", nil, false },
+	}
+
+	for _, tt := range tests {
+		var args []dms.Value
+		for _, a := range tt.args {
+			args = append(args, dms.NewValue(a))
+		}
+
+		if got := isStop(tt.cmd, args); got != tt.want {
+			t.Errorf("%s%v: got %v, want %v", tt.cmd, tt.args, got, tt.want)
+		}
+	}
+}
+
+func TestGuard(t *testing.T) {
+	h := guard([]string{ "localhost", "stand" }, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	tests := []struct {
+		method string
+		host string
+		origin string
+		typ string
+		code int
+	}{
+		{ http.MethodGet, "stand:8080", "", "", http.StatusOK },
+		{ http.MethodGet, "stand:8080", "http://stand:8080", "", http.StatusOK },
+		{ http.MethodGet, "stand:8080", "http://evil.example", "", http.StatusForbidden },
+		{ http.MethodPost, "stand:8080", "", "application/json", http.StatusOK },
+		{ http.MethodPost, "stand:8080", "", "application/json; charset=utf-8", http.StatusOK },
+		{ http.MethodPost, "stand:8080", "", "", http.StatusUnsupportedMediaType },
+		{ http.MethodPost, "stand:8080", "", "text/plain", http.StatusUnsupportedMediaType },
+		{ http.MethodPost, "stand:8080", "", "application/x-www-form-urlencoded", http.StatusUnsupportedMediaType },
+		{ http.MethodPost, "stand:8080", "http://stand:8080", "application/json", http.StatusOK },
+		{ http.MethodPost, "stand:8080", "http://stand:9090", "application/json", http.StatusForbidden },
+		{ http.MethodPost, "stand:8080", "null", "application/json", http.StatusForbidden },
+
+		// a rebound name is same-origin for the browser, but not a known host
+		{ http.MethodGet, "evil.example:8080", "", "", http.StatusForbidden },
+		{ http.MethodPost, "evil.example:8080", "http://evil.example:8080", "application/json", http.StatusForbidden },
+		{ http.MethodGet, "STAND:8080", "", "", http.StatusOK },
+		{ http.MethodGet, "localhost:8080", "http://localhost:8080", "", http.StatusOK },
+		{ http.MethodGet, "127.0.0.1:8080", "http://127.0.0.1:8080", "", http.StatusOK },
+		{ http.MethodGet, "[::1]:8080", "http://[::1]:8080", "", http.StatusOK },
+		{ http.MethodGet, "192.168.1.5", "", "", http.StatusOK },
+		{ http.MethodGet, "stand.example:8080", "", "", http.StatusForbidden },
+	}
+
+	for _, tt := range tests {
+		r := httptest.NewRequest(tt.method, "http://" + tt.host + "/api/device/control", strings.NewReader("{}"))
+		if len(tt.origin) > 0 {
+			r.Header.Set("Origin", tt.origin)
+		}
+		if len(tt.typ) > 0 {
+			r.Header.Set("Content-Type", tt.typ)
+		}
+
+		w := httptest.NewRecorder()
+		h(w, r)
+
+		if w.Code != tt.code {
+			t.Errorf("%s host %s origin %q type %q: got %d, want %d", tt.method, tt.host, tt.origin, tt.typ, w.Code, tt.code)
+		}
+	}
+}
@@ -0,0 +1,364 @@
+'use strict';
+
+// dm-cli browser ui: live charts, device controls, script runner and runs.
+// Talks to the serve api only, no external assets.
+
+const $ = (sel) => document.querySelector(sel);
+
+const charts = [
+	{ title: 'RPM', fields: ['motorRPM'] },
+	{ title: 'Throttle, µs', fields: ['throttle'] },
+	{ title: 'Current, A / power, W', fields: ['motorI', 'motorP'] },
+	{ title: 'Load cells', fields: ['load1', 'load2', 'load3'] },
+	{ title: 'Temperature, °C', fields: ['temp1', 'temp2', 'temp3'] },
+	{ title: 'Voltage, V', fields: ['motorU'] },
+];
+
+const colors = ['#2563eb', '#dc2626', '#059669', '#d97706'];
+
+const state = {
+	samples: [], // {t: ms, data}
+	running: false,
+};
+
+async function api(method, path, body) {
+	const opts = { method, headers: {} };
+	if (method === 'POST') {
+		// the server takes json only
+		opts.headers['Content-Type'] = 'application/json';
+		opts.body = JSON.stringify(body === undefined ? {} : body);
+	}
+
+	const res = await fetch(path, opts);
+	const data = await res.json().catch(() => ({}));
+	if (!res.ok) {
+		throw new Error(data.error || res.statusText);
+	}
+	return data;
+}
+
+function el(tag, attrs, ...children) {
+	const e = document.createElement(tag);
+	for (const [k, v] of Object.entries(attrs || {})) {
+		if (k === 'class') {
+			e.className = v;
+		} else if (v !== undefined && v !== null) {
+			e.setAttribute(k, v);
+		}
+	}
+	for (const c of children) {
+		e.append(c);
+	}
+	return e;
+}
+
+function setBadge(id, text, cls) {
+	const b = $(id);
+	b.textContent = text;
+	b.className = 'badge ' + (cls || '');
+}
+
+////////////////////////////////////////////////////////////////////////////////
+// charts
+////////////////////////////////////////////////////////////////////////////////
+
+function setupCharts() {
+	const list = $('#chart-list');
+	for (const c of charts) {
+		c.canvas = el('canvas');
+		c.legend = el('div', { class: 'legend' });
+		list.append(el('div', { class: 'chart' }, el('b', {}, c.title), c.legend, c.canvas));
+	}
+	requestAnimationFrame(drawCharts);
+}
+
+function drawChart(c, samples, from, to) {
+	const canvas = c.canvas;
+	const w = canvas.clientWidth, h = canvas.clientHeight;
+	if (canvas.width !== w || canvas.height !== h) {
+		canvas.width = w;
+		canvas.height = h;
+	}
+
+	const ctx = canvas.getContext('2d');
+	ctx.clearRect(0, 0, w, h);
+
+	let lo = Infinity, hi = -Infinity;
+	for (const s of samples) {
+		for (const f of c.fields) {
+			const v = s.data[f];
+			if (typeof v === 'number') {
+				lo = Math.min(lo, v);
+				hi = Math.max(hi, v);
+			}
+		}
+	}
+
+	c.legend.replaceChildren(...c.fields.map((f, i) => {
+		const last = samples.length ? samples[samples.length - 1].data[f] : undefined;
+		return el('span', { style: 'color:' + colors[i % colors.length] }, f + ' ' + (last === undefined ? '—' : last));
+	}));
+
+	if (!isFinite(lo)) {
+		return;
+	} else if (lo === hi) {
+		lo -= 1;
+		hi += 1;
+	}
+
+	const pad = 30;
+	const x = (t) => pad + (t - from) / (to - from) * (w - pad);
+	const y = (v) => h - 4 - (v - lo) / (hi - lo) * (h - 8);
+
+	ctx.fillStyle = '#6b7280';
+	ctx.font = '10px sans-serif';
+	ctx.fillText(String(+hi.toPrecision(4)), 0, 10);
+	ctx.fillText(String(+lo.toPrecision(4)), 0, h - 2);
+
+	ctx.strokeStyle = '#e5e7eb';
+	ctx.beginPath();
+	ctx.moveTo(pad, 0);
+	ctx.lineTo(pad, h);
+	ctx.stroke();
+
+	c.fields.forEach((f, i) => {
+		ctx.strokeStyle = colors[i % colors.length];
+		ctx.lineWidth = 1.5;
+		ctx.beginPath();
+		let started = false;
+		for (const s of samples) {
+			const v = s.data[f];
+			if (typeof v !== 'number') {
+				continue;
+			} else if (!started) {
+				ctx.moveTo(x(s.t), y(v));
+				started = true;
+			} else {
+				ctx.lineTo(x(s.t), y(v));
+			}
+		}
+		ctx.stroke();
+	});
+}
+
+function drawCharts() {
+	if (!$('#pause').checked) {
+		const span = Number($('#window').value) * 1000;
+		const to = Date.now(), from = to - span;
+
+		while (state.samples.length && state.samples[0].t < to - 120000) {
+			state.samples.shift();
+		}
+
+		const visible = state.samples.filter((s) => s.t >= from);
+		for (const c of charts) {
+			drawChart(c, visible, from, to);
+		}
+	}
+	requestAnimationFrame(drawCharts);
+}
+
+////////////////////////////////////////////////////////////////////////////////
+// stream
+////////////////////////////////////////////////////////////////////////////////
+
+function log(line) {
+	const pre = $('#log');
+	const atBottom = pre.scrollTop + pre.clientHeight >= pre.scrollHeight - 4;
+	pre.append(line + '\n');
+	while (pre.childNodes.length > 1000) {
+		pre.removeChild(pre.firstChild);
+	}
+	if (atBottom) {
+		pre.scrollTop = pre.scrollHeight;
+	}
+}
+
+function showRun(run) {
+	state.running = run.state === 'running';
+	if (state.running) {
+		setBadge('#run', 'running ' + run.script, 'warn');
+	} else {
+		setBadge('#run', run.script + ': ' + (run.outcome || run.state), run.outcome === 'pass' ? 'ok' : 'bad');
+	}
+}
+
+function connect() {
+	const proto = location.protocol === 'https:' ? 'wss:' : 'ws:';
+	const ws = new WebSocket(proto + '//' + location.host + '/api/stream?rate=25');
+
+	ws.onopen = () => setBadge('#link', 'online', 'ok');
+	ws.onclose = () => {
+		setBadge('#link', 'offline', 'bad');
+		setTimeout(connect, 2000);
+	};
+
+	ws.onmessage = (ev) => {
+		const msg = JSON.parse(ev.data);
+		switch (msg.type) {
+		case 'telemetry':
+			state.samples.push({ t: Date.now(), data: msg.data });
+			$('#tag').textContent = msg.data.tag ? 'tag: ' + msg.data.tag : '';
+			break;
+		case 'log':
+			log(msg.data);
+			break;
+		case 'run':
+			showRun(msg.data);
+			if (msg.data.state !== 'running') {
+				loadRuns();
+			}
+			break;
+		}
+	};
+}
+
+////////////////////////////////////////////////////////////////////////////////
+// device
+////////////////////////////////////////////////////////////////////////////////
+
+async function control(cmd, args) {
+	const out = $('#control-result');
+	try {
+		const res = await api('POST', '/api/device/control', { cmd, args });
+		out.textContent = cmd + ': ' + JSON.stringify(res.result, null, 2);
+	} catch (e) {
+		out.textContent = cmd + ': ' + e.message;
+	}
+}
+
+async function loadDevice() {
+	const dev = await api('GET', '/api/device');
+	$('#device').textContent = dev.id + ' (' + dev.status + ')';
+
+	const box = $('#controls');
+	box.replaceChildren();
+	for (const m of dev.methods) {
+		const inputs = m.args.map((a) => el('input', {
+			type: a.type === 'int' ? 'number' : 'text',
+			placeholder: a.name,
+			title: a.name,
+			min: a.min,
+			max: a.max,
+		}));
+
+		const button = el('button', { type: 'button' }, m.name);
+		button.onclick = () => control(m.name, inputs.map((i, n) => m.args[n].type === 'int' ? Number(i.value) : i.value));
+
+		box.append(el('div', { class: 'method' }, el('span', { class: 'name' }, m.usage), ...inputs, button, el('span', { class: 'help' }, m.help || '')));
+	}
+}
+
+async function emergencyStop() {
+	// cut the throttle at once, then stop the script, then cut it again in
+	// case the script managed to send another command
+	await control('throttle', [1000]);
+	if (state.running) {
+		await api('POST', '/api/runs/current/stop').catch((e) => log('stop: ' + e.message));
+		await control('throttle', [1000]);
+	}
+	log('EMERGENCY STOP');
+}
+
+////////////////////////////////////////////////////////////////////////////////
+// scripts and runs
+////////////////////////////////////////////////////////////////////////////////
+
+let scripts = {};
+
+function showParams() {
+	const box = $('#params');
+	box.replaceChildren();
+	for (const p of scripts[$('#script').value] || []) {
+		const input = el('input', {
+			name: p.name,
+			type: p.type === 'bool' ? 'checkbox' : (p.type === 'string' ? 'text' : 'number'),
+			step: p.type === 'float' ? 'any' : undefined,
+			min: p.min,
+			max: p.max,
+		});
+
+		if (p.type === 'bool') {
+			input.checked = ['1', 'true', 'yes', 'on'].includes(String(p.default).toLowerCase());
+		} else if (p.default !== undefined) {
+			input.value = p.default;
+		}
+
+		box.append(el('label', {}, el('span', {}, p.name), input, ' ', el('span', { class: 'desc' }, p.description || '')));
+	}
+}
+
+async function loadScripts() {
+	scripts = await api('GET', '/api/scripts');
+	const sel = $('#script');
+	sel.replaceChildren(...Object.keys(scripts).sort().map((name) => el('option', { value: name }, name)));
+	showParams();
+}
+
+async function startScript(ev) {
+	ev.preventDefault();
+
+	const params = {};
+	for (const input of $('#params').querySelectorAll('input')) {
+		params[input.name] = input.type === 'checkbox' ? String(input.checked) : input.value;
+	}
+
+	const out = $('#script-result');
+	try {
+		const run = await api('POST', '/api/runs', { script: $('#script').value, params });
+		out.textContent = 'started ' + run.id;
+		showRun(run);
+	} catch (e) {
+		out.textContent = e.message;
+	}
+}
+
+async function stopScript() {
+	try {
+		const run = await api('POST', '/api/runs/current/stop');
+		$('#script-result').textContent = run.id + ': ' + run.outcome;
+	} catch (e) {
+		$('#script-result').textContent = e.message;
+	}
+}
+
+async function loadRuns() {
+	const runs = await api('GET', '/api/runs');
+	const body = $('#runs tbody');
+	body.replaceChildren(...runs.reverse().map((r) => {
+		const files = el('td', {});
+		for (const f of ['session.json', 'session.log', 'telemetry.csv', 'events.csv']) {
+			files.append(el('a', { href: '/api/runs/' + encodeURIComponent(r.id) + '/' + f, target: '_blank' }, f));
+		}
+
+		return el('tr', {},
+			el('td', {}, r.id),
+			el('td', {}, r.script),
+			el('td', {}, new Date(r.started).toLocaleString()),
+			el('td', {}, r.duration ? r.duration.toFixed(1) + ' s' : ''),
+			el('td', { class: r.outcome || '' }, r.outcome || r.state),
+			el('td', { class: r.verdict || '' }, r.verdict || ''),
+			files);
+	}));
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+async function main() {
+	setupCharts();
+	connect();
+
+	$('#estop').onclick = emergencyStop;
+	$('#script').onchange = showParams;
+	$('#script-form').onsubmit = startScript;
+	$('#stop').onclick = stopScript;
+	$('#refresh-runs').onclick = loadRuns;
+
+	for (const load of [loadDevice, loadScripts, loadRuns]) {
+		load().catch((e) => log(e.message));
+	}
+
+	api('GET', '/api/runs/current').then(showRun).catch(() => {});
+}
+
+main();
@@ -0,0 +1,152 @@
+package session
+
+import (
+	"os"
+	"io"
+	"fmt"
+	"sync"
+	"time"
+
+	"encoding/csv"
+	"encoding/json"
+
+	"dronmotors/dmetrics/internal/device"
+
+	dms "dronmotors/dmetrics/internal/script"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+// Event is a marker of the run correlated to the device time stamp of the
+// record the script is handling (the latest telemetry record for markers
+// from elsewhere).
+type Event struct {
+	Time time.Time              `json:"time"`
+	Ts   string                 `json:"ts,omitempty"`
+	Name string                 `json:"name"`
+	Data map[string]interface{} `json:"data,omitempty"`
+}
+
+// Events collects markers of the run
+type Events struct {
+	sync.Mutex
+	ts string
+	list []Event
+
+	Notify func(Event) // optional, called for every marker
+}
+
+// telemetryTs returns the device time stamp of a record, "" if it has none
+func telemetryTs(t device.Telemetry) string {
+	for i, k := range t.AsKeys() {
+		if k == "ts" {
+			if v := t.AsValues(); i < len(v) {
+				return v[i]
+			}
+		}
+	}
+	return ""
+}
+
+func (e *Events) Observe(t device.Telemetry) {
+	ts := telemetryTs(t)
+
+	e.Lock()
+	e.ts = ts
+	e.Unlock()
+}
+
+func (e *Events) Mark(m dms.Mark) {
+	if m.Time.IsZero() {
+		m.Time = time.Now()
+	}
+
+	e.Lock()
+	ev := Event{
+		Time: m.Time,
+		Ts: m.Ts,
+		Name: m.Name,
+		Data: m.Data,
+	}
+	if len(ev.Ts) == 0 {
+		ev.Ts = e.ts
+	}
+	e.list = append(e.list, ev)
+	e.Unlock()
+
+	if e.Notify != nil {
+		e.Notify(ev)
+	}
+}
+
+func (e *Events) List() []Event {
+	e.Lock()
+	defer e.Unlock()
+	return append([]Event{}, e.list...)
+}
+
+// WriteEvents stores events as csv: time, ts, name, data (json object)
+func WriteEvents(filename string, events []Event) error {
+	f, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+
+	defer f.Close()
+
+	writer := csv.NewWriter(f)
+	writer.Write([]string{ "time", "ts", "name", "data" })
+
+	for _, e := range events {
+		var data []byte
+		if len(e.Data) > 0 {
+			if data, err = json.Marshal(e.Data); err != nil {
+				return err
+			}
+		}
+
+		writer.Write([]string{ e.Time.Format(time.RFC3339Nano), e.Ts, e.Name, string(data) })
+	}
+
+	writer.Flush()
+	return writer.Error()
+}
+
+func ReadEvents(filename string) ([]Event, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	defer f.Close()
+
+	reader := csv.NewReader(f)
+	if _, err := reader.Read(); err != nil {
+		return nil, fmt.Errorf("%s: %v", filename, err)
+	}
+
+	var res []Event
+	for {
+		row, err := reader.Read()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("%s: %v", filename, err)
+		} else if len(row) < 4 {
+			return nil, fmt.Errorf("%s: expected time, ts, name, data", filename)
+		}
+
+		e := Event{ Ts: row[1], Name: row[2] }
+		if e.Time, err = time.Parse(time.RFC3339Nano, row[0]); err != nil {
+			return nil, fmt.Errorf("%s: %v", filename, err)
+		} else if len(row[3]) > 0 {
+			if err := json.Unmarshal([]byte(row[3]), &e.Data); err != nil {
+				return nil, fmt.Errorf("%s: %v", filename, err)
+			}
+		}
+
+		res = append(res, e)
+	}
+
+	return res, nil
+}
@@ -0,0 +1,107 @@
+package session
+
+import (
+	"os"
+	"time"
+	"reflect"
+	"strings"
+	"testing"
+	"path/filepath"
+
+	dms "dronmotors/dmetrics/internal/script"
+)
+
+type record struct {
+	ts string
+}
+
+func (r record) Id() string { return "test" }
+func (r record) AsKeys() []string { return []string{ "ts", "rpm" } }
+func (r record) AsValues() []string { return []string{ r.ts, "5000" } }
+func (r record) TimeStamp() time.Time { return time.Time{} }
+func (r record) String() string { return r.ts }
+
+func TestEventsMark(t *testing.T) {
+	var notified []string
+	e := &Events{ Notify: func(ev Event) { notified = append(notified, ev.Name) } }
+
+	at := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	e.Mark(dms.Mark{ Time: at, Name: "before telemetry" })
+	e.Observe(record{ ts: "100" })
+	e.Observe(record{ ts: "110" })
+	e.Mark(dms.Mark{ Time: at, Name: "latest" })
+	e.Mark(dms.Mark{ Time: at, Ts: "100", Name: "handled", Data: map[string]interface{}{ "n": 1 } })
+	e.Mark(dms.Mark{ Name: "no time" })
+
+	want := []Event{
+		{ Time: at, Name: "before telemetry" },
+		{ Time: at, Ts: "110", Name: "latest" },
+		{ Time: at, Ts: "100", Name: "handled", Data: map[string]interface{}{ "n": 1 } },
+	}
+
+	list := e.List()
+	if len(list) != 4 || !reflect.DeepEqual(list[:3], want) {
+		t.Errorf("got %+v", list)
+	} else if list[3].Time.IsZero() || list[3].Ts != "110" {
+		t.Errorf("unstamped mark %+v", list[3])
+	}
+
+	if strings.Join(notified, ",") != "before telemetry,latest,handled,no time" {
+		t.Errorf("notified %v", notified)
+	}
+}
+
+func TestEventsFile(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "events.csv")
+	at := time.Date(2026, 1, 1, 12, 0, 0, 500, time.UTC)
+
+	events := []Event{
+		{ Time: at, Name: "before telemetry" },
+		{ Time: at.Add(time.Second), Ts: "1500", Name: "prop, changed", Data: map[string]interface{}{ "prop": "10x4.5", "n": 2.0 } },
+	}
+
+	if err := WriteEvents(filename, events); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := ReadEvents(filename)
+	if err != nil {
+		t.Fatal(err)
+	} else if len(got) != len(events) {
+		t.Fatalf("got %+v", got)
+	}
+
+	for i := range events {
+		if !got[i].Time.Equal(events[i].Time) || got[i].Ts != events[i].Ts || got[i].Name != events[i].Name || !reflect.DeepEqual(got[i].Data, events[i].Data) {
+			t.Errorf("%d: got %+v, want %+v", i, got[i], events[i])
+		}
+	}
+}
+
+func TestReadEventsErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		err string
+	}{
+		{ "empty", "", "EOF" },
+		{ "short row", "time,ts,name,data\n2026-01-01T00:00:00Z,1,x\n", "" },
+		{ "bad time", "time,ts,name,data\nyesterday,1,x,\n", "yesterday" },
+		{ "bad data", "time,ts,name,data\n2026-01-01T00:00:00Z,1,x,{\n", "" },
+	}
+
+	for _, tt := range tests {
+		filename := filepath.Join(t.TempDir(), "events.csv")
+		if err := os.WriteFile(filename, []byte(tt.text), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := ReadEvents(filename); err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("%s: got %v", tt.name, err)
+		}
+	}
+
+	if _, err := ReadEvents(filepath.Join(t.TempDir(), "none.csv")); err == nil {
+		t.Errorf("no error for a missing file")
+	}
+}
@@ -0,0 +1,133 @@
+package session
+
+import (
+	"os"
+	"fmt"
+	"sort"
+
+	"encoding/xml"
+
+	dms "dronmotors/dmetrics/internal/script"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+type junitProperty struct {
+	Name  string `xml:"name,attr"`
+	Value string `xml:"value,attr"`
+}
+
+type junitMessage struct {
+	Message string `xml:"message,attr"`
+	Type    string `xml:"type,attr,omitempty"`
+	Text    string `xml:",chardata"`
+}
+
+type junitCase struct {
+	Name      string        `xml:"name,attr"`
+	ClassName string        `xml:"classname,attr"`
+	Time      string        `xml:"time,attr"`
+	Failure   *junitMessage `xml:"failure,omitempty"`
+	Error     *junitMessage `xml:"error,omitempty"`
+	SystemOut string        `xml:"system-out,omitempty"`
+}
+
+type junitSuite struct {
+	XMLName    xml.Name        `xml:"testsuite"`
+	Name       string          `xml:"name,attr"`
+	Tests      int             `xml:"tests,attr"`
+	Failures   int             `xml:"failures,attr"`
+	Errors     int             `xml:"errors,attr"`
+	Time       string          `xml:"time,attr"`
+	Timestamp  string          `xml:"timestamp,attr"`
+	Hostname   string          `xml:"hostname,attr,omitempty"`
+	Properties []junitProperty `xml:"properties>property"`
+	Cases      []junitCase     `xml:"testcase"`
+}
+
+// WriteJUnit writes the session as a junit test suite: one test case per
+// check plus a "run" case carrying the run outcome.
+func (s *Session) WriteJUnit(filename string) error {
+	suite := junitSuite{
+		Name: s.Script,
+		Time: fmt.Sprintf("%.3f", s.Duration),
+		Timestamp: s.Started.Format("2006-01-02T15:04:05"),
+		Properties: []junitProperty{
+			{ Name: "device", Value: s.Device },
+			{ Name: "outcome", Value: s.Outcome },
+			{ Name: "verdict", Value: s.Verdict },
+		},
+	}
+
+	suite.Hostname, _ = os.Hostname()
+
+	var keys []string
+	for k := range s.Params {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		suite.Properties = append(suite.Properties, junitProperty{ Name: "param." + k, Value: s.Params[k] })
+	}
+
+	prev := s.Started
+	for _, c := range s.Checks {
+		tc := junitCase{
+			Name: c.Name,
+			ClassName: s.Script,
+			Time: fmt.Sprintf("%.3f", c.Time.Sub(prev).Seconds()),
+		}
+		prev = c.Time
+
+		if c.Value != nil {
+			tc.SystemOut = fmt.Sprintf("value=%g", *c.Value)
+			if c.Min != nil {
+				tc.SystemOut += fmt.Sprintf(" min=%g", *c.Min)
+			}
+			if c.Max != nil {
+				tc.SystemOut += fmt.Sprintf(" max=%g", *c.Max)
+			}
+		}
+
+		switch c.Status {
+		case dms.VerdictFail:
+			tc.Failure = &junitMessage{ Message: c.Message, Type: "check" }
+			suite.Failures++
+		case dms.VerdictError:
+			tc.Error = &junitMessage{ Message: c.Message, Type: "check" }
+			suite.Errors++
+		}
+
+		suite.Cases = append(suite.Cases, tc)
+	}
+
+	run := junitCase{
+		Name: "run",
+		ClassName: s.Script,
+		Time: fmt.Sprintf("%.3f", s.Duration),
+	}
+
+	if s.Outcome != OutcomePass && s.Outcome != OutcomeFail {
+		run.Error = &junitMessage{ Message: s.Error, Type: s.Outcome }
+		suite.Errors++
+	}
+
+	suite.Cases = append(suite.Cases, run)
+	suite.Tests = len(suite.Cases)
+
+	f, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+
+	defer f.Close()
+
+	if _, err := f.WriteString(xml.Header); err != nil {
+		return err
+	}
+
+	enc := xml.NewEncoder(f)
+	enc.Indent("", "  ")
+	return enc.Encode(suite)
+}
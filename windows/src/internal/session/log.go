@@ -0,0 +1,140 @@
+package session
+
+import (
+	"io"
+	"os"
+	"fmt"
+	"sync"
+	"time"
+	"strings"
+
+	"dronmotors/dmetrics/internal/device"
+
+	dms "dronmotors/dmetrics/internal/script"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+// Logger writes script and run messages to the console and, once opened, to
+// the session log. File lines are logfmt stamped with the wall clock (or
+// virtual) time and the device time stamp: of the record the script is
+// handling for its own lines, of the latest telemetry record otherwise.
+type Logger struct {
+	sync.Mutex
+
+	Clock dms.Clock
+
+	console io.Writer
+	color bool
+	file *os.File
+	ts string // device ts of the latest telemetry
+}
+
+func NewLogger(console io.Writer) *Logger {
+	return &Logger{
+		Clock: dms.RealClock,
+		console: console,
+		color: isTerminal(console),
+	}
+}
+
+func isTerminal(w io.Writer) bool {
+	if len(os.Getenv("NO_COLOR")) > 0 {
+		return false
+	} else if f, ok := w.(*os.File); !ok {
+		return false
+	} else if fi, err := f.Stat(); err != nil {
+		return false
+	} else {
+		return fi.Mode() & os.ModeCharDevice != 0
+	}
+}
+
+// Open starts the log file, a session directory reused by another run gets
+// a fresh log like the rest of its files
+func (l *Logger) Open(filename string) error {
+	f, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+
+	l.Lock()
+	l.file = f
+	l.Unlock()
+
+	return nil
+}
+
+func (l *Logger) Close() error {
+	l.Lock()
+	defer l.Unlock()
+
+	if l.file == nil {
+		return nil
+	}
+
+	err := l.file.Close()
+	l.file = nil
+	return err
+}
+
+// Observe takes the device time stamp from a telemetry record
+func (l *Logger) Observe(t device.Telemetry) {
+	ts := telemetryTs(t)
+
+	l.Lock()
+	l.ts = ts
+	l.Unlock()
+}
+
+var levelColors = map[string]string{
+	dms.LogDebug: "\x1b[90m",
+	dms.LogInfo:  "\x1b[36m",
+	dms.LogWarn:  "\x1b[33m",
+	dms.LogError: "\x1b[31m",
+}
+
+func (l *Logger) Log(e dms.LogEntry) {
+	if e.Time.IsZero() {
+		e.Time = l.Clock.Now()
+	}
+
+	fields := e.FormatFields()
+
+	l.Lock()
+	defer l.Unlock()
+
+	var line strings.Builder
+	if e.Level != dms.LogPrint {
+		level := strings.ToUpper(e.Level)
+		if c, ok := levelColors[e.Level]; ok && l.color {
+			level = c + level + "\x1b[0m"
+		}
+		line.WriteString(level + " ")
+	}
+	line.WriteString(e.Message)
+	if len(fields) > 0 {
+		line.WriteString(" " + fields)
+	}
+	fmt.Fprintln(l.console, line.String())
+
+	if l.file != nil {
+		ts := e.Ts
+		if len(ts) == 0 {
+			ts = l.ts
+		}
+		if len(ts) == 0 {
+			ts = "-"
+		}
+		fmt.Fprintf(l.file, "time=%s ts=%s level=%s msg=%s",
+			e.Time.Format(time.RFC3339Nano), ts, e.Level, dms.FormatValue(e.Message))
+		if len(fields) > 0 {
+			fmt.Fprintf(l.file, " %s", fields)
+		}
+		fmt.Fprintln(l.file)
+	}
+}
+
+func (l *Logger) Printf(level string, t string, args ...interface{}) {
+	l.Log(dms.LogEntry{ Level: level, Message: fmt.Sprintf(t, args...) })
+}
@@ -0,0 +1,175 @@
+package session
+
+import (
+	"os"
+	"time"
+	"path/filepath"
+
+	"encoding/json"
+
+	"dronmotors/dmetrics/internal/sink"
+	"dronmotors/dmetrics/internal/device"
+
+	dms "dronmotors/dmetrics/internal/script"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+const (
+	OutcomePass		= "pass"
+	OutcomeFail		= "fail"
+	OutcomeError		= "error"
+	OutcomeDisconnected	= "disconnected"
+	OutcomeAborted		= "aborted"
+)
+
+// Session describes a single test run. Everything produced by the run is
+// stored in the session directory: telemetry.csv, session.json, etc.
+type Session struct {
+	Dir           string             `json:"-"`
+	Script        string             `json:"script"`
+	Device        string             `json:"device"`
+	Params        map[string]string  `json:"params"`
+	Started       time.Time          `json:"started"`
+	Finished      time.Time          `json:"finished"`
+	Duration      float64            `json:"duration"` // seconds
+	Outcome       string             `json:"outcome"`
+	Verdict       string             `json:"verdict"`
+	Checks        []dms.Check        `json:"checks"`
+	Events        []Event            `json:"events,omitempty"`
+	Telemetry     *device.QueueStats `json:"telemetry,omitempty"`
+	ScriptDropped uint64             `json:"scriptDropped,omitempty"`
+	Error         string             `json:"error,omitempty"`
+
+	Clock dms.Clock `json:"-"` // times the run, real time if nil
+}
+
+func New(dir string) (*Session, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	return &Session{
+		Dir: dir,
+		Params: map[string]string{},
+		Started: time.Now(),
+	}, nil
+}
+
+// Load reads session.json of a session directory
+func Load(dir string) (*Session, error) {
+	data, err := os.ReadFile(filepath.Join(dir, "session.json"))
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Session{ Dir: dir }
+	if err := json.Unmarshal(data, s); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+func (s *Session) Path(name string) string {
+	return filepath.Join(s.Dir, name)
+}
+
+// Finish records the outcome of the run. Any run error overrides the
+// verdict of the checks.
+func (s *Session) Finish(result *dms.Result, outcome string, err error) {
+	s.Finished = time.Now()
+	if s.Clock != nil {
+		s.Finished = s.Clock.Now()
+	}
+	s.Duration = s.Finished.Sub(s.Started).Seconds()
+	s.Outcome = outcome
+
+	if result != nil {
+		s.Verdict = result.Verdict()
+		result.Lock()
+		s.Checks = append([]dms.Check{}, result.Checks...)
+		result.Unlock()
+	} else {
+		s.Verdict = dms.VerdictPass
+	}
+
+	if err != nil {
+		s.Error = err.Error()
+		s.Verdict = dms.VerdictError
+	}
+}
+
+func (s *Session) Save() error {
+	return s.WriteJSON(s.Path("session.json"))
+}
+
+func (s *Session) WriteJSON(filename string) error {
+	f, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+
+	defer f.Close()
+
+	enc := json.NewEncoder(f)
+	enc.SetIndent("", "  ")
+	enc.SetEscapeHTML(false)
+	return enc.Encode(s)
+}
+
+func (s *Session) SaveEvents() error {
+	if len(s.Events) == 0 {
+		return nil
+	}
+	return WriteEvents(s.Path("events.csv"), s.Events)
+}
+
+func (s *Session) SaveTelemetry(telemetry []device.Telemetry) error {
+	if len(telemetry) == 0 {
+		return nil
+	}
+
+	w, err := sink.NewCSV(s.Path("telemetry.csv"))
+	if err != nil {
+		return err
+	}
+
+	for _, t := range telemetry {
+		if err := w.Write(t); err != nil {
+			w.Close()
+			return err
+		}
+	}
+
+	return w.Close()
+}
+
+// SaveInflux writes telemetry as InfluxDB line protocol to a file or a write
+// url, points are tagged with the device, script and run (session directory)
+func (s *Session) SaveInflux(target string, token string, telemetry []device.Telemetry) error {
+	if len(telemetry) == 0 {
+		return nil
+	}
+
+	w, err := sink.NewInflux(target, sink.InfluxOptions{
+		Tags: map[string]string{
+			"device": s.Device,
+			"script": filepath.Base(s.Script),
+			"run": filepath.Base(s.Dir),
+		},
+		Token: token,
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, t := range telemetry {
+		if err := w.Write(t); err != nil {
+			w.Close()
+			return err
+		}
+	}
+
+	return w.Close()
+}
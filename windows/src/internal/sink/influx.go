@@ -0,0 +1,288 @@
+package sink
+
+import (
+	"io"
+	"os"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+	"bufio"
+	"bytes"
+	"strconv"
+	"strings"
+	"net/http"
+
+	"dronmotors/dmetrics/internal/device"
+)
+
+const DefaultMeasurement = "telemetry"
+
+// points per http request, a request is sent at least every interval
+const influxBatch = 5000
+const influxInterval = time.Second
+
+// points kept for another attempt while the write endpoint is failing
+const influxMaxPending = 10 * influxBatch
+
+// the device clock is followed while it stays that close to the host one
+const influxMaxDrift = time.Second
+
+// InfluxOptions: tags are added to every point, empty ones are omitted. The
+// device tag is taken from the device on connect unless set.
+type InfluxOptions struct {
+	Measurement string // DefaultMeasurement if empty
+	Tags map[string]string
+	Token string // http only, InfluxDB 2 API token
+}
+
+// influxSink writes InfluxDB line protocol to a file or to a write endpoint:
+//
+//	telemetry,device=DMSX,run=...,script=test.lua,tag=hover motorRPM=5980,... 1760000000000000000
+//
+// Record fields become fields, the phase tag of the record becomes the tag
+// tag. The time of a point is the device ts (ms) mapped to the host clock.
+type influxSink struct {
+	opts InfluxOptions
+	tags map[string]string
+
+	file *os.File
+	url string
+	w *bufio.Writer
+	buf bytes.Buffer
+	points int
+	flushed time.Time // the last attempt
+	retry bool // the buffer holds a failed batch
+	lost int
+
+	base time.Time // host time of device ts 0
+	lastTs float64
+}
+
+// NewInflux writes to target: a file name (appended) or an http(s) write url, e.g.
+// http://localhost:8086/api/v2/write?org=lab&bucket=motors or
+// http://localhost:8086/write?db=motors
+func NewInflux(target string, opts InfluxOptions) (Sink, error) {
+	if len(opts.Measurement) == 0 {
+		opts.Measurement = DefaultMeasurement
+	}
+
+	s := &influxSink{
+		opts: opts,
+		tags: map[string]string{},
+	}
+
+	for k, v := range opts.Tags {
+		s.tags[k] = v
+	}
+
+	if strings.HasPrefix(target, "http://") || strings.HasPrefix(target, "https://") {
+		s.url = target
+		return s, nil
+	}
+
+	// the history of runs accumulates in a file
+	f, err := os.OpenFile(target, os.O_WRONLY | os.O_CREATE | os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	s.file = f
+	s.w = bufio.NewWriter(f)
+	return s, nil
+}
+
+func (s *influxSink) OnConnect(dev device.Device) {
+	if len(s.tags["device"]) == 0 {
+		s.tags["device"] = dev.Id()
+	}
+}
+
+var (
+	influxMeasurementEscaper = strings.NewReplacer(`,`, `\,`, ` `, `\ `, "\n", `\n`)
+	influxKeyEscaper = strings.NewReplacer(`,`, `\,`, `=`, `\=`, ` `, `\ `, "\n", `\n`)
+	influxStringEscaper = strings.NewReplacer(`"`, `\"`, `\`, `\\`, "\n", `\n`)
+)
+
+// pointTime maps the device time stamp to the host clock: the offset is taken
+// from the first record and again when the device restarts its counter or
+// drifts away
+func (s *influxSink) pointTime(t device.Telemetry, fields map[string]interface{}) time.Time {
+	host := t.TimeStamp()
+
+	ts, ok := fields["ts"].(float64)
+	if !ok {
+		return host
+	}
+
+	at := s.base.Add(time.Duration(ts * float64(time.Millisecond)))
+	if d := at.Sub(host); s.base.IsZero() || ts < s.lastTs || d > influxMaxDrift || d < -influxMaxDrift {
+		s.base = host.Add(-time.Duration(ts * float64(time.Millisecond)))
+		at = host
+	}
+
+	s.lastTs = ts
+	return at
+}
+
+// line formats a point, nil if it has no fields. NaN and infinite values
+// are not representable, such fields are left out.
+func (s *influxSink) line(t device.Telemetry) []byte {
+	fields := device.Fields(t)
+	at := s.pointTime(t, fields)
+
+	for k, v := range fields {
+		if f, ok := v.(float64); ok && (math.IsNaN(f) || math.IsInf(f, 0)) {
+			delete(fields, k)
+		}
+	}
+
+	tags := map[string]string{}
+	for k, v := range s.tags {
+		tags[k] = v
+	}
+
+	if tag, ok := fields["tag"].(string); ok {
+		tags["tag"] = tag
+		delete(fields, "tag")
+	}
+
+	if len(fields) == 0 {
+		return nil
+	}
+
+	var b bytes.Buffer
+	b.WriteString(influxMeasurementEscaper.Replace(s.opts.Measurement))
+
+	keys := make([]string, 0, len(tags))
+	for k := range tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		if len(tags[k]) > 0 {
+			fmt.Fprintf(&b, ",%s=%s", influxKeyEscaper.Replace(k), influxKeyEscaper.Replace(tags[k]))
+		}
+	}
+
+	keys = keys[:0]
+	for k := range fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for i, k := range keys {
+		if i == 0 {
+			b.WriteByte(' ')
+		} else {
+			b.WriteByte(',')
+		}
+
+		b.WriteString(influxKeyEscaper.Replace(k))
+		b.WriteByte('=')
+
+		switch v := fields[k].(type) {
+		case float64:
+			b.WriteString(strconv.FormatFloat(v, 'f', -1, 64))
+		default:
+			fmt.Fprintf(&b, "\"%s\"", influxStringEscaper.Replace(fmt.Sprint(v)))
+		}
+	}
+
+	fmt.Fprintf(&b, " %d\n", at.UnixNano())
+	return b.Bytes()
+}
+
+func (s *influxSink) Write(t device.Telemetry) error {
+	line := s.line(t)
+	if line == nil {
+		return nil
+	}
+
+	if s.w != nil {
+		if _, err := s.w.Write(line); err != nil {
+			return errorf("influx: %v", err)
+		}
+		return nil
+	}
+
+	if s.flushed.IsZero() {
+		s.flushed = time.Now()
+	}
+
+	// while failing only the interval paces the attempts
+	s.buf.Write(line)
+	if s.points++; (s.points >= influxBatch && !s.retry) || time.Since(s.flushed) >= influxInterval {
+		return s.flush(false)
+	}
+	return nil
+}
+
+// flush sends the buffer. A batch that failed for a reason that may go away
+// (no connection, server errors) is kept and sent again with the next one
+// until there are influxMaxPending points, a rejected or an overflowing one
+// is dropped and counted as lost. The last flush is not retried.
+func (s *influxSink) flush(last bool) error {
+	if s.buf.Len() == 0 {
+		return nil
+	}
+
+	s.flushed = time.Now()
+
+	keep, err := s.post()
+	if err != nil && keep && !last && s.points < influxMaxPending {
+		s.retry = true
+		return nil
+	}
+
+	if err != nil {
+		s.lost += s.points
+		err = errorf("influx: %v, %d points lost", err, s.lost)
+	}
+
+	s.buf.Reset()
+	s.points = 0
+	s.retry = false
+	return err
+}
+
+// post sends the buffer, keep tells whether a failed batch is worth another
+// attempt
+func (s *influxSink) post() (keep bool, err error) {
+	req, err := http.NewRequest(http.MethodPost, s.url, bytes.NewReader(s.buf.Bytes()))
+	if err != nil {
+		return false, err
+	}
+
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	if len(s.opts.Token) > 0 {
+		req.Header.Set("Authorization", "Token " + s.opts.Token)
+	}
+
+	client := http.Client{ Timeout: 30 * time.Second }
+	res, err := client.Do(req)
+	if err != nil {
+		return true, err
+	}
+
+	defer res.Body.Close()
+	if res.StatusCode / 100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(res.Body, 512))
+		keep := res.StatusCode / 100 == 5 || res.StatusCode == http.StatusTooManyRequests
+		return keep, fmt.Errorf("%s: %s", res.Status, strings.TrimSpace(string(msg)))
+	}
+	return false, nil
+}
+
+func (s *influxSink) Close() error {
+	if s.w == nil {
+		return s.flush(true)
+	}
+
+	if err := s.w.Flush(); err != nil {
+		s.file.Close()
+		return errorf("influx: %v", err)
+	}
+	return s.file.Close()
+}
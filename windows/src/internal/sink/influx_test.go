@@ -0,0 +1,253 @@
+package sink
+
+import (
+	"io"
+	"os"
+	"fmt"
+	"sync"
+	"time"
+	"strings"
+	"testing"
+	"net/http"
+	"path/filepath"
+	"net/http/httptest"
+)
+
+type record struct {
+	keys, values []string
+	ts time.Time
+}
+
+func (r record) Id() string { return "test" }
+func (r record) AsKeys() []string { return r.keys }
+func (r record) AsValues() []string { return r.values }
+func (r record) TimeStamp() time.Time { return r.ts }
+func (r record) String() string { return strings.Join(r.values, ",") }
+
+var epoch = time.Unix(1760000000, 0)
+
+func newInflux(t *testing.T, target string, opts InfluxOptions) *influxSink {
+	s, err := NewInflux(target, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s.(*influxSink)
+}
+
+func TestInfluxLine(t *testing.T) {
+	tests := []struct {
+		name string
+		opts InfluxOptions
+		keys, values []string
+		want string
+	}{
+		{
+			"plain",
+			InfluxOptions{ Tags: map[string]string{ "run": "r1", "device": "DMSX" } },
+			[]string{ "ts", "motorRPM", "tag" },
+			[]string{ "0", "5980.5", "hover" },
+			"telemetry,device=DMSX,run=r1,tag=hover motorRPM=5980.5,ts=0 1760000000000000000\n",
+		},
+		{
+			"escaping",
+			InfluxOptions{ Measurement: "motor data,v2", Tags: map[string]string{ "a b": "x,y=z" } },
+			[]string{ "ts", "my key", "note" },
+			[]string{ "0", "1e3", `say "hi" \` },
+			`motor\ data\,v2,a\ b=x\,y\=z my\ key=1000,note="say \"hi\" \\",ts=0 1760000000000000000` + "\n",
+		},
+		{
+			"empty tags omitted",
+			InfluxOptions{ Tags: map[string]string{ "run": "" } },
+			[]string{ "ts", "rpm", "tag" },
+			[]string{ "0", "1", "" },
+			"telemetry rpm=1,ts=0 1760000000000000000\n",
+		},
+		{
+			"non-finite skipped",
+			InfluxOptions{},
+			[]string{ "ts", "a", "b", "c" },
+			[]string{ "0", "NaN", "+Inf", "-2" },
+			"telemetry c=-2,ts=0 1760000000000000000\n",
+		},
+		{
+			"no fields",
+			InfluxOptions{},
+			[]string{ "rpm", "tag" },
+			[]string{ "NaN", "idle" },
+			"",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newInflux(t, "http://localhost/write", tt.opts)
+			if got := string(s.line(record{ tt.keys, tt.values, epoch })); got != tt.want {
+				t.Errorf("got  %q\nwant %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestInfluxTime(t *testing.T) {
+	s := newInflux(t, "http://localhost/write", InfluxOptions{})
+
+	// device ts in ms, the time it is received
+	tests := []struct {
+		ts float64
+		host, want time.Duration
+	}{
+		{ 0, 0, 0 },
+		{ 100, 130 * time.Millisecond, 100 * time.Millisecond },
+		{ 200, 190 * time.Millisecond, 200 * time.Millisecond },
+		{ 50, 300 * time.Millisecond, 300 * time.Millisecond }, // restarted
+		{ 60, 320 * time.Millisecond, 310 * time.Millisecond },
+		{ 5000, 400 * time.Millisecond, 400 * time.Millisecond }, // drifted
+	}
+
+	for _, tt := range tests {
+		r := record{ []string{ "ts" }, []string{ fmt.Sprint(tt.ts) }, epoch.Add(tt.host) }
+		if d := s.pointTime(r, map[string]interface{}{ "ts": tt.ts }).Sub(epoch); d != tt.want {
+			t.Errorf("ts %g: got %v, want %v", tt.ts, d, tt.want)
+		}
+	}
+}
+
+func TestInfluxFile(t *testing.T) {
+	target := filepath.Join(t.TempDir(), "runs.influx")
+
+	for i := 0; i < 2; i++ {
+		s := newInflux(t, target, InfluxOptions{})
+		if err := s.Write(record{ []string{ "rpm" }, []string{ "1" }, epoch }); err != nil {
+			t.Fatal(err)
+		} else if err := s.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	data, err := os.ReadFile(target)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// appended
+	if got := strings.Count(string(data), "telemetry rpm=1 "); got != 2 {
+		t.Errorf("%d points in\n%s", got, data)
+	}
+}
+
+func TestInfluxHTTP(t *testing.T) {
+	var got []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		got = append(got, r.Header.Get("Authorization") + " " + string(body))
+
+		if strings.Contains(string(body), "rpm=0") {
+			http.Error(w, "bad point", http.StatusBadRequest)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	s := newInflux(t, srv.URL, InfluxOptions{ Token: "secret" })
+	if err := s.Write(record{ []string{ "rpm" }, []string{ "1" }, epoch }); err != nil {
+		t.Fatal(err)
+	} else if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if want := "Token secret telemetry rpm=1 1760000000000000000\n"; len(got) != 1 || got[0] != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	s = newInflux(t, srv.URL, InfluxOptions{})
+	s.Write(record{ []string{ "rpm" }, []string{ "0" }, epoch })
+	if err := s.Close(); err == nil || !strings.Contains(err.Error(), "bad point") {
+		t.Errorf("got error %v", err)
+	}
+}
+
+// endpoint answers with the queued status codes, then with 204
+type endpoint struct {
+	sync.Mutex
+	codes []int
+	bodies []string
+}
+
+func (e *endpoint) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+
+	e.Lock()
+	defer e.Unlock()
+
+	code := http.StatusNoContent
+	if len(e.codes) > 0 {
+		code, e.codes = e.codes[0], e.codes[1:]
+	}
+
+	if code / 100 == 2 {
+		e.bodies = append(e.bodies, string(body))
+	}
+	w.WriteHeader(code)
+}
+
+func TestInfluxRetry(t *testing.T) {
+	rpm := func(v string) record {
+		return record{ []string{ "rpm" }, []string{ v }, epoch }
+	}
+
+	tests := []struct {
+		name string
+		codes []int
+		flushes int // before Close
+		pending int // points to fake a full buffer
+		err string
+		sent int
+	}{
+		{ "ok", nil, 1, 0, "", 2 },
+		{ "server error retried", []int{ 503 }, 1, 0, "", 2 },
+		{ "throttled retried", []int{ 429, 500 }, 2, 0, "", 2 },
+		{ "rejected", []int{ 400 }, 1, 0, "1 points lost", 1 },
+		{ "last attempt", []int{ 503, 503 }, 1, 0, "2 points lost", 0 },
+		{ "overflow", []int{ 503 }, 1, influxMaxPending, "points lost", 1 },
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := &endpoint{ codes: tt.codes }
+			srv := httptest.NewServer(e)
+			defer srv.Close()
+
+			s := newInflux(t, srv.URL, InfluxOptions{})
+			if err := s.Write(rpm("1")); err != nil {
+				t.Fatal(err)
+			}
+
+			s.points += tt.pending
+
+			var errs []string
+			for i := 0; i < tt.flushes; i++ {
+				if err := s.flush(false); err != nil {
+					errs = append(errs, err.Error())
+				}
+			}
+
+			if err := s.Write(rpm("2")); err != nil {
+				errs = append(errs, err.Error())
+			} else if err := s.Close(); err != nil {
+				errs = append(errs, err.Error())
+			}
+
+			if err := strings.Join(errs, "; "); len(tt.err) == 0 && len(err) > 0 || !strings.Contains(err, tt.err) {
+				t.Errorf("got error %q, want %q", err, tt.err)
+			}
+
+			e.Lock()
+			defer e.Unlock()
+
+			if got := strings.Count(strings.Join(e.bodies, ""), "\n"); got != tt.sent {
+				t.Errorf("%d points sent, want %d: %q", got, tt.sent, e.bodies)
+			}
+		})
+	}
+}
@@ -0,0 +1,89 @@
+package sink
+
+import (
+	"os"
+	"fmt"
+
+	"encoding/csv"
+
+	"dronmotors/dmetrics/internal/device"
+)
+
+func errorf(t string, args ...interface{}) error {
+	return fmt.Errorf("sink: " + t, args...)
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// Sink records telemetry at full rate
+type Sink interface {
+	Write(device.Telemetry) error
+	Close() error
+}
+
+// Callbacks subscribes a sink to the device bus, the first write error is
+// reported through fail and stops writing. A sink with OnConnect learns the
+// device.
+func Callbacks(s Sink, fail func(error)) device.Callbacks {
+	var failed bool
+	return &device.CallbacksWrapper{
+		Connect: func(dev device.Device) {
+			if c, ok := s.(interface{ OnConnect(device.Device) }); ok {
+				c.OnConnect(dev)
+			}
+		},
+		Telemetry: func(dev device.Device, t device.Telemetry) {
+			if failed {
+				return
+			} else if err := s.Write(t); err != nil {
+				failed = true
+				fail(err)
+			}
+		},
+	}
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// csvSink writes telemetry.csv: an idx column followed by the record keys
+type csvSink struct {
+	file *os.File
+	writer *csv.Writer
+	idx int
+}
+
+func NewCSV(filename string) (Sink, error) {
+	f, err := os.Create(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	return &csvSink{
+		file: f,
+		writer: csv.NewWriter(f),
+	}, nil
+}
+
+func (s *csvSink) Write(t device.Telemetry) error {
+	if s.idx == 0 {
+		if err := s.writer.Write(append([]string{ "idx" }, t.AsKeys()...)); err != nil {
+			return errorf("csv: %v", err)
+		}
+	}
+
+	if err := s.writer.Write(append([]string{ fmt.Sprintf("%d", s.idx) }, t.AsValues()...)); err != nil {
+		return errorf("csv: %v", err)
+	}
+
+	s.idx++
+	return nil
+}
+
+func (s *csvSink) Close() error {
+	s.writer.Flush()
+	if err := s.writer.Error(); err != nil {
+		s.file.Close()
+		return errorf("csv: %v", err)
+	}
+	return s.file.Close()
+}
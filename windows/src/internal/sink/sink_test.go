@@ -0,0 +1,97 @@
+package sink
+
+import (
+	"os"
+	"strings"
+	"testing"
+	"path/filepath"
+
+	"dronmotors/dmetrics/internal/device"
+)
+
+func TestCSV(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "telemetry.csv")
+	s, err := NewCSV(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keys := []string{ "ts", "motorRPM", "tag" }
+	for _, values := range [][]string{ { "0", "5000", "hover" }, { "10", "5100", "max, full" } } {
+		if err := s.Write(record{ keys: keys, values: values }); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "idx,ts,motorRPM,tag\n0,0,5000,hover\n1,10,5100,\"max, full\"\n"; string(data) != want {
+		t.Errorf("got %q, want %q", data, want)
+	}
+}
+
+func TestCSVFlush(t *testing.T) {
+	// writes are buffered, a full disk shows up on Close
+	if _, err := os.Stat("/dev/full"); err != nil {
+		t.Skip("no /dev/full")
+	}
+
+	s, err := NewCSV("/dev/full")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Write(record{ keys: []string{ "ts" }, values: []string{ "0" } }); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Close(); err == nil || !strings.HasPrefix(err.Error(), "sink: csv:") {
+		t.Errorf("got %v", err)
+	}
+}
+
+// failing fails every write after the first ones
+type failing struct {
+	ok, writes int
+	dev device.Device
+}
+
+func (s *failing) OnConnect(dev device.Device) { s.dev = dev }
+func (s *failing) Close() error { return nil }
+
+func (s *failing) Write(device.Telemetry) error {
+	s.writes++
+	if s.writes > s.ok {
+		return os.ErrClosed
+	}
+	return nil
+}
+
+type named struct {
+	device.Device
+}
+
+func TestCallbacks(t *testing.T) {
+	s := &failing{ ok: 2 }
+	var errs []error
+	c := Callbacks(s, func(err error) { errs = append(errs, err) })
+
+	dev := named{}
+	c.OnConnect(dev)
+	for i := 0; i < 5; i++ {
+		c.OnTelemetry(dev, record{})
+	}
+	c.OnDisconnect(dev)
+
+	if s.dev != dev {
+		t.Errorf("OnConnect not passed to the sink")
+	}
+
+	// the first error is reported, nothing is written after it
+	if s.writes != 3 || len(errs) != 1 || errs[0] != os.ErrClosed {
+		t.Errorf("%d writes, errors %v", s.writes, errs)
+	}
+}
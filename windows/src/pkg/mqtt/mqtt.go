@@ -0,0 +1,444 @@
+// Package mqtt is a minimal MQTT 3.1.1 client: QoS 0 publish and subscribe,
+// keep alive and a last will over tcp or tls, no persistence.
+package mqtt
+
+import (
+	"io"
+	"net"
+	"fmt"
+	"sync"
+	"time"
+	"bufio"
+	"strings"
+
+	"crypto/tls"
+	"encoding/binary"
+)
+
+const (
+	packetConnect		= 1
+	packetConnAck		= 2
+	packetPublish		= 3
+	packetPubAck		= 4
+	packetSubscribe		= 8
+	packetSubAck		= 9
+	packetPingReq		= 12
+	packetPingResp		= 13
+	packetDisconnect	= 14
+)
+
+const (
+	DefaultPort = "1883"
+	DefaultTLSPort = "8883"
+)
+
+const maxPacketSize = 1 << 20
+
+func errorf(t string, args ...interface{}) error {
+	return fmt.Errorf("mqtt: " + t, args...)
+}
+
+var ErrClosed = errorf("connection closed")
+
+var connectErrors = []string{
+	1: "unacceptable protocol version",
+	2: "client identifier rejected",
+	3: "server unavailable",
+	4: "bad user name or password",
+	5: "not authorized",
+}
+
+type Message struct {
+	Topic string
+	Payload []byte
+	Retain bool
+}
+
+type Options struct {
+	ClientId string
+	Username string
+	Password string
+	KeepAlive time.Duration // 30s by default
+	Will *Message // published by the broker when the connection is lost
+	TLS *tls.Config // of a tls:// broker, nil - the system roots
+}
+
+type Client struct {
+	conn net.Conn
+	r *bufio.Reader
+	wmu sync.Mutex
+
+	handler func(Message)
+	keepAlive time.Duration
+
+	smu sync.Mutex // one subscription at a time
+	packetId uint16
+	subAck chan []byte
+
+	done chan struct{}
+	once sync.Once
+	err error
+}
+
+// Secure tells the broker is connected over tls: a tls://, ssl:// or
+// mqtts:// scheme
+func Secure(broker string) bool {
+	for _, p := range []string{ "tls://", "ssl://", "mqtts://" } {
+		if strings.HasPrefix(broker, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// Address adds the default port, a tcp:// or mqtt:// scheme is accepted, as
+// well as the tls ones
+func Address(broker string) string {
+	port := DefaultPort
+	if Secure(broker) {
+		port = DefaultTLSPort
+	}
+
+	for _, p := range []string{ "tcp://", "mqtt://", "tls://", "ssl://", "mqtts://" } {
+		broker = strings.TrimPrefix(broker, p)
+	}
+	if _, _, err := net.SplitHostPort(broker); err != nil {
+		return net.JoinHostPort(broker, port)
+	}
+	return broker
+}
+
+// Dial connects to the broker, handler gets messages of the subscriptions on
+// the reading goroutine
+func Dial(broker string, opts Options, handler func(Message)) (*Client, error) {
+	if opts.KeepAlive <= 0 {
+		opts.KeepAlive = 30 * time.Second
+	}
+
+	var conn net.Conn
+	var err error
+
+	dialer := &net.Dialer{ Timeout: 10 * time.Second }
+	if Secure(broker) {
+		conn, err = tls.DialWithDialer(dialer, "tcp", Address(broker), opts.TLS)
+	} else {
+		conn, err = dialer.Dial("tcp", Address(broker))
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	c := &Client{
+		conn: conn,
+		r: bufio.NewReader(conn),
+		handler: handler,
+		keepAlive: opts.KeepAlive,
+		subAck: make(chan []byte, 1),
+		done: make(chan struct{}),
+	}
+
+	if err := c.connect(opts); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	go c.read()
+	go c.ping()
+	return c, nil
+}
+
+func (c *Client) connect(opts Options) error {
+	var flags byte = 0x02 // clean session
+	var payload []byte
+	payload = appendString(payload, opts.ClientId)
+
+	if opts.Will != nil {
+		flags |= 0x04
+		if opts.Will.Retain {
+			flags |= 0x20
+		}
+		payload = appendString(payload, opts.Will.Topic)
+		payload = appendBytes(payload, opts.Will.Payload)
+	}
+
+	if len(opts.Username) > 0 {
+		flags |= 0x80
+		payload = appendString(payload, opts.Username)
+	}
+
+	if len(opts.Password) > 0 {
+		flags |= 0x40
+		payload = appendString(payload, opts.Password)
+	}
+
+	keepAlive := int(opts.KeepAlive / time.Second)
+	if keepAlive > 0xffff {
+		keepAlive = 0xffff
+	}
+
+	var body []byte
+	body = appendString(body, "MQTT")
+	body = append(body, 4, flags)
+	body = binary.BigEndian.AppendUint16(body, uint16(keepAlive))
+	body = append(body, payload...)
+
+	if err := c.write(packetConnect << 4, body); err != nil {
+		return err
+	}
+
+	c.conn.SetReadDeadline(time.Now().Add(10 * time.Second))
+	typ, body, err := c.readPacket()
+	switch {
+	case err != nil:
+		return err
+	case typ >> 4 != packetConnAck || len(body) != 2:
+		return errorf("CONNACK expected")
+	case body[1] != 0:
+		if int(body[1]) < len(connectErrors) {
+			return errorf("connection refused: %s", connectErrors[body[1]])
+		}
+		return errorf("connection refused: code %d", body[1])
+	}
+
+	return nil
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+func appendString(b []byte, s string) []byte {
+	return appendBytes(b, []byte(s))
+}
+
+func appendBytes(b []byte, s []byte) []byte {
+	b = binary.BigEndian.AppendUint16(b, uint16(len(s)))
+	return append(b, s...)
+}
+
+func readString(b []byte) (string, []byte, error) {
+	if len(b) < 2 {
+		return "", nil, errorf("malformed packet")
+	}
+
+	n := int(binary.BigEndian.Uint16(b))
+	if len(b) < 2 + n {
+		return "", nil, errorf("malformed packet")
+	}
+	return string(b[2:2 + n]), b[2 + n:], nil
+}
+
+func (c *Client) write(header byte, body []byte) error {
+	packet := []byte{ header }
+	for n := len(body); ; {
+		d := byte(n % 128)
+		if n /= 128; n > 0 {
+			d |= 0x80
+		}
+		packet = append(packet, d)
+		if n == 0 {
+			break
+		}
+	}
+	packet = append(packet, body...)
+
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+
+	c.conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+	_, err := c.conn.Write(packet)
+	return err
+}
+
+func (c *Client) readPacket() (byte, []byte, error) {
+	header, err := c.r.ReadByte()
+	if err != nil {
+		return 0, nil, err
+	}
+
+	var n, shift int
+	for {
+		d, err := c.r.ReadByte()
+		if err != nil {
+			return 0, nil, err
+		} else if shift > 21 {
+			return 0, nil, errorf("malformed remaining length")
+		}
+
+		n |= int(d & 0x7f) << shift
+		if d & 0x80 == 0 {
+			break
+		}
+		shift += 7
+	}
+
+	if n > maxPacketSize {
+		return 0, nil, errorf("packet of %d bytes is too big", n)
+	}
+
+	body := make([]byte, n)
+	if _, err := io.ReadFull(c.r, body); err != nil {
+		return 0, nil, err
+	}
+	return header, body, nil
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+func (c *Client) read() {
+	for {
+		// the broker answers pings, so silence means the link is gone
+		c.conn.SetReadDeadline(time.Now().Add(c.keepAlive * 3 / 2))
+
+		header, body, err := c.readPacket()
+		if err != nil {
+			c.fail(err)
+			return
+		}
+
+		switch header >> 4 {
+		case packetPublish:
+			if err := c.receive(header, body); err != nil {
+				c.fail(err)
+				return
+			}
+		case packetSubAck:
+			select {
+			case c.subAck <- body:
+			default:
+			}
+		case packetPingResp:
+		default:
+			c.fail(errorf("unexpected packet type %d", header >> 4))
+			return
+		}
+	}
+}
+
+func (c *Client) receive(header byte, body []byte) error {
+	topic, rest, err := readString(body)
+	if err != nil {
+		return err
+	}
+
+	// subscriptions are QoS 0, still a broker may deliver retained QoS 1
+	if qos := (header >> 1) & 3; qos > 0 {
+		if len(rest) < 2 {
+			return errorf("malformed packet")
+		} else if qos == 1 {
+			if err := c.write(packetPubAck << 4, rest[:2]); err != nil {
+				return err
+			}
+		}
+		rest = rest[2:]
+	}
+
+	if c.handler != nil {
+		c.handler(Message{ Topic: topic, Payload: rest, Retain: header & 1 != 0 })
+	}
+	return nil
+}
+
+func (c *Client) ping() {
+	ticker := time.NewTicker(c.keepAlive / 2)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.done:
+			return
+		case <-ticker.C:
+			if err := c.write(packetPingReq << 4, nil); err != nil {
+				c.fail(err)
+				return
+			}
+		}
+	}
+}
+
+func (c *Client) fail(err error) {
+	c.once.Do(func() {
+		c.err = err
+		close(c.done)
+		c.conn.Close()
+	})
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+func (c *Client) Publish(m Message) error {
+	select {
+	case <-c.done:
+		return ErrClosed
+	default:
+	}
+
+	header := byte(packetPublish << 4)
+	if m.Retain {
+		header |= 1
+	}
+
+	body := appendString(nil, m.Topic)
+	return c.write(header, append(body, m.Payload...))
+}
+
+// Subscribe subscribes to topic filters with QoS 0 and waits for the broker
+func (c *Client) Subscribe(topics ...string) error {
+	c.smu.Lock()
+	defer c.smu.Unlock()
+
+	c.packetId++
+	if c.packetId == 0 {
+		c.packetId = 1
+	}
+
+	body := binary.BigEndian.AppendUint16(nil, c.packetId)
+	for _, t := range topics {
+		body = append(appendString(body, t), 0)
+	}
+
+	if err := c.write(packetSubscribe << 4 | 0x02, body); err != nil {
+		return err
+	}
+
+	select {
+	case ack := <-c.subAck:
+		if len(ack) != 2 + len(topics) || binary.BigEndian.Uint16(ack) != c.packetId {
+			return errorf("unexpected SUBACK")
+		}
+		for i, code := range ack[2:] {
+			if code & 0x80 != 0 {
+				return errorf("subscription to %q refused", topics[i])
+			}
+		}
+		return nil
+	case <-c.done:
+		return c.Err()
+	case <-time.After(10 * time.Second):
+		return errorf("SUBACK timeout")
+	}
+}
+
+// Done is closed when the connection is lost or closed
+func (c *Client) Done() <-chan struct{} {
+	return c.done
+}
+
+func (c *Client) Err() error {
+	select {
+	case <-c.done:
+		return c.err
+	default:
+		return nil
+	}
+}
+
+// Close disconnects gracefully, the broker discards the will
+func (c *Client) Close() error {
+	select {
+	case <-c.done:
+	default:
+		c.write(packetDisconnect << 4, nil)
+	}
+
+	c.fail(ErrClosed)
+	return nil
+}
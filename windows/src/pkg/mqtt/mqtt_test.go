@@ -0,0 +1,300 @@
+package mqtt
+
+import (
+	"io"
+	"net"
+	"bufio"
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+	"net/http/httptest"
+
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/binary"
+)
+
+func TestAddress(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{ "broker", "broker:1883" },
+		{ "broker:11883", "broker:11883" },
+		{ "tcp://broker", "broker:1883" },
+		{ "mqtt://10.0.0.1:1884", "10.0.0.1:1884" },
+		{ "::1", "[::1]:1883" },
+		{ "tls://broker", "broker:8883" },
+		{ "mqtts://broker:18883", "broker:18883" },
+		{ "ssl://10.0.0.1", "10.0.0.1:8883" },
+	}
+
+	for _, tt := range tests {
+		if got := Address(tt.in); got != tt.want {
+			t.Errorf("%q: got %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+// pipe returns the two ends of a connection as packet codecs
+func pipe() (*Client, *Client) {
+	a, b := net.Pipe()
+	return &Client{ conn: a, r: bufio.NewReader(a) }, &Client{ conn: b, r: bufio.NewReader(b) }
+}
+
+func TestRemainingLength(t *testing.T) {
+	tests := []struct {
+		n int
+		encoded []byte
+	}{
+		{ 0, []byte{ 0x00 } },
+		{ 127, []byte{ 0x7f } },
+		{ 128, []byte{ 0x80, 0x01 } },
+		{ 321, []byte{ 0xc1, 0x02 } },
+		{ 16383, []byte{ 0xff, 0x7f } },
+		{ 16384, []byte{ 0x80, 0x80, 0x01 } },
+		{ maxPacketSize, []byte{ 0x80, 0x80, 0x40 } },
+	}
+
+	for _, tt := range tests {
+		w, r := pipe()
+		body := bytes.Repeat([]byte{ 'x' }, tt.n)
+
+		go w.write(packetPublish << 4, body)
+		packet := make([]byte, 1 + len(tt.encoded) + tt.n)
+		if _, err := io.ReadFull(r.r, packet); err != nil {
+			t.Fatal(err)
+		} else if !bytes.Equal(packet[1:1 + len(tt.encoded)], tt.encoded) {
+			t.Errorf("%d: length %x, want %x", tt.n, packet[1:1 + len(tt.encoded)], tt.encoded)
+		}
+
+		// and back
+		go w.write(packetPublish << 4, body)
+		if typ, got, err := r.readPacket(); err != nil || typ != packetPublish << 4 || len(got) != tt.n {
+			t.Errorf("%d: read %x, %d bytes, %v", tt.n, typ, len(got), err)
+		}
+
+		w.conn.Close()
+		r.conn.Close()
+	}
+}
+
+func TestReadPacketErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+		err string
+	}{
+		{ "long length", []byte{ 0x30, 0xff, 0xff, 0xff, 0xff, 0x01 }, "malformed" },
+		{ "too big", []byte{ 0x30, 0x81, 0x80, 0x40 }, "too big" },
+	}
+
+	for _, tt := range tests {
+		w, r := pipe()
+		go w.conn.Write(tt.data)
+
+		if _, _, err := r.readPacket(); err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("%s: got error %v, want %q", tt.name, err, tt.err)
+		}
+
+		w.conn.Close()
+		r.conn.Close()
+	}
+}
+
+// broker accepts one client, replies to CONNECT with code and hands the
+// connection to the test
+func broker(t *testing.T, code byte) (string, chan *Client, chan []byte) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return serve(t, ln, code)
+}
+
+func serve(t *testing.T, ln net.Listener, code byte) (string, chan *Client, chan []byte) {
+	t.Cleanup(func() { ln.Close() })
+
+	conns := make(chan *Client, 1)
+	connect := make(chan []byte, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+
+		b := &Client{ conn: conn, r: bufio.NewReader(conn) }
+		if typ, body, err := b.readPacket(); err != nil || typ != packetConnect << 4 {
+			conn.Close()
+			return
+		} else {
+			connect <- body
+		}
+
+		b.write(packetConnAck << 4, []byte{ 0, code })
+		conns <- b
+	}()
+
+	return ln.Addr().String(), conns, connect
+}
+
+func TestConnect(t *testing.T) {
+	addr, _, connect := broker(t, 0)
+
+	c, err := Dial(addr, Options{
+		ClientId: "dm-cli-1",
+		Username: "user",
+		Password: "secret",
+		KeepAlive: 20 * time.Second,
+		Will: &Message{ Topic: "stand/1/status", Payload: []byte("offline"), Retain: true },
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	var want []byte
+	want = appendString(want, "MQTT")
+	want = append(want, 4, 0x80 | 0x40 | 0x20 | 0x04 | 0x02, 0, 20)
+	want = appendString(want, "dm-cli-1")
+	want = appendString(want, "stand/1/status")
+	want = appendString(want, "offline")
+	want = appendString(want, "user")
+	want = appendString(want, "secret")
+
+	if got := <-connect; !bytes.Equal(got, want) {
+		t.Errorf("CONNECT\n%x, want\n%x", got, want)
+	}
+}
+
+func TestConnectTLS(t *testing.T) {
+	// the test certificate of httptest is for 127.0.0.1
+	srv := httptest.NewTLSServer(nil)
+	srv.Close()
+
+	listen := func() (string, chan []byte) {
+		ln, err := tls.Listen("tcp", "127.0.0.1:0", srv.TLS)
+		if err != nil {
+			t.Fatal(err)
+		}
+		addr, _, connect := serve(t, ln, 0)
+		return addr, connect
+	}
+	addr, connect := listen()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(srv.Certificate())
+
+	c, err := Dial("tls://" + addr, Options{ ClientId: "x", TLS: &tls.Config{ RootCAs: roots } }, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	if _, ok := c.conn.(*tls.Conn); !ok {
+		t.Errorf("%T connection", c.conn)
+	}
+	<-connect
+
+	// not trusted without the certificate
+	addr, _ = listen()
+	if _, err := Dial("tls://" + addr, Options{ ClientId: "x" }, nil); err == nil || !strings.Contains(err.Error(), "certificate") {
+		t.Errorf("got %v, want a certificate error", err)
+	}
+}
+
+func TestConnectRefused(t *testing.T) {
+	addr, _, _ := broker(t, 4)
+
+	if _, err := Dial(addr, Options{ ClientId: "x" }, nil); err == nil || !strings.Contains(err.Error(), "bad user name or password") {
+		t.Errorf("got error %v", err)
+	}
+}
+
+func TestSession(t *testing.T) {
+	addr, conns, _ := broker(t, 0)
+
+	got := make(chan Message, 4)
+	c, err := Dial(addr, Options{ ClientId: "x" }, func(m Message) { got <- m })
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	b := <-conns
+	done := make(chan error, 1)
+	go func() { done <- c.Subscribe("stand/1/command", "stand/+/status") }()
+
+	typ, body, err := b.readPacket()
+	if err != nil || typ != packetSubscribe << 4 | 0x02 {
+		t.Fatalf("SUBSCRIBE expected, got %x %v", typ, err)
+	}
+
+	id := body[:2]
+	filter, rest, _ := readString(body[2:])
+	if filter != "stand/1/command" || rest[0] != 0 {
+		t.Errorf("SUBSCRIBE %q %x", filter, rest)
+	}
+
+	b.write(packetSubAck << 4, append(append([]byte{}, id...), 0, 0))
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+
+	// a retained QoS 0 and a QoS 1 message, the latter is acknowledged
+	b.write(packetPublish << 4 | 1, append(appendString(nil, "stand/1/status"), "online"...))
+	qos1 := appendString(nil, "stand/1/command")
+	qos1 = binary.BigEndian.AppendUint16(qos1, 7)
+	b.write(packetPublish << 4 | 0x02, append(qos1, `{"cmd":"tare"}`...))
+
+	if m := <-got; m.Topic != "stand/1/status" || string(m.Payload) != "online" || !m.Retain {
+		t.Errorf("got %+v", m)
+	}
+	if m := <-got; m.Topic != "stand/1/command" || string(m.Payload) != `{"cmd":"tare"}` || m.Retain {
+		t.Errorf("got %+v", m)
+	}
+
+	if typ, body, err := b.readPacket(); err != nil || typ != packetPubAck << 4 || binary.BigEndian.Uint16(body) != 7 {
+		t.Errorf("PUBACK 7 expected, got %x %x %v", typ, body, err)
+	}
+
+	if err := c.Publish(Message{ Topic: "stand/1/telemetry", Payload: []byte("{}"), Retain: true }); err != nil {
+		t.Fatal(err)
+	}
+
+	if typ, body, err := b.readPacket(); err != nil || typ != packetPublish << 4 | 1 {
+		t.Errorf("PUBLISH expected, got %x %v", typ, err)
+	} else if topic, payload, _ := readString(body); topic != "stand/1/telemetry" || string(payload) != "{}" {
+		t.Errorf("PUBLISH %q %q", topic, payload)
+	}
+
+	c.Close()
+	if typ, _, err := b.readPacket(); err != nil || typ != packetDisconnect << 4 {
+		t.Errorf("DISCONNECT expected, got %x %v", typ, err)
+	}
+
+	if err := c.Publish(Message{ Topic: "x" }); err != ErrClosed {
+		t.Errorf("publish after close: %v", err)
+	}
+}
+
+func TestSubscribeRefused(t *testing.T) {
+	addr, conns, _ := broker(t, 0)
+
+	c, err := Dial(addr, Options{ ClientId: "x" }, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	b := <-conns
+	go func() {
+		if _, body, err := b.readPacket(); err == nil {
+			b.write(packetSubAck << 4, append(body[:2:2], 0x80))
+		}
+	}()
+
+	if err := c.Subscribe("$SYS/#"); err == nil || !strings.Contains(err.Error(), "refused") {
+		t.Errorf("got error %v", err)
+	}
+}
@@ -0,0 +1,226 @@
+// Package websocket is a minimal server side of RFC 6455: enough to stream
+// JSON to browsers and scripts, no extensions, no subprotocols.
+package websocket
+
+import (
+	"io"
+	"net"
+	"fmt"
+	"sync"
+	"time"
+	"bufio"
+	"strings"
+	"net/http"
+
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+)
+
+const (
+	OpContinuation	= 0x0
+	OpText		= 0x1
+	OpBinary	= 0x2
+	OpClose		= 0x8
+	OpPing		= 0x9
+	OpPong		= 0xa
+)
+
+const maxMessageSize = 1 << 20
+
+const acceptGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+func errorf(t string, args ...interface{}) error {
+	return fmt.Errorf("websocket: " + t, args...)
+}
+
+type Conn struct {
+	conn net.Conn
+	rw *bufio.ReadWriter
+	wmu sync.Mutex
+}
+
+func IsUpgrade(r *http.Request) bool {
+	return headerContains(r.Header, "Connection", "upgrade") && headerContains(r.Header, "Upgrade", "websocket")
+}
+
+func headerContains(h http.Header, name, token string) bool {
+	for _, v := range h.Values(name) {
+		for _, s := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(s), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Upgrade completes the handshake, on error the response is already sent
+func Upgrade(w http.ResponseWriter, r *http.Request) (*Conn, error) {
+	key := r.Header.Get("Sec-WebSocket-Key")
+
+	switch {
+	case r.Method != http.MethodGet, !IsUpgrade(r):
+		http.Error(w, "websocket upgrade expected", http.StatusBadRequest)
+		return nil, errorf("not an upgrade request")
+	case r.Header.Get("Sec-WebSocket-Version") != "13":
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		http.Error(w, "unsupported websocket version", http.StatusUpgradeRequired)
+		return nil, errorf("unsupported version")
+	case len(key) == 0:
+		http.Error(w, "missing websocket key", http.StatusBadRequest)
+		return nil, errorf("missing key")
+	}
+
+	hj, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "websocket is not supported", http.StatusInternalServerError)
+		return nil, errorf("connection can't be hijacked")
+	}
+
+	conn, rw, err := hj.Hijack()
+	if err != nil {
+		return nil, err
+	}
+
+	sum := sha1.Sum([]byte(key + acceptGUID))
+	fmt.Fprintf(rw, "HTTP/1.1 101 Switching Protocols\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: %s\r\n\r\n", base64.StdEncoding.EncodeToString(sum[:]))
+
+	if err := rw.Flush(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	return &Conn{ conn: conn, rw: rw }, nil
+}
+
+func (c *Conn) writeFrame(op int, data []byte) error {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+
+	c.conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+
+	hdr := []byte{ 0x80 | byte(op) } // final fragment, server frames are not masked
+	switch n := len(data); {
+	case n < 126:
+		hdr = append(hdr, byte(n))
+	case n < 1 << 16:
+		hdr = append(hdr, 126, byte(n >> 8), byte(n))
+	default:
+		hdr = append(hdr, 127)
+		hdr = binary.BigEndian.AppendUint64(hdr, uint64(n))
+	}
+
+	if _, err := c.rw.Write(hdr); err != nil {
+		return err
+	} else if _, err := c.rw.Write(data); err != nil {
+		return err
+	}
+
+	return c.rw.Flush()
+}
+
+func (c *Conn) WriteMessage(op int, data []byte) error {
+	return c.writeFrame(op, data)
+}
+
+func (c *Conn) readFrame() (fin bool, op int, data []byte, err error) {
+	var hdr [2]byte
+	if _, err = io.ReadFull(c.rw, hdr[:]); err != nil {
+		return
+	}
+
+	fin, op = hdr[0] & 0x80 != 0, int(hdr[0] & 0x0f)
+	masked := hdr[1] & 0x80 != 0
+	n := uint64(hdr[1] & 0x7f)
+
+	switch n {
+	case 126:
+		var ext [2]byte
+		if _, err = io.ReadFull(c.rw, ext[:]); err != nil {
+			return
+		}
+		n = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err = io.ReadFull(c.rw, ext[:]); err != nil {
+			return
+		}
+		n = binary.BigEndian.Uint64(ext[:])
+	}
+
+	if !masked {
+		err = errorf("client frame is not masked")
+		return
+	} else if n > maxMessageSize {
+		err = errorf("frame of %d bytes is too large", n)
+		return
+	}
+
+	var mask [4]byte
+	if _, err = io.ReadFull(c.rw, mask[:]); err != nil {
+		return
+	}
+
+	data = make([]byte, n)
+	if _, err = io.ReadFull(c.rw, data); err != nil {
+		return
+	}
+
+	for i := range data {
+		data[i] ^= mask[i % 4]
+	}
+
+	return
+}
+
+// ReadMessage returns the next text or binary message. Pings are answered,
+// a close frame is echoed and reported as io.EOF.
+func (c *Conn) ReadMessage() (int, []byte, error) {
+	var msg []byte
+	msgOp := -1
+
+	for {
+		fin, op, data, err := c.readFrame()
+		if err != nil {
+			return 0, nil, err
+		}
+
+		switch op {
+		case OpPing:
+			if err := c.writeFrame(OpPong, data); err != nil {
+				return 0, nil, err
+			}
+			continue
+		case OpPong:
+			continue
+		case OpClose:
+			c.writeFrame(OpClose, data)
+			return 0, nil, io.EOF
+		case OpText, OpBinary:
+			msgOp, msg = op, data
+		case OpContinuation:
+			if msgOp < 0 {
+				return 0, nil, errorf("unexpected continuation frame")
+			}
+			msg = append(msg, data...)
+		default:
+			return 0, nil, errorf("opcode %d is not supported", op)
+		}
+
+		if len(msg) > maxMessageSize {
+			return 0, nil, errorf("message is too large")
+		} else if fin {
+			return msgOp, msg, nil
+		}
+	}
+}
+
+// Close sends a normal closure and closes the connection
+func (c *Conn) Close() error {
+	c.writeFrame(OpClose, []byte{ 0x03, 0xe8 }) // 1000
+	return c.conn.Close()
+}
@@ -0,0 +1,240 @@
+package websocket
+
+import (
+	"io"
+	"net"
+	"bytes"
+	"bufio"
+	"strings"
+	"testing"
+	"net/http"
+	"net/http/httptest"
+
+	"encoding/binary"
+)
+
+// client is the raw client side of a connection
+type client struct {
+	conn net.Conn
+	r *bufio.Reader
+}
+
+// dial connects to an echo server, errs gets the error ending ReadMessage
+func dial(t *testing.T) (*client, chan error) {
+	errs := make(chan error, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c, err := Upgrade(w, r)
+		if err != nil {
+			return
+		}
+		defer c.conn.Close()
+
+		for {
+			op, msg, err := c.ReadMessage()
+			if err != nil {
+				errs <- err
+				return
+			}
+			c.WriteMessage(op, msg)
+		}
+	}))
+	t.Cleanup(srv.Close)
+
+	conn, err := net.Dial("tcp", srv.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	io.WriteString(conn, "GET / HTTP/1.1\r\nHost: test\r\n" +
+		"Connection: Upgrade\r\nUpgrade: websocket\r\n" +
+		"Sec-WebSocket-Version: 13\r\nSec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\n\r\n")
+
+	c := &client{ conn: conn, r: bufio.NewReader(conn) }
+	res, err := http.ReadResponse(c.r, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the example of RFC 6455
+	if res.StatusCode != http.StatusSwitchingProtocols || res.Header.Get("Sec-WebSocket-Accept") != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		t.Fatalf("handshake: %s %v", res.Status, res.Header)
+	}
+
+	return c, errs
+}
+
+// frame encodes a client frame, masked unless mask is nil
+func frame(fin bool, op int, data []byte, mask []byte) []byte {
+	b := []byte{ byte(op) }
+	if fin {
+		b[0] |= 0x80
+	}
+
+	var m byte
+	if mask != nil {
+		m = 0x80
+	}
+
+	switch n := len(data); {
+	case n < 126:
+		b = append(b, m | byte(n))
+	case n < 1 << 16:
+		b = append(b, m | 126, byte(n >> 8), byte(n))
+	default:
+		b = append(b, m | 127)
+		b = binary.BigEndian.AppendUint64(b, uint64(n))
+	}
+
+	if mask == nil {
+		return append(b, data...)
+	}
+
+	b = append(b, mask...)
+	for i, v := range data {
+		b = append(b, v ^ mask[i % 4])
+	}
+	return b
+}
+
+func (c *client) send(t *testing.T, frames ...[]byte) {
+	for _, f := range frames {
+		if _, err := c.conn.Write(f); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// read decodes a server frame
+func (c *client) read(t *testing.T) (byte, []byte) {
+	var hdr [2]byte
+	if _, err := io.ReadFull(c.r, hdr[:]); err != nil {
+		t.Fatal(err)
+	}
+
+	if hdr[0] & 0x80 == 0 || hdr[1] & 0x80 != 0 {
+		t.Fatalf("server frame header %x: not final or masked", hdr)
+	}
+
+	n := uint64(hdr[1])
+	switch n {
+	case 126:
+		var ext [2]byte
+		io.ReadFull(c.r, ext[:])
+		n = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		io.ReadFull(c.r, ext[:])
+		n = binary.BigEndian.Uint64(ext[:])
+	}
+
+	data := make([]byte, n)
+	if _, err := io.ReadFull(c.r, data); err != nil {
+		t.Fatal(err)
+	}
+	return hdr[0] & 0x0f, data
+}
+
+var mask = []byte{ 0x37, 0xfa, 0x21, 0x3d }
+
+func TestEcho(t *testing.T) {
+	c, _ := dial(t)
+
+	// 7 bit, 16 bit and 64 bit lengths
+	for _, n := range []int{ 0, 5, 125, 126, 300, 1 << 16, 70000 } {
+		msg := bytes.Repeat([]byte{ 'x' }, n)
+		c.send(t, frame(true, OpText, msg, mask))
+
+		if op, data := c.read(t); op != OpText || !bytes.Equal(data, msg) {
+			t.Errorf("%d bytes: got op %d, %d bytes", n, op, len(data))
+		}
+	}
+}
+
+func TestFragments(t *testing.T) {
+	c, _ := dial(t)
+
+	// a ping between the fragments is answered at once
+	c.send(t,
+		frame(false, OpBinary, []byte("Hel"), mask),
+		frame(true, OpPing, []byte("p"), mask),
+		frame(false, OpContinuation, []byte("lo, "), mask),
+		frame(true, OpContinuation, []byte("world"), mask),
+	)
+
+	if op, data := c.read(t); op != OpPong || string(data) != "p" {
+		t.Errorf("got op %d %q, want a pong", op, data)
+	}
+
+	if op, data := c.read(t); op != OpBinary || string(data) != "Hello, world" {
+		t.Errorf("got op %d %q", op, data)
+	}
+}
+
+func TestReadErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		frame []byte
+		err string
+	}{
+		{ "unmasked", frame(true, OpText, []byte("hi"), nil), "not masked" },
+		{ "continuation first", frame(true, OpContinuation, []byte("hi"), mask), "unexpected continuation" },
+		{ "bad opcode", frame(true, 0x3, nil, mask), "not supported" },
+		{ "too large", frame(true, OpText, make([]byte, maxMessageSize + 1), mask), "too large" },
+		{ "close", frame(true, OpClose, []byte{ 0x03, 0xe8 }, mask), "EOF" },
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, errs := dial(t)
+			c.send(t, tt.frame)
+
+			if err := <-errs; err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("got error %v, want %q", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestCloseEcho(t *testing.T) {
+	c, _ := dial(t)
+	c.send(t, frame(true, OpClose, []byte{ 0x03, 0xe8 }, mask))
+
+	if op, data := c.read(t); op != OpClose || !bytes.Equal(data, []byte{ 0x03, 0xe8 }) {
+		t.Errorf("got op %d %x, want the close echoed", op, data)
+	}
+}
+
+func TestUpgradeErrors(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		Upgrade(w, r)
+	}))
+	defer srv.Close()
+
+	tests := []struct {
+		name string
+		header map[string]string
+		code int
+	}{
+		{ "plain get", nil, http.StatusBadRequest },
+		{ "old version", map[string]string{ "Connection": "keep-alive, Upgrade", "Upgrade": "websocket", "Sec-WebSocket-Version": "8", "Sec-WebSocket-Key": "x" }, http.StatusUpgradeRequired },
+		{ "no key", map[string]string{ "Connection": "Upgrade", "Upgrade": "websocket", "Sec-WebSocket-Version": "13" }, http.StatusBadRequest },
+	}
+
+	for _, tt := range tests {
+		req, _ := http.NewRequest(http.MethodGet, srv.URL, nil)
+		for k, v := range tt.header {
+			req.Header.Set(k, v)
+		}
+
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+
+		if res.StatusCode != tt.code {
+			t.Errorf("%s: got %s, want %d", tt.name, res.Status, tt.code)
+		}
+	}
+}